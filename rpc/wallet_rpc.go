@@ -41,11 +41,34 @@ const (
 	NewTopoheight = "new_topoheight"
 	// When a new transaction (incoming/outgoing/coinbase) is detected
 	NewEntry = "new_entry"
+	// When a new topoheight is detected, wallet height compared to daemon height
+	SyncProgress = "sync_progress"
+	// When the wallet own synced height changes
+	WalletHeight = "wallet_height"
+	// When the wallet is locked or unlocked by its owner
+	WalletLocked = "wallet_locked"
+	// When the available requests of an application drop below the rate limit warning threshold
+	RateLimitWarning = "rate_limit_warning"
+	// When another application connects to the wallet
+	AppConnected = "app_connected"
+	// When another application disconnects from the wallet
+	AppDisconnected = "app_disconnected"
+	// When the daemon endpoint of the wallet is changed
+	DaemonChanged = "daemon_changed"
+	// When the account of the wallet is switched
+	AccountChanged = "account_changed"
 )
 
 type EventNotification struct {
-	Event EventType   `json:"event"`
-	Value interface{} `json:"value"`
+	Event          EventType   `json:"event"`
+	Value          interface{} `json:"value"`
+	SubscriptionID string      `json:"subscription_id,omitempty"` // set by the application when subscribing
+}
+
+type SyncProgressChange struct {
+	WalletHeight uint64 `json:"walletHeight"`
+	DaemonHeight uint64 `json:"daemonHeight"`
+	Synced       bool   `json:"synced"`
 }
 
 type BalanceChange struct {
@@ -258,9 +281,12 @@ type (
 		Receiver        string      `json:"receiver"`
 		DestinationPort uint64      `json:"dstport"`
 		SourcePort      uint64      `json:"srcport"`
+		Offset          uint64      `json:"offset,omitempty"` // entries skipped when paginating
+		Limit           uint64      `json:"limit,omitempty"`  // max entries returned, 0 returns all entries
 	}
 	Get_Transfers_Result struct {
 		Entries []Entry `json:"entries,omitempty"`
+		Total   uint64  `json:"total,omitempty"` // entries matching the filters before pagination
 	}
 )
 
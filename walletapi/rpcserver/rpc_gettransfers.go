@@ -33,7 +33,28 @@ func GetTransfers(ctx context.Context, p rpc.Get_Transfers_Params) (result rpc.G
 
 	w := FromContext(ctx)
 
-	result.Entries = w.wallet.Show_Transfers(p.SCID, p.Coinbase, p.In, p.Out, p.Min_Height, p.Max_Height, p.Sender, p.Receiver, p.DestinationPort, p.SourcePort)
+	entries := w.wallet.Show_Transfers(p.SCID, p.Coinbase, p.In, p.Out, p.Min_Height, p.Max_Height, p.Sender, p.Receiver, p.DestinationPort, p.SourcePort)
+	result.Total = uint64(len(entries))
+	result.Entries = paginateEntries(entries, p.Offset, p.Limit)
 
 	return result, nil
 }
+
+// page of entries starting at offset, all remaining entries if limit is 0
+func paginateEntries(entries []rpc.Entry, offset, limit uint64) []rpc.Entry {
+	total := uint64(len(entries))
+	if offset == 0 && limit == 0 {
+		return entries
+	}
+
+	if offset >= total {
+		return nil
+	}
+
+	end := total
+	if limit > 0 && limit < total-offset {
+		end = offset + limit
+	}
+
+	return entries[offset:end]
+}
@@ -23,6 +23,7 @@ import (
 
 	"github.com/deroproject/derohe/cryptography/crypto"
 	"github.com/deroproject/derohe/rpc"
+	"github.com/deroproject/derohe/walletapi"
 )
 
 func ScInvoke(ctx context.Context, p rpc.SC_Invoke_Params) (result rpc.Transfer_Result, err error) {
@@ -37,26 +38,36 @@ func ScInvoke(ctx context.Context, p rpc.SC_Invoke_Params) (result rpc.Transfer_
 		return result, fmt.Errorf("Wallet is in offline mode")
 	}
 
-	// translate rpc to arguments
+	tp, err := PrepareSCInvoke(w.wallet, p)
+	if err != nil {
+		return result, err
+	}
+
+	//fmt.Printf("transfers %+v\n", tp)
+
+	return Transfer(ctx, tp)
+
+}
+
+// translate rpc to arguments, so the sc invoke params can be used as transfer params
+func PrepareSCInvoke(wallet *walletapi.Wallet_Disk, p rpc.SC_Invoke_Params) (tp rpc.Transfer_Params, err error) {
 
 	//fmt.Printf("incoming transfer params %+v\n", p)
 
 	if p.SC_ID == "" {
-		return result, fmt.Errorf("SCID cannot be empty")
+		return tp, fmt.Errorf("SCID cannot be empty")
 	}
 
 	// if destination is "", we will choose a random address automatically
 
-	var tp rpc.Transfer_Params
-
 	// we must burn this much native currency
 	if p.SC_DERO_Deposit >= 1 {
 
 		var mainscid crypto.Hash
-		random := w.wallet.Random_ring_members(mainscid)
+		random := wallet.Random_ring_members(mainscid)
 
 		if len(random) < 3 {
-			return result, fmt.Errorf("SCID could not obtain ring members")
+			return tp, fmt.Errorf("SCID could not obtain ring members")
 		}
 		tp.Transfers = append(tp.Transfers, rpc.Transfer{Destination: random[0], Amount: 0, Burn: p.SC_DERO_Deposit})
 	}
@@ -68,8 +79,5 @@ func ScInvoke(ctx context.Context, p rpc.SC_Invoke_Params) (result rpc.Transfer_
 	tp.SC_ID = p.SC_ID
 	tp.Ringsize = p.Ringsize
 
-	//fmt.Printf("transfers %+v\n", tp)
-
-	return Transfer(ctx, tp)
-
+	return tp, nil
 }
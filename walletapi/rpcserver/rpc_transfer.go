@@ -43,6 +43,35 @@ func Transfer(ctx context.Context, p rpc.Transfer_Params) (result rpc.Transfer_R
 
 	w := FromContext(ctx)
 
+	if !w.wallet.GetMode() { // if wallet is in online mode, use the fees, provided by the daemon, else we need to use what is provided by the user
+		return result, fmt.Errorf("Wallet is in offline mode")
+	}
+
+	if err = PrepareTransfer(&p); err != nil {
+		return
+	}
+
+	var tx *transaction.Transaction
+	for tries := 0; tries < 2; tries++ {
+		tx, err = w.wallet.TransferPayload0(p.Transfers, p.Ringsize, false, p.SC_RPC, p.Fees, false)
+		if err != nil {
+			w.logger.V(1).Error(err, "Error building tx")
+			return result, err
+		}
+
+		err = w.wallet.SendTransaction(tx)
+		if err == nil {
+			break
+		}
+	}
+
+	// we must return a txid if everything went alright
+	result.TXID = tx.GetHash().String()
+	return result, nil
+}
+
+// translate rpc to arguments, so the transfer params can be used to build a transaction
+func PrepareTransfer(p *rpc.Transfer_Params) (err error) {
 	for _, t := range p.Transfers {
 		_, err = t.Payload_RPC.CheckPack(transaction.PAYLOAD0_LIMIT)
 		if err != nil {
@@ -50,12 +79,6 @@ func Transfer(ctx context.Context, p rpc.Transfer_Params) (result rpc.Transfer_R
 		}
 	}
 
-	if !w.wallet.GetMode() { // if wallet is in online mode, use the fees, provided by the daemon, else we need to use what is provided by the user
-		return result, fmt.Errorf("Wallet is in offline mode")
-	}
-
-	// translate rpc to arguments
-
 	//fmt.Printf("incoming transfer params %+v\n", p)
 
 	if len(p.SC_Code) >= 1 { // decode SC from base64 if possible, since json has limitations
@@ -77,21 +100,5 @@ func Transfer(ctx context.Context, p rpc.Transfer_Params) (result rpc.Transfer_R
 		}
 	}
 
-	var tx *transaction.Transaction
-	for tries := 0; tries < 2; tries++ {
-		tx, err = w.wallet.TransferPayload0(p.Transfers, p.Ringsize, false, p.SC_RPC, p.Fees, false)
-		if err != nil {
-			w.logger.V(1).Error(err, "Error building tx")
-			return result, err
-		}
-
-		err = w.wallet.SendTransaction(tx)
-		if err == nil {
-			break
-		}
-	}
-
-	// we must return a txid if everything went alright
-	result.TXID = tx.GetHash().String()
-	return result, nil
+	return
 }
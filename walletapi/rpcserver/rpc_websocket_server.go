@@ -342,3 +342,8 @@ func NewWalletContext(logger logr.Logger, wallet *walletapi.Wallet_Disk) *Wallet
 		Extra:  make(map[string]interface{}),
 	}
 }
+
+// Wallet served by the context
+func (w *WalletContext) Wallet() *walletapi.Wallet_Disk {
+	return w.wallet
+}
@@ -0,0 +1,323 @@
+// Package client is a Go client for dApps connecting to a wallet XSWD server
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/deroproject/derohe/rpc"
+	"github.com/deroproject/derohe/walletapi/xswd"
+	"github.com/gorilla/websocket"
+)
+
+// Size of each event channel, events are dropped while it is full
+const EventBuffer = 16
+
+// Client is an application connected and accepted by a XSWD server
+type Client struct {
+	conn *websocket.Conn
+	// only one writer at a time on the websocket
+	writeMutex sync.Mutex
+	// last request ID sent
+	id uint64
+	// requests awaiting their response by ID
+	pending map[string]chan response
+	// channels of subscribed events
+	events map[rpc.EventType]chan rpc.EventNotification
+	// closed when the read loop exits, err is then the reason
+	done chan struct{}
+	err  error
+	// mutex for id, pending and events
+	sync.Mutex
+}
+
+type response struct {
+	ID     json.RawMessage `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *jrpc2.Error    `json:"error"`
+}
+
+type request struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      uint64      `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// Connect the application to a XSWD server url (ws://127.0.0.1:44326/xswd),
+// it returns once the application is accepted by the wallet
+func Connect(url string, app xswd.ApplicationData) (*Client, error) {
+	return ConnectWithSigner(url, app, nil)
+}
+
+// Connect the application to a XSWD server url requiring a signature challenge,
+// sign is called with the app ID followed by the challenge and returns the signature of the application
+func ConnectWithSigner(url string, app xswd.ApplicationData, sign func(message []byte) ([]byte, error)) (*Client, error) {
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := authorize(conn, app, sign); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &Client{
+		conn:    conn,
+		pending: make(map[string]chan response),
+		events:  make(map[rpc.EventType]chan rpc.EventNotification),
+		done:    make(chan struct{}),
+	}
+
+	go c.readLoop()
+
+	return c, nil
+}
+
+// Send the application data and wait for the authorization response
+func authorize(conn *websocket.Conn, app xswd.ApplicationData, sign func(message []byte) ([]byte, error)) error {
+	var auth struct {
+		xswd.AuthorizationResponse
+		Challenge string `json:"challenge"`
+	}
+
+	// challenge is sent first if server requires it, before app data is read
+	challenged := false
+	if sign != nil {
+		if err := conn.ReadJSON(&auth); err != nil {
+			return fmt.Errorf("failed to receive challenge: %s", err)
+		}
+
+		if auth.Challenge == "" {
+			return fmt.Errorf("server did not send a challenge")
+		}
+
+		signature, err := sign([]byte(app.Id + auth.Challenge))
+		if err != nil {
+			return fmt.Errorf("failed to sign challenge: %s", err)
+		}
+
+		app.Signature = signature
+		challenged = true
+	}
+
+	if err := conn.WriteJSON(app); err != nil {
+		return fmt.Errorf("failed to send application data: %s", err)
+	}
+
+	auth.Challenge = ""
+	if err := conn.ReadJSON(&auth); err != nil {
+		return fmt.Errorf("failed to receive authorization response: %s", err)
+	}
+
+	// without signer, application is not signed and challenge can be skipped
+	if auth.Challenge != "" && !challenged {
+		if err := conn.ReadJSON(&auth); err != nil {
+			return fmt.Errorf("failed to receive authorization response: %s", err)
+		}
+	}
+
+	if !auth.Accepted {
+		return fmt.Errorf("application not accepted: %s", auth.Message)
+	}
+
+	return nil
+}
+
+// Read all messages from the server and dispatch them to their request or event
+// The connection is closed once an error is read, from the websocket or the session
+func (c *Client) readLoop() {
+	var err error
+	defer func() {
+		c.conn.Close()
+
+		c.Lock()
+		c.err = err
+		for id, ch := range c.pending {
+			close(ch)
+			delete(c.pending, id)
+		}
+		for event, ch := range c.events {
+			close(ch)
+			delete(c.events, event)
+		}
+		c.Unlock()
+
+		close(c.done)
+	}()
+
+	for {
+		var r response
+		if err = c.conn.ReadJSON(&r); err != nil {
+			return
+		}
+
+		// messages without ID are events or errors of the session such as rate limit
+		id := string(r.ID)
+		if id == "" || id == "null" {
+			if r.Error != nil {
+				err = r.Error
+				return
+			}
+
+			var notification rpc.EventNotification
+			if json.Unmarshal(r.Result, &notification) == nil {
+				c.notify(notification)
+			}
+
+			continue
+		}
+
+		c.Lock()
+		ch, ok := c.pending[id]
+		delete(c.pending, id)
+		c.Unlock()
+
+		if ok {
+			ch <- r
+		}
+	}
+}
+
+// Send notification to its event channel without blocking the read loop
+func (c *Client) notify(notification rpc.EventNotification) {
+	c.Lock()
+	defer c.Unlock()
+
+	if ch, ok := c.events[notification.Event]; ok {
+		select {
+		case ch <- notification:
+		default:
+		}
+	}
+}
+
+// Call a method and wait for its result, a *jrpc2.Error is returned if server responds with an error
+func (c *Client) Call(method string, params interface{}) (json.RawMessage, error) {
+	c.Lock()
+	select {
+	case <-c.done:
+		c.Unlock()
+		return nil, c.closedErr()
+	default:
+	}
+
+	c.id++
+	id := c.id
+	ch := make(chan response, 1)
+	c.pending[strconv.FormatUint(id, 10)] = ch
+	c.Unlock()
+
+	c.writeMutex.Lock()
+	err := c.conn.WriteJSON(request{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	c.writeMutex.Unlock()
+	if err != nil {
+		c.Lock()
+		delete(c.pending, strconv.FormatUint(id, 10))
+		c.Unlock()
+		return nil, fmt.Errorf("failed to write %s request: %s", method, err)
+	}
+
+	r, ok := <-ch
+	if !ok {
+		return nil, c.closedErr()
+	}
+
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return r.Result, nil
+}
+
+// Subscribe to an event, the channel is closed when the event is unsubscribed or the client closed
+// An error is returned if the server did not subscribe the session, such as when it is already subscribed
+func (c *Client) Subscribe(event rpc.EventType) (<-chan rpc.EventNotification, error) {
+	ch := make(chan rpc.EventNotification, EventBuffer)
+	c.Lock()
+	if _, ok := c.events[event]; ok {
+		c.Unlock()
+		return nil, fmt.Errorf("event %s already subscribed", event)
+	}
+	c.events[event] = ch
+	c.Unlock()
+
+	result, err := c.Call("Subscribe", xswd.Subscribe_Params{Event: event})
+	if err != nil {
+		c.removeEvent(event)
+		return nil, err
+	}
+
+	var subscribed bool
+	if err := json.Unmarshal(result, &subscribed); err != nil {
+		c.removeEvent(event)
+		return nil, fmt.Errorf("failed to decode Subscribe result: %s", err)
+	}
+
+	if !subscribed {
+		c.removeEvent(event)
+		return nil, fmt.Errorf("event %s not subscribed by the server", event)
+	}
+
+	return ch, nil
+}
+
+// Unsubscribe from an event and close its channel
+func (c *Client) Unsubscribe(event rpc.EventType) error {
+	if _, err := c.Call("Unsubscribe", xswd.Subscribe_Params{Event: event}); err != nil {
+		return err
+	}
+
+	c.removeEvent(event)
+
+	return nil
+}
+
+func (c *Client) removeEvent(event rpc.EventType) {
+	c.Lock()
+	defer c.Unlock()
+
+	if ch, ok := c.events[event]; ok {
+		close(ch)
+		delete(c.events, event)
+	}
+}
+
+// Done is closed when the connection with the server is closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the connection was closed, nil while connected
+func (c *Client) Err() error {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.err
+}
+
+func (c *Client) closedErr() error {
+	if err := c.Err(); err != nil {
+		return fmt.Errorf("connection closed: %s", err)
+	}
+
+	return fmt.Errorf("connection closed")
+}
+
+// Close the connection with the server, nothing is done if it is already closed
+func (c *Client) Close() error {
+	select {
+	case <-c.done:
+		return nil
+	default:
+	}
+
+	err := c.conn.Close()
+	<-c.done
+
+	return err
+}
@@ -0,0 +1,175 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/creachadair/jrpc2/code"
+	"github.com/deroproject/derohe/rpc"
+	"github.com/deroproject/derohe/walletapi"
+	"github.com/deroproject/derohe/walletapi/xswd"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// Port apart from xswd package tests as packages can be tested in parallel
+const testPort = 44327
+
+var testURL = fmt.Sprintf("ws://127.0.0.1:%d/xswd", testPort)
+
+var testApp = xswd.ApplicationData{
+	Id:          "76a16407d9371ebcb57b3009ba7a0e705314e23b7d220df635788d2e88052dab",
+	Name:        "Test Client",
+	Description: "Client application",
+	Url:         "http://testclient.com",
+}
+
+// Test calls, errors and events through the client
+func TestClient(t *testing.T) {
+	wallet, server := testNewServer(t, true)
+
+	c, err := Connect(testURL, testApp)
+	assert.NoErrorf(t, err, "Connect should not error: %s", err)
+	defer c.Close()
+
+	result, err := c.Call("GetAddress", nil)
+	assert.NoErrorf(t, err, "GetAddress should not error: %s", err)
+	var address rpc.GetAddress_Result
+	err = json.Unmarshal(result, &address)
+	assert.NoErrorf(t, err, "Unmarshal GetAddress should not error: %s", err)
+	assert.Equal(t, wallet.GetAddress().String(), address.Address, "Address does not match")
+
+	_, err = c.Call("UnknownMethod", nil)
+	if jrpcErr, ok := err.(*jrpc2.Error); assert.True(t, ok, "Error should be *jrpc2.Error: %T", err) {
+		assert.Equal(t, code.MethodNotFound, jrpcErr.Code, "Error should be %v: %v", code.MethodNotFound, jrpcErr.Code)
+	}
+
+	events, err := c.Subscribe(rpc.NewTopoheight)
+	assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+	_, err = c.Subscribe(rpc.NewTopoheight)
+	assert.Error(t, err, "Subscribe twice should error")
+
+	// session already subscribed by the server
+	_, err = c.Call("Subscribe", xswd.Subscribe_Params{Event: rpc.NewBalance})
+	assert.NoErrorf(t, err, "Subscribe call should not error: %s", err)
+	_, err = c.Subscribe(rpc.NewBalance)
+	assert.Error(t, err, "Subscribe not done by the server should error")
+	c.Lock()
+	assert.NotContains(t, c.events, rpc.EventType(rpc.NewBalance), "Event channel should be dropped")
+	c.Unlock()
+
+	server.BroadcastEvent(rpc.NewTopoheight, 10)
+	select {
+	case notification := <-events:
+		assert.Equal(t, rpc.EventType(rpc.NewTopoheight), notification.Event, "Event does not match")
+		assert.Equal(t, float64(10), notification.Value, "Event value does not match")
+	case <-time.After(time.Second):
+		t.Errorf("Event should be received")
+	}
+
+	err = c.Unsubscribe(rpc.NewTopoheight)
+	assert.NoErrorf(t, err, "Unsubscribe should not error: %s", err)
+	_, open := <-events
+	assert.False(t, open, "Event channel should be closed")
+
+	// Server closing the connection ends the client
+	server.Stop()
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Errorf("Client should be done when server stops")
+	}
+
+	_, err = c.Call("GetAddress", nil)
+	assert.Error(t, err, "Call should error once connection is closed")
+}
+
+// Test an error of the session closes the connection
+func TestClientSessionError(t *testing.T) {
+	_, server := testNewServer(t, true)
+	defer server.Stop()
+
+	c, err := Connect(testURL, testApp)
+	assert.NoErrorf(t, err, "Connect should not error: %s", err)
+	defer c.Close()
+
+	// server responds to an invalid message with an error without ID
+	c.writeMutex.Lock()
+	err = c.conn.WriteMessage(websocket.TextMessage, []byte("{"))
+	c.writeMutex.Unlock()
+	assert.NoErrorf(t, err, "Write should not error: %s", err)
+
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Client should be done on a session error")
+	}
+
+	if jrpcErr, ok := c.Err().(*jrpc2.Error); assert.True(t, ok, "Error should be *jrpc2.Error: %T", c.Err()) {
+		assert.Equal(t, code.ParseError, jrpcErr.Code, "Error should be %v: %v", code.ParseError, jrpcErr.Code)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 0, server.ApplicationCount(), "Application should be disconnected from the server")
+	assert.NoError(t, c.Close(), "Close should not error once done")
+}
+
+// Test application denied or requiring a challenge
+func TestClientConnect(t *testing.T) {
+	t.Run("Denied", func(t *testing.T) {
+		_, server := testNewServer(t, false)
+		defer server.Stop()
+
+		_, err := Connect(testURL, testApp)
+		assert.Error(t, err, "Connect should error when application is denied")
+	})
+
+	t.Run("Challenge", func(t *testing.T) {
+		wallet, server := testNewServer(t, true, xswd.WithSignatureChallenge(true))
+		defer server.Stop()
+
+		// Unsigned application skips the challenge
+		c, err := Connect(testURL, testApp)
+		assert.NoErrorf(t, err, "Connect should not error without signer: %s", err)
+		if c != nil {
+			c.Close()
+		}
+		time.Sleep(50 * time.Millisecond)
+
+		sign := func(message []byte) ([]byte, error) {
+			return wallet.SignData(message), nil
+		}
+
+		c, err = ConnectWithSigner(testURL, testApp, sign)
+		assert.NoErrorf(t, err, "ConnectWithSigner should not error: %s", err)
+		if c != nil {
+			c.Close()
+		}
+	})
+}
+
+// Create a wallet and start XSWD server on testPort allowing all requests
+func testNewServer(t *testing.T, accept bool, opts ...xswd.Option) (*walletapi.Wallet_Disk, *xswd.XSWD) {
+	wallet, err := walletapi.Create_Encrypted_Wallet_Random(filepath.Join(t.TempDir(), "xswd_client_wallet.db"), "xswd")
+	if err != nil {
+		t.Fatalf("Failed to create wallet: %s", err)
+	}
+
+	appHandler := func(app *xswd.ApplicationData) bool { return accept }
+	requestHandler := func(app *xswd.ApplicationData, request *jrpc2.Request) xswd.Permission { return xswd.Allow }
+
+	server := xswd.NewXSWDServer(wallet, appHandler, requestHandler, append(opts, xswd.WithPort(testPort))...)
+
+	// Wait for the server to start
+	time.Sleep(time.Second)
+
+	if !server.IsRunning() {
+		t.Fatalf("Server is not running and should be")
+	}
+
+	return wallet, server
+}
@@ -1,11 +1,16 @@
 package xswd
 
 import (
+	"bytes"
 	"context"
+	"encoding/hex"
 	"fmt"
 	"strings"
 
+	"github.com/deroproject/derohe/config"
+	"github.com/deroproject/derohe/cryptography/crypto"
 	"github.com/deroproject/derohe/rpc"
+	"github.com/deroproject/derohe/transaction"
 	"github.com/deroproject/derohe/walletapi"
 	"github.com/deroproject/derohe/walletapi/rpcserver"
 )
@@ -15,20 +20,116 @@ type HasMethod_Params struct {
 }
 
 type Subscribe_Params struct {
-	Event rpc.EventType `json:"event"`
+	Event          rpc.EventType `json:"event"`
+	ReplayLatest   bool          `json:"replay_latest"`             // send the latest value of the event once subscribed if any
+	SubscriptionID string        `json:"subscription_id,omitempty"` // echoed in each notification of the event
 }
 
 type Signature_Result struct {
 	Signature []byte `json:"signature"`
+	Signer    string `json:"signer"`        // address of the wallet which signed the data
+	Tag       string `json:"tag,omitempty"` // prefix of the signed payload when domain separation is enabled
 }
 
 type CheckSignature_Result struct {
-	Signer  string `json:"signer"`
-	Message string `json:"message"`
+	Signer        string `json:"signer"`
+	Message       string `json:"message"`
+	IsDERONetwork bool   `json:"is_dero_network"` // signer belongs to DERO network
 }
 
 type GetDaemon_Result struct {
 	Endpoint string `json:"endpoint"`
+	Network  string `json:"network"` // empty if daemon is offline
+	Height   uint64 `json:"height"`  // zero if daemon is offline
+}
+
+type GetDaemonStatus_Result struct {
+	Online   bool   `json:"online"`
+	Endpoint string `json:"endpoint"`
+}
+
+type PreviewTransfer_Destination struct {
+	SCID        crypto.Hash `json:"scid"`
+	Destination string      `json:"destination"`
+	Amount      uint64      `json:"amount"`
+	Burn        uint64      `json:"burn"`
+}
+
+type PreviewTransfer_Result struct {
+	Fees         uint64                        `json:"fees"`
+	Amount       uint64                        `json:"amount"` // sum of amount and burn of all transfers, fees excluded
+	Ringsize     uint64                        `json:"ringsize"`
+	Destinations []PreviewTransfer_Destination `json:"destinations"`
+}
+
+type BuildSignedTransfer_Result struct {
+	TXID string `json:"txid"`
+	TX   string `json:"tx"` // hex encoded signed transaction, not broadcasted
+	Fees uint64 `json:"fees"`
+}
+
+type GetRateLimit_Result struct {
+	Limit  float64 `json:"limit"` // requests per second
+	Burst  int     `json:"burst"`
+	Tokens float64 `json:"tokens"` // requests currently available before being disconnected
+}
+
+type UpdateMetadata_Params struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Url         string `json:"url"`
+}
+
+type RequestPermissions_Params struct {
+	Methods []string `json:"methods"`
+}
+
+type RequestPermissions_Result struct {
+	Permissions map[string]Permission `json:"permissions"` // permission applying to each method, Ask if none is stored
+}
+
+type GetPermissions_Result struct {
+	Permissions map[string]PermissionInfo `json:"permissions"` // stored permissions, methods not listed will Ask
+}
+
+type GetVersion_Result struct {
+	Version      string   `json:"version"`
+	Capabilities []string `json:"capabilities"`
+}
+
+type GetNetworkInfo_Result struct {
+	Mainnet        bool    `json:"mainnet"`
+	Ringsize       int     `json:"ringsize"`        // default ring size of transfers
+	FeesMultiplier float32 `json:"fees_multiplier"` // multiplier applied to the base fees
+	FeePerKB       uint64  `json:"fee_per_kb"`      // base fees per KB of transaction
+}
+
+type DecodeAddress_Params struct {
+	Address string `json:"address"`
+}
+
+type DecodeAddress_Result struct {
+	Address     string        `json:"address"` // base address without arguments
+	Mainnet     bool          `json:"mainnet"`
+	Integrated  bool          `json:"integrated"`
+	Payload_RPC rpc.Arguments `json:"payload_rpc"`
+}
+
+type VerifySignatureFrom_Params struct {
+	Signature []byte `json:"signature"`
+	Address   string `json:"address"` // expected signer
+}
+
+type VerifySignatureFrom_Result struct {
+	Valid   bool   `json:"valid"`
+	Message string `json:"message"`
+}
+
+type GetTransferPayload_Result struct {
+	SCID            crypto.Hash   `json:"scid"`
+	DestinationPort uint64        `json:"dstport"`
+	SourcePort      uint64        `json:"srcport"`
+	Payload_RPC     rpc.Arguments `json:"payload_rpc"`
 }
 
 func HasMethod(ctx context.Context, p HasMethod_Params) bool {
@@ -38,30 +139,60 @@ func HasMethod(ctx context.Context, p HasMethod_Params) bool {
 	return ok
 }
 
-func Subscribe(ctx context.Context, p Subscribe_Params) bool {
+func Subscribe(ctx context.Context, p Subscribe_Params) (bool, error) {
 	w := rpcserver.FromContext(ctx)
+	xswd := w.Extra["xswd"].(*XSWD)
 	app := w.Extra["app_data"].(*ApplicationData)
 
+	if !xswd.IsEventSupported(p.Event) {
+		return false, fmt.Errorf("event %q is not supported", p.Event)
+	}
+
+	if len(p.SubscriptionID) > 64 {
+		return false, fmt.Errorf("subscription_id is limited to 64 characters")
+	}
+
+	// events are read by the session loop for RateLimitWarning
+	xswd.Lock()
 	_, ok := app.RegisteredEvents[p.Event]
 	if ok {
-		return false
+		xswd.Unlock()
+		return false, nil
+	}
+
+	if len(app.RegisteredEvents) >= xswd.maxSubscriptions {
+		xswd.Unlock()
+		return false, fmt.Errorf("subscriptions limit of %d events reached", xswd.maxSubscriptions)
 	}
 
 	app.RegisteredEvents[p.Event] = true
+	if p.SubscriptionID != "" {
+		app.subscriptions[p.Event] = p.SubscriptionID
+	}
+	xswd.Unlock()
 
-	return true
+	if p.ReplayLatest {
+		xswd.queueReplay(app, p.Event)
+	}
+
+	return true, nil
 }
 
 func Unsubscribe(ctx context.Context, p Subscribe_Params) bool {
 	w := rpcserver.FromContext(ctx)
+	xswd := w.Extra["xswd"].(*XSWD)
 	app := w.Extra["app_data"].(*ApplicationData)
 
+	xswd.Lock()
+	defer xswd.Unlock()
+
 	_, ok := app.RegisteredEvents[p.Event]
 	if !ok {
 		return false
 	}
 
 	delete(app.RegisteredEvents, p.Event)
+	delete(app.subscriptions, p.Event)
 
 	return true
 }
@@ -70,45 +201,395 @@ func Unsubscribe(ctx context.Context, p Subscribe_Params) bool {
 func SignData(ctx context.Context, p []byte) (result Signature_Result, err error) {
 	w := rpcserver.FromContext(ctx)
 	xswd := w.Extra["xswd"].(*XSWD)
-	if xswd.wallet == nil {
+	if w.Wallet() == nil {
 		err = fmt.Errorf("XSWD could not sign data")
 		return
 	}
 
-	result.Signature = xswd.wallet.SignData(p)
+	// payload is prefixed so it can't be a message meaningful outside of XSWD
+	if xswd.signDomain {
+		app := w.Extra["app_data"].(*ApplicationData)
+		result.Tag = SignDomainTag(app.Id)
+		p = append([]byte(result.Tag), p...)
+	}
+
+	result.Signature = w.Wallet().SignData(p)
+	// signer is embedded in the signed message, returning it reveals nothing more
+	result.Signer = w.Wallet().GetAddress().String()
 
 	return
 }
 
+// SignDomainTag prefixing the data signed by an application when domain separation is enabled,
+// verifiers can check a signed message starts with it
+func SignDomainTag(app_id string) string {
+	return fmt.Sprintf("XSWD:%s:", strings.ToLower(app_id))
+}
+
 // CheckSignature of DERO signed message
 func CheckSignature(ctx context.Context, p []byte) (result CheckSignature_Result, err error) {
 	w := rpcserver.FromContext(ctx)
-	xswd := w.Extra["xswd"].(*XSWD)
-	if xswd.wallet == nil {
+	if w.Wallet() == nil {
 		err = fmt.Errorf("XSWD could not check signature")
 		return
 	}
 
 	var address *rpc.Address
 	var messageBytes []byte
-	address, messageBytes, err = xswd.wallet.CheckSignature(p)
+	address, messageBytes, err = w.Wallet().CheckSignature(p)
 	if err != nil {
 		return
 	}
 
 	result.Signer = address.String()
+	result.IsDERONetwork = address.IsDERONetwork()
 	result.Message = strings.TrimSpace(string(messageBytes))
 
 	return
 }
 
-// GetDaemon endpoint from connected wallet
+// GetDaemon endpoint from connected wallet, with the daemon network and height when it is online
 func GetDaemon(ctx context.Context) (result GetDaemon_Result, err error) {
-	if walletapi.Daemon_Endpoint_Active != "" {
-		result.Endpoint = walletapi.Daemon_Endpoint_Active
-	} else {
+	w := rpcserver.FromContext(ctx)
+	xswd := w.Extra["xswd"].(*XSWD)
+	if w.Wallet() == nil {
 		err = fmt.Errorf("XSWD could not get daemon endpoint from wallet")
+		return
+	}
+
+	result.Endpoint = xswd.DaemonEndpoint()
+
+	if xswd.daemon.IsOnline() {
+		var info rpc.GetInfo_Result
+		response, err := xswd.callDaemon(ctx, "DERO.GetInfo", nil)
+		if err == nil {
+			err = response.UnmarshalResult(&info)
+		}
+
+		if err != nil {
+			xswd.logger.V(1).Error(err, "Error while getting daemon info")
+			return result, nil
+		}
+
+		result.Network = info.Network
+		result.Height = uint64(info.Height)
+	}
+
+	return
+}
+
+// GetDaemonStatus of connected wallet, answered even if daemon is offline
+func GetDaemonStatus(ctx context.Context) (result GetDaemonStatus_Result, err error) {
+	w := rpcserver.FromContext(ctx)
+	xswd := w.Extra["xswd"].(*XSWD)
+	if w.Wallet() == nil {
+		err = fmt.Errorf("XSWD could not get daemon status from wallet")
+		return
+	}
+
+	result.Online = xswd.daemon.IsOnline()
+	result.Endpoint = xswd.DaemonEndpoint()
+
+	return
+}
+
+// PreviewTransfer builds the transfer like transfer method would but never broadcasts it,
+// so an application can show what the transfer will cost before requesting it
+func PreviewTransfer(ctx context.Context, p rpc.Transfer_Params) (result PreviewTransfer_Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace r %s", r)
+		}
+	}()
+
+	w := rpcserver.FromContext(ctx)
+	if w.Wallet() == nil {
+		err = fmt.Errorf("XSWD could not preview transfer")
+		return
+	}
+
+	if !w.Wallet().GetMode() {
+		err = fmt.Errorf("Wallet is in offline mode")
+		return
+	}
+
+	return previewTransfer(w.Wallet(), p)
+}
+
+// PreviewSCInvoke builds the sc invoke transaction without broadcasting it,
+// so the application can estimate its fees and validate it before calling scinvoke
+func PreviewSCInvoke(ctx context.Context, p rpc.SC_Invoke_Params) (result PreviewTransfer_Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace r %s", r)
+		}
+	}()
+
+	w := rpcserver.FromContext(ctx)
+	if w.Wallet() == nil {
+		err = fmt.Errorf("XSWD could not preview sc invoke")
+		return
+	}
+
+	if !w.Wallet().GetMode() {
+		err = fmt.Errorf("Wallet is in offline mode")
+		return
+	}
+
+	var tp rpc.Transfer_Params
+	if tp, err = rpcserver.PrepareSCInvoke(w.Wallet(), p); err != nil {
+		return
+	}
+
+	return previewTransfer(w.Wallet(), tp)
+}
+
+// Build the transaction of transfer params and summarize it, the transaction is never sent
+func previewTransfer(wallet *walletapi.Wallet_Disk, p rpc.Transfer_Params) (result PreviewTransfer_Result, err error) {
+	if err = rpcserver.PrepareTransfer(&p); err != nil {
+		return
+	}
+
+	var tx *transaction.Transaction
+	tx, err = wallet.TransferPayload0(p.Transfers, p.Ringsize, false, p.SC_RPC, p.Fees, false)
+	if err != nil {
+		return
+	}
+
+	result.Fees = tx.Fees()
+	if len(tx.Payloads) > 0 {
+		result.Ringsize = tx.Payloads[0].Statement.RingSize
+	}
+
+	result.Destinations = make([]PreviewTransfer_Destination, 0, len(p.Transfers))
+	for _, t := range p.Transfers {
+		result.Amount += t.Amount + t.Burn
+		result.Destinations = append(result.Destinations, PreviewTransfer_Destination{SCID: t.SCID, Destination: t.Destination, Amount: t.Amount, Burn: t.Burn})
+	}
+
+	return
+}
+
+// BuildSignedTransfer builds and signs a transaction without broadcasting it,
+// so it can be approved offline and broadcasted later by the application
+func BuildSignedTransfer(ctx context.Context, p rpc.Transfer_Params) (result BuildSignedTransfer_Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic occured. stack trace r %s", r)
+		}
+	}()
+
+	w := rpcserver.FromContext(ctx)
+	if w.Wallet() == nil {
+		err = fmt.Errorf("XSWD could not build transfer")
+		return
+	}
+
+	if !w.Wallet().GetMode() {
+		err = fmt.Errorf("Wallet is in offline mode")
+		return
+	}
+
+	if err = rpcserver.PrepareTransfer(&p); err != nil {
+		return
+	}
+
+	var tx *transaction.Transaction
+	tx, err = w.Wallet().TransferPayload0(p.Transfers, p.Ringsize, false, p.SC_RPC, p.Fees, false)
+	if err != nil {
+		return
+	}
+
+	result.TXID = tx.GetHash().String()
+	result.TX = hex.EncodeToString(tx.Serialize())
+	result.Fees = tx.Fees()
+
+	return
+}
+
+// GetRateLimit of the application, so it can throttle itself before being disconnected
+func GetRateLimit(ctx context.Context) (result GetRateLimit_Result, err error) {
+	w := rpcserver.FromContext(ctx)
+	app := w.Extra["app_data"].(*ApplicationData)
+	if app.limiter == nil {
+		err = fmt.Errorf("XSWD could not get rate limit of application")
+		return
+	}
+
+	result.Limit = float64(app.limiter.Limit())
+	result.Burst = app.limiter.Burst()
+	result.Tokens = app.limiter.Tokens()
+
+	return
+}
+
+// UpdateMetadata of the application without reconnecting, empty values are left unchanged
+func UpdateMetadata(ctx context.Context, p UpdateMetadata_Params) (bool, error) {
+	w := rpcserver.FromContext(ctx)
+	xswd := w.Extra["xswd"].(*XSWD)
+	app := w.Extra["app_data"].(*ApplicationData)
+
+	if err := xswd.UpdateApplicationMetadata(app, p.Name, p.Description, p.Url); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RequestPermissions of several methods at once so the application doesn't have to call each of them to be prompted
+func RequestPermissions(ctx context.Context, p RequestPermissions_Params) (result RequestPermissions_Result, err error) {
+	w := rpcserver.FromContext(ctx)
+	xswd := w.Extra["xswd"].(*XSWD)
+	app := w.Extra["app_data"].(*ApplicationData)
+
+	result.Permissions, err = xswd.requestPermissions(ctx, app, p.Methods)
+
+	return
+}
+
+// GetPermissions stored for the application and if they survive a restart or only last the session
+func GetPermissions(ctx context.Context) GetPermissions_Result {
+	w := rpcserver.FromContext(ctx)
+	xswd := w.Extra["xswd"].(*XSWD)
+	app := w.Extra["app_data"].(*ApplicationData)
+
+	return GetPermissions_Result{Permissions: xswd.permissionsInfo(app)}
+}
+
+// GetVersion of the XSWD protocol and the capabilities of the server so an application can detect features
+func GetVersion(ctx context.Context) GetVersion_Result {
+	w := rpcserver.FromContext(ctx)
+	xswd := w.Extra["xswd"].(*XSWD)
+
+	return GetVersion_Result{Version: ProtocolVersion, Capabilities: xswd.Capabilities()}
+}
+
+// GetNetworkInfo returns the network and transfer defaults of the wallet,
+// so an application doesn't have to guess Fees and Ringsize of its transfers
+func GetNetworkInfo(ctx context.Context) (result GetNetworkInfo_Result, err error) {
+	w := rpcserver.FromContext(ctx)
+	if w.Wallet() == nil {
+		err = fmt.Errorf("XSWD could not get network info from wallet")
+		return
+	}
+
+	result.Mainnet = w.Wallet().GetNetwork()
+	result.Ringsize = w.Wallet().GetRingSize()
+	result.FeesMultiplier = w.Wallet().GetFeeMultiplier()
+	result.FeePerKB = config.FEE_PER_KB
+
+	return
+}
+
+// IsRegistered returns if the wallet is registered on-chain, so an application
+// gating its UI on registration doesn't have to request GetAddress permission
+func IsRegistered(ctx context.Context) (bool, error) {
+	w := rpcserver.FromContext(ctx)
+	if w.Wallet() == nil {
+		return false, fmt.Errorf("XSWD could not get registration from wallet")
+	}
+
+	return w.Wallet().IsRegistered(), nil
+}
+
+// Ping lets an application check the server is alive, it is answered even while the application is paused
+func Ping(ctx context.Context) string {
+	return "Pong"
+}
+
+// Disconnect the application once this request is answered
+func Disconnect(ctx context.Context) bool {
+	w := rpcserver.FromContext(ctx)
+	app := w.Extra["app_data"].(*ApplicationData)
+	app.disconnect = true
+
+	return true
+}
+
+// DecodeAddress into its base address and the arguments embedded if it is an integrated address
+func DecodeAddress(ctx context.Context, p DecodeAddress_Params) (result DecodeAddress_Result, err error) {
+	if p.Address == "" {
+		err = fmt.Errorf("Could not find address as parameter")
+		return
+	}
+
+	var addr *rpc.Address
+	addr, err = rpc.NewAddress(p.Address)
+	if err != nil {
+		err = fmt.Errorf("Error parsing address err %s", err)
+		return
+	}
+
+	result.Address = addr.BaseAddress().String()
+	result.Mainnet = addr.IsMainnet()
+	result.Integrated = addr.IsIntegratedAddress()
+	result.Payload_RPC = addr.Arguments
+
+	return
+}
+
+// VerifySignatureFrom checks that DERO signed message was signed by the expected address
+func VerifySignatureFrom(ctx context.Context, p VerifySignatureFrom_Params) (result VerifySignatureFrom_Result, err error) {
+	w := rpcserver.FromContext(ctx)
+	if w.Wallet() == nil {
+		err = fmt.Errorf("XSWD could not verify signature")
+		return
 	}
 
+	var expected *rpc.Address
+	expected, err = rpc.NewAddress(p.Address)
+	if err != nil {
+		err = fmt.Errorf("Error parsing address err %s", err)
+		return
+	}
+
+	// invalid signature is not an error, it is not valid
+	signer, messageBytes, serr := w.Wallet().CheckSignature(p.Signature)
+	if serr != nil {
+		return
+	}
+
+	result.Valid = bytes.Equal(signer.Compressed(), expected.Compressed())
+	result.Message = strings.TrimSpace(string(messageBytes))
+
+	return
+}
+
+// GetTransferPayload decodes the payload arguments of the transfer with TXID,
+// such as its comment and destination port
+func GetTransferPayload(ctx context.Context, p rpc.Get_Transfer_By_TXID_Params) (result GetTransferPayload_Result, err error) {
+	w := rpcserver.FromContext(ctx)
+	if w.Wallet() == nil {
+		err = fmt.Errorf("XSWD could not get transfer payload")
+		return
+	}
+
+	var entry rpc.Entry
+	result.SCID, entry = w.Wallet().Get_Payments_TXID(p.SCID, p.TXID)
+	if entry.Height == 0 {
+		err = fmt.Errorf("Transaction not found. TXID %s", p.TXID)
+		return
+	}
+
+	if entry.Coinbase || entry.PayloadType != 0 {
+		err = fmt.Errorf("Transaction %s has no payload arguments", p.TXID)
+		return
+	}
+
+	if entry.PayloadError != "" {
+		err = fmt.Errorf("Transaction %s payload could not be decoded: %s", p.TXID, entry.PayloadError)
+		return
+	}
+
+	args, perr := entry.ProcessPayload()
+	if perr != nil {
+		err = fmt.Errorf("Transaction %s payload could not be decoded: %s", p.TXID, perr)
+		return
+	}
+
+	result.DestinationPort = entry.DestinationPort
+	result.SourcePort = entry.SourcePort
+	result.Payload_RPC = args
+
 	return
 }
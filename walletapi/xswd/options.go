@@ -0,0 +1,504 @@
+package xswd
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/deroproject/derohe/rpc"
+	"github.com/deroproject/derohe/walletapi"
+	"github.com/deroproject/derohe/walletapi/rpcserver"
+	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+)
+
+// Option configures the XSWD server when passed to NewXSWDServer
+type Option func(*XSWD)
+
+// Default rate limit applied to each application requests,
+// sustained rate of 10 requests per second with bursts up to 20 requests
+const (
+	DefaultRateLimit rate.Limit = 10.0
+	DefaultRateBurst int        = 20
+)
+
+// Default max duration of a custom method handler before it is cancelled
+const DefaultHandlerTimeout = time.Minute
+
+// Default max events an application can subscribe to
+const DefaultMaxSubscriptions = 16
+
+// Default max requests handled concurrently across all applications
+const DefaultMaxConcurrentRequests = 64
+
+// Default max requests in flight of an application, waiting on a worker or being handled
+const DefaultMaxApplicationRequests = 16
+
+// Default max method handlers run concurrently, requests of a same session are always handled one at a time
+const DefaultMaxConcurrentHandlers = 4
+
+// Default namespace of the methods registered with SetNamespacedMethod
+const DefaultMethodNamespace = "app."
+
+// Default host XSWD listens on, only local applications can connect
+const DefaultHost = "127.0.0.1"
+
+// Default max duration a new session has to send its application data
+const DefaultHandshakeTimeout = 10 * time.Second
+
+// Default size of the queues of requests and registrations waiting to be handled
+const DefaultQueueSize = 64
+
+// Default noStore methods, xswd methods won't store AlwaysAllow permission
+var DefaultNoStore = []string{"Subscribe", "SignData", "CheckSignature", "GetDaemon", "GetDaemonStatus", "DecodeAddress", "VerifySignatureFrom", "query_key", "QueryKey", "GetVersion", "GetNetworkInfo", "Ping", "GetPermissions", "IsRegistered"}
+
+// Default window of the transfer limit set with WithTransferLimit
+const DefaultTransferWindow = time.Hour
+
+// Default period over which the grace burst set with WithRateLimitGrace decays
+const DefaultRateLimitGracePeriod = 5 * time.Second
+
+// Default available requests below which an application subscribed to RateLimitWarning is warned
+const DefaultRateLimitWarning = 5.0
+
+// Default methods not counted by the rate limit, so an application managing its subscriptions isn't disconnected,
+// they are counted by the cheaper exempt rate limit instead
+var DefaultRateLimitExempt = []string{"Subscribe", "Unsubscribe"}
+
+// Default rate limit applied to the exempt methods requests of each application,
+// sustained rate of 2 requests per second with bursts up to 32 requests
+const (
+	DefaultExemptRateLimit rate.Limit = 2.0
+	DefaultExemptRateBurst int        = 32
+)
+
+// Default URL schemes an application Url can use
+var DefaultURLSchemes = []string{"http", "https"}
+
+// Default methods of the daemon, a DERO. method not listed is reported as not found when the daemon is offline
+var DefaultDaemonMethods = []string{"DERO.Echo", "DERO.Ping", "DERO.GetInfo", "DERO.GetBlock", "DERO.GetBlockHeaderByTopoHeight", "DERO.GetBlockHeaderByHash", "DERO.GetTxPool", "DERO.GetRandomAddress", "DERO.GetTransaction", "DERO.SendRawTransaction", "DERO.SubmitBlock", "DERO.GetHeight", "DERO.GetBlockCount", "DERO.GetLastBlockHeader", "DERO.GetBlockTemplate", "DERO.GetEncryptedBalance", "DERO.GetSC", "DERO.GetGasEstimate", "DERO.NameToAddress"}
+
+// Default methods coalesced by WithCoalescing, expensive read-only wallet queries
+var DefaultCoalesceMethods = []string{"getbalance", "GetBalance", "getheight", "GetHeight", "get_transfer_by_txid", "GetTransferbyTXID", "get_transfers", "GetTransfers"}
+
+// WithPort sets the port XSWD server will listen on
+// Production should always use XSWD_PORT as its a way to identify XSWD
+func WithPort(port int) Option {
+	return func(x *XSWD) {
+		x.port = port
+	}
+}
+
+// WithHost sets the host XSWD server will listen on, "" listens on all interfaces
+// and exposes the wallet to the network
+func WithHost(host string) Option {
+	return func(x *XSWD) {
+		x.host = host
+	}
+}
+
+// WithForceAsk sets if all permissions requested upon initial connection should default to Ask
+func WithForceAsk(forceAsk bool) Option {
+	return func(x *XSWD) {
+		x.forceAsk = forceAsk
+	}
+}
+
+// WithNoStore replaces the methods which won't store AlwaysAllow permission
+func WithNoStore(methods ...string) Option {
+	return func(x *XSWD) {
+		x.noStore = methods
+	}
+}
+
+// WithRateLimit sets the requests rate limit and burst of each application,
+// limit is the sustained requests per second refilling the bucket and burst is its size,
+// the max requests an application can send at once. rate.Inf disables the limit
+func WithRateLimit(limit rate.Limit, burst int) Option {
+	return func(x *XSWD) {
+		x.rateLimit = limit
+		x.rateBurst = burst
+	}
+}
+
+// WithRateLimitExempt replaces the methods not counted by the rate limit,
+// they are counted by the exempt rate limit set with WithExemptRateLimit
+func WithRateLimitExempt(methods ...string) Option {
+	return func(x *XSWD) {
+		x.rateExempt = methods
+	}
+}
+
+// WithExemptRateLimit sets the rate limit and burst of each application for the methods set with WithRateLimitExempt,
+// an application exceeding it is disconnected as with the requests rate limit. rate.Inf disables the limit
+func WithExemptRateLimit(limit rate.Limit, burst int) Option {
+	return func(x *XSWD) {
+		x.exemptRateLimit = limit
+		x.exemptRateBurst = burst
+	}
+}
+
+// WithSignatureChallenge sets if a challenge is sent to each session before it sends its ApplicationData,
+// a signed application must then sign its ID followed by the challenge, preventing signature replay
+func WithSignatureChallenge(challenge bool) Option {
+	return func(x *XSWD) {
+		x.challenge = challenge
+	}
+}
+
+// WithOnRequest sets an audit callback invoked after each request resolved, including denied,
+// daemon proxied and method not found requests, with the permission applied and the error returned to the app if any
+func WithOnRequest(onRequest func(app *ApplicationData, method string, permission Permission, err error)) Option {
+	return func(x *XSWD) {
+		x.onRequest = onRequest
+	}
+}
+
+// WithOnDisconnect sets a callback invoked when an accepted application is disconnected and the reason why
+func WithOnDisconnect(onDisconnect func(app *ApplicationData, reason DisconnectReason)) Option {
+	return func(x *XSWD) {
+		x.onDisconnect = onDisconnect
+	}
+}
+
+// WithOnPermissionChange sets a callback invoked when a permission of an application is stored or revoked,
+// a revoked permission is reported as Ask
+func WithOnPermissionChange(onPermissionChange func(appID, method string, perm Permission)) Option {
+	return func(x *XSWD) {
+		x.onPermissionChange = onPermissionChange
+	}
+}
+
+// WithSignDomain sets if data signed with SignData is prefixed with the SignDomainTag of the application,
+// so an application can't get a signature of a message meaningful outside of XSWD
+func WithSignDomain(enabled bool) Option {
+	return func(x *XSWD) {
+		x.signDomain = enabled
+	}
+}
+
+// WithURLSchemes replaces the URL schemes an application Url can use, such as only https or an app specific scheme
+func WithURLSchemes(schemes ...string) Option {
+	return func(x *XSWD) {
+		x.urlSchemes = schemes
+	}
+}
+
+// WithUniqueURL sets if an application is rejected when its Url is already used by another connected application,
+// disabled by default so multiple instances of a dApp can connect
+func WithUniqueURL(unique bool) Option {
+	return func(x *XSWD) {
+		x.uniqueURL = unique
+	}
+}
+
+// WithLocalURLCheck sets if an application from a remote origin is rejected when its Url points to localhost,
+// so a remote dApp can't present itself as a local one. Applications without origin are not checked
+func WithLocalURLCheck(check bool) Option {
+	return func(x *XSWD) {
+		x.localURL = check
+	}
+}
+
+// WithHandlerTimeout sets the max duration of method handlers before they are cancelled with DeadlineExceeded, 0 disables it
+// if methods are passed the timeout only applies to them, otherwise it replaces the default timeout of custom methods
+// Wallet and xswd methods are only timed out when passed, a transfer may still be sent once timed out.
+// RequestPermissions is never timed out as it waits on the user
+func WithHandlerTimeout(timeout time.Duration, methods ...string) Option {
+	return func(x *XSWD) {
+		if len(methods) == 0 {
+			x.handlerTimeout = timeout
+			return
+		}
+
+		for _, m := range methods {
+			x.methodTimeouts[m] = timeout
+		}
+	}
+}
+
+// WithMaxSubscriptions sets the max events an application can subscribe to
+func WithMaxSubscriptions(max int) Option {
+	return func(x *XSWD) {
+		x.maxSubscriptions = max
+	}
+}
+
+// WithTrustedProxy sets if XSWD runs behind a trusted reverse proxy, X-Forwarded-Origin is then compared
+// to the application Url instead of Origin and X-Forwarded-For is used as remote address
+func WithTrustedProxy(trusted bool) Option {
+	return func(x *XSWD) {
+		x.trustedProxy = trusted
+	}
+}
+
+// WithMaxApplications sets the max number of connected applications, 0 if unlimited
+func WithMaxApplications(max int) Option {
+	return func(x *XSWD) {
+		x.maxApplications = max
+	}
+}
+
+// WithExcludeOrigin sets if an event caused by an application, such as the NewEntry of its transfer, is not broadcasted back to it
+func WithExcludeOrigin(exclude bool) Option {
+	return func(x *XSWD) {
+		x.excludeOrigin = exclude
+	}
+}
+
+// WithTemplateHandler replaces appHandler by a handler also returning the name of
+// the permissions template selected by the user, empty if none, see DefineTemplate
+func WithTemplateHandler(handler func(app *ApplicationData) (accepted bool, template string)) Option {
+	return func(x *XSWD) {
+		x.templateHandler = handler
+	}
+}
+
+// WithMaxConcurrentRequests sets the max requests handled concurrently across all applications,
+// excess requests are queued until one completes, 0 if unlimited
+func WithMaxConcurrentRequests(max int) Option {
+	return func(x *XSWD) {
+		x.maxConcurrent = max
+	}
+}
+
+// WithMaxApplicationRequests sets the max requests in flight of an application across its sessions,
+// further ones are rejected with Cancelled until one completes, 0 if unlimited
+// It should be below the max concurrent requests so an application can't hold every worker
+func WithMaxApplicationRequests(max int) Option {
+	return func(x *XSWD) {
+		x.maxAppRequests = max
+	}
+}
+
+// WithWallet registers an additional wallet by name which applications can target with their Wallet field,
+// an empty name is the default wallet passed to NewXSWDServer and is ignored
+func WithWallet(name string, wallet *walletapi.Wallet_Disk) Option {
+	return func(x *XSWD) {
+		if name == "" || wallet == nil {
+			return
+		}
+
+		x.wallets[name] = rpcserver.NewWalletContext(x.logger, wallet)
+	}
+}
+
+// WithPermissionsHandler sets a batch variant of requestHandler used when an application requests
+// the permissions of several methods with RequestPermissions, requestHandler is called for each method otherwise
+func WithPermissionsHandler(handler func(app *ApplicationData, methods []string) map[string]Permission) Option {
+	return func(x *XSWD) {
+		x.permissionsHandler = handler
+	}
+}
+
+// WithRootHandler sets the handler of the root path, see SetRootHandler
+func WithRootHandler(handler http.HandlerFunc) Option {
+	return func(x *XSWD) {
+		if handler != nil {
+			x.rootHandler = handler
+		}
+	}
+}
+
+// WithOriginPolicy sets how an application Url is compared to the origin of its session, OriginStrict by default
+func WithOriginPolicy(policy OriginPolicy) Option {
+	return func(x *XSWD) {
+		x.originPolicy = policy
+	}
+}
+
+// WithRateLimitWarning sets the available requests below which RateLimitWarning is sent
+// to an application subscribed to it, 0 disables the warning
+func WithRateLimitWarning(threshold float64) Option {
+	return func(x *XSWD) {
+		x.rateWarning = threshold
+	}
+}
+
+// WithUTF8Metadata sets if application names and descriptions can be UTF-8 instead of ASCII only,
+// they are then limited to 255 characters and can't contain control characters
+func WithUTF8Metadata(allow bool) Option {
+	return func(x *XSWD) {
+		x.allowUTF8 = allow
+	}
+}
+
+// WithCustomEvents registers events defined by the host which applications can subscribe to,
+// they are broadcasted with BroadcastCustomEvent and can't replace events of the server
+func WithCustomEvents(names ...string) Option {
+	return func(x *XSWD) {
+		for _, name := range names {
+			if name != "" {
+				x.customEvents[rpc.EventType(name)] = true
+			}
+		}
+	}
+}
+
+// WithCoalescing enables sharing the result of a request with the identical requests (method and params)
+// sent by the same application while it is in flight, DefaultCoalesceMethods are used if no methods are passed
+// Methods with side effects such as transfer or SignData are never coalesced
+func WithCoalescing(methods ...string) Option {
+	return func(x *XSWD) {
+		if len(methods) == 0 {
+			methods = DefaultCoalesceMethods
+		}
+
+		x.coalesce = make(map[string]bool, len(methods))
+		for _, method := range methods {
+			if isMutatingMethod(method) {
+				x.logger.Info("Method with side effects can't be coalesced", "method", method)
+				continue
+			}
+
+			x.coalesce[method] = true
+		}
+	}
+}
+
+// WithAuthorizationMessages sets a provider replacing the message of each AuthorizationResponse such as to localize it,
+// it receives the reason, the failed ValidationStep if the application data is invalid and the default message
+func WithAuthorizationMessages(provider func(reason AuthorizationReason, step ValidationStep, message string) string) Option {
+	return func(x *XSWD) {
+		x.authorizationMessage = provider
+	}
+}
+
+// WithQueueSize sets how many requests and registrations can be queued while the server is busy,
+// 0 makes sessions wait until each message is taken
+func WithQueueSize(size int) Option {
+	return func(x *XSWD) {
+		if size >= 0 {
+			x.queueSize = size
+		}
+	}
+}
+
+// WithHandshakeTimeout sets how long a new session has to send its application data before it is closed,
+// 0 waits without limit
+func WithHandshakeTimeout(timeout time.Duration) Option {
+	return func(x *XSWD) {
+		if timeout >= 0 {
+			x.handshakeTimeout = timeout
+		}
+	}
+}
+
+// WithDaemonClient sets the client called for DERO. requests instead of the daemon of the wallet,
+// such as a fake daemon in tests
+func WithDaemonClient(client DaemonClient) Option {
+	return func(x *XSWD) {
+		if client != nil {
+			x.daemon = client
+		}
+	}
+}
+
+// WithMethodNamespace sets the namespace of the methods registered with SetNamespacedMethod,
+// a trailing dot is added if missing and the DERO. namespace of daemon methods is ignored
+func WithMethodNamespace(namespace string) Option {
+	return func(x *XSWD) {
+		if namespace == "" {
+			return
+		}
+
+		if !strings.HasSuffix(namespace, ".") {
+			namespace += "."
+		}
+
+		if namespace != "DERO." {
+			x.namespace = namespace
+		}
+	}
+}
+
+// WithMaxConcurrentHandlers sets the max method handlers run concurrently across applications,
+// sessions waiting for a handler are served in turn so a busy application can't starve the others, 0 if unlimited.
+// A request takes a handler once its permission is resolved, requests waiting on the user don't hold one
+func WithMaxConcurrentHandlers(max int) Option {
+	return func(x *XSWD) {
+		if max >= 0 {
+			x.maxHandlers = max
+		}
+	}
+}
+
+// WithLogger sets the logger of XSWD and its wallet contexts instead of the XSWD named global logger,
+// so the host can filter its verbosity independently
+func WithLogger(logger logr.Logger) Option {
+	return func(x *XSWD) {
+		x.logger = logger
+	}
+}
+
+// WithPermissionMaxAge sets how long an AlwaysAllow permission of methods lasts before being asked again,
+// such as an hour for transfer. Methods without max age keep their AlwaysAllow permission, 0 removes it
+func WithPermissionMaxAge(maxAge time.Duration, methods ...string) Option {
+	return func(x *XSWD) {
+		for _, m := range methods {
+			if maxAge > 0 {
+				x.permissionMaxAge[m] = maxAge
+			} else {
+				delete(x.permissionMaxAge, m)
+			}
+		}
+	}
+}
+
+// WithDaemonMethods replaces the known DERO. methods of the daemon, such as for a daemon exposing more methods.
+// While the daemon is offline an unknown DERO. method is reported as not found instead of cancelled,
+// no methods disables the check so any DERO. method is cancelled
+func WithDaemonMethods(methods ...string) Option {
+	return func(x *XSWD) {
+		x.daemonMethods = methods
+	}
+}
+
+// WithTransferConfirmation sets the DERO amount above which a transfer calls requestHandler
+// even if AlwaysAllow is stored or a threshold allows it, 0 disables it
+// Token and SC transfers are not compared, non-transfer methods are not affected
+func WithTransferConfirmation(amount uint64) Option {
+	return func(x *XSWD) {
+		x.confirmAbove = amount
+	}
+}
+
+// WithTransferLimit sets the max transfers (transfer methods, BuildSignedTransfer and scinvoke) an application can send within window,
+// such as 10 per hour. Further ones are rejected with TransferLimitExceeded even if AlwaysAllow is stored,
+// failed ones are not counted. DefaultTransferWindow is used if window is not set and a limit of 0 disables it
+func WithTransferLimit(max int, window time.Duration) Option {
+	return func(x *XSWD) {
+		if window <= 0 {
+			window = DefaultTransferWindow
+		}
+
+		if max >= 0 {
+			x.transferLimit = max
+			x.transferWindow = window
+		}
+	}
+}
+
+// WithAccountChangeDisconnect sets if signed applications are disconnected when the account of the wallet changes,
+// their permissions were granted to the previous account. AccountChanged event is sent either way
+func WithAccountChangeDisconnect(disconnect bool) Option {
+	return func(x *XSWD) {
+		x.accountDisconnect = disconnect
+	}
+}
+
+// WithRateLimitGrace allows burst extra requests once an application is connected, so its setup calls
+// don't exceed the rate limit. The extra burst decays linearly to the steady one over period,
+// DefaultRateLimitGracePeriod is used if period is not set and a burst of 0 disables it
+func WithRateLimitGrace(burst int, period time.Duration) Option {
+	return func(x *XSWD) {
+		if period <= 0 {
+			period = DefaultRateLimitGracePeriod
+		}
+
+		if burst >= 0 {
+			x.graceBurst = burst
+			x.gracePeriod = period
+		}
+	}
+}
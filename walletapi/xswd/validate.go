@@ -0,0 +1,156 @@
+package xswd
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/creachadair/jrpc2"
+	"github.com/deroproject/derohe/rpc"
+	"github.com/deroproject/derohe/transaction"
+)
+
+// Params validators of well-known methods, run before requesting the permission
+// so user is never prompted for a malformed request
+var paramsValidators = map[string]func(*jrpc2.Request) error{
+	"transfer":            validateTransferParams,
+	"Transfer":            validateTransferParams,
+	"transfer_split":      validateTransferParams,
+	"PreviewTransfer":     validateTransferParams,
+	"BuildSignedTransfer": validateTransferParams,
+	"scinvoke":            validateSCInvokeParams,
+	"PreviewSCInvoke":     validateSCInvokeParams,
+	"RequestPermissions":  validateRequestPermissionsParams,
+	"GetTransferPayload":  validateTXIDParams,
+}
+
+// Validate params of a request if its method is well-known
+// custom and unknown methods are not validated
+func validateParams(request *jrpc2.Request) error {
+	validate, ok := paramsValidators[request.Method()]
+	if !ok {
+		return nil
+	}
+
+	return validate(request)
+}
+
+// Decode params into v, json errors report the invalid field
+func decodeParams(request *jrpc2.Request, v interface{}) error {
+	params := request.ParamString()
+	if params == "" {
+		return fmt.Errorf("params are required")
+	}
+
+	return json.Unmarshal([]byte(params), v)
+}
+
+func validateTransferParams(request *jrpc2.Request) error {
+	var p rpc.Transfer_Params
+	if err := decodeParams(request, &p); err != nil {
+		return err
+	}
+
+	for i, t := range p.Transfers {
+		// empty destination is allowed for SC calls
+		if t.Destination != "" {
+			if err := validateDestination(t.Destination); err != nil {
+				return fmt.Errorf("transfers[%d].destination: %s", i, err)
+			}
+		}
+
+		if _, err := t.Payload_RPC.CheckPack(transaction.PAYLOAD0_LIMIT); err != nil {
+			return fmt.Errorf("transfers[%d].payload_rpc: %s", i, err)
+		}
+	}
+
+	if p.SC_ID != "" {
+		if err := validateSCID(p.SC_ID); err != nil {
+			return fmt.Errorf("scid: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// Destination must be an address or a name the wallet resolves when transferring,
+// a value with an address prefix is never a name and must be a valid address
+func validateDestination(destination string) error {
+	_, err := rpc.NewAddress(destination)
+	if err == nil {
+		return nil
+	}
+
+	lower := strings.ToLower(destination)
+	for _, hrp := range []string{"dero1", "deroi1", "deto1", "detoi1", "deroproof1"} {
+		if strings.HasPrefix(lower, hrp) {
+			return err
+		}
+	}
+
+	// same limit as the names registered with the name service
+	if len(destination) >= 64 {
+		return fmt.Errorf("invalid name size %d", len(destination))
+	}
+
+	return nil
+}
+
+func validateSCInvokeParams(request *jrpc2.Request) error {
+	var p rpc.SC_Invoke_Params
+	if err := decodeParams(request, &p); err != nil {
+		return err
+	}
+
+	if err := validateSCID(p.SC_ID); err != nil {
+		return fmt.Errorf("scid: %s", err)
+	}
+
+	return nil
+}
+
+func validateRequestPermissionsParams(request *jrpc2.Request) error {
+	var p RequestPermissions_Params
+	if err := decodeParams(request, &p); err != nil {
+		return err
+	}
+
+	if len(p.Methods) == 0 {
+		return fmt.Errorf("methods: at least one method is required")
+	}
+
+	// same limit as the permissions of an application
+	if len(p.Methods) > 255 {
+		return fmt.Errorf("methods: too many methods %d", len(p.Methods))
+	}
+
+	return nil
+}
+
+// SCID must be a 32 bytes hex encoded hash
+func validateSCID(scid string) error {
+	if len(scid) != 64 {
+		return fmt.Errorf("invalid size %d", len(scid))
+	}
+
+	if _, err := hex.DecodeString(scid); err != nil {
+		return fmt.Errorf("invalid hexadecimal")
+	}
+
+	return nil
+}
+
+func validateTXIDParams(request *jrpc2.Request) error {
+	var p rpc.Get_Transfer_By_TXID_Params
+	if err := decodeParams(request, &p); err != nil {
+		return err
+	}
+
+	// TXID is a hash as SCID
+	if err := validateSCID(p.TXID); err != nil {
+		return fmt.Errorf("txid: %s", err)
+	}
+
+	return nil
+}
@@ -1,14 +1,25 @@
 package xswd
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/creachadair/jrpc2"
 	"github.com/creachadair/jrpc2/code"
@@ -27,13 +38,42 @@ type ApplicationData struct {
 	Name             string                `json:"name"`
 	Description      string                `json:"description"`
 	Url              string                `json:"url"`
-	Permissions      map[string]Permission `json:"permissions"`
+	Permissions      map[string]Permission `json:"permissions"` // changed with both the handling and applications locks held
 	Signature        []byte                `json:"signature"`
+	Wallet           string                `json:"wallet,omitempty"` // name of the registered wallet targeted, default wallet if empty
 	RegisteredEvents map[rpc.EventType]bool
 	// RegisteredEvents only init when accepted by user
 	OnClose      chan bool     `json:"-"` // used to inform when the Session disconnect
 	isRequesting bool          `json:"-"`
 	limiter      *rate.Limiter `json:"-"` // rate limit requests from the application
+	exempt       *rate.Limiter `json:"-"` // rate limit requests of the methods exempt from limiter
+	challenge    string        `json:"-"` // challenge issued to the session that signature must include
+	origin       string        `json:"-"` // origin header of the session, Url must match it
+	disconnect   bool          `json:"-"` // application requested to be disconnected once its request is answered
+	ConnectedAt  time.Time     `json:"-"` // when the application was accepted
+	SessionID    string        `json:"-"` // unique ID of the session assigned by the server, unlike Id it is never shared
+	LastActivity time.Time     `json:"-"` // last message read from the application
+	Transfers    int           `json:"-"` // transfers sent within the transfer limit window, only set on snapshots
+
+	// methods whose permission survives a restart, only set on the copy returned by GetApplicationByID
+	Persisted map[string]bool `json:"-"`
+
+	// TXIDs of transactions sent by the application, used to find the origin of events
+	transactions map[string]bool `json:"-"`
+	// amounts below which transfer methods are allowed without asking, guarded by handling
+	thresholds map[string]uint64 `json:"-"`
+	// when AlwaysAllow was granted per method, permissions without it are aged from ConnectedAt, guarded by handling
+	grantedAt map[string]time.Time `json:"-"`
+	// subscription IDs set by the application for its events, echoed in their notifications
+	subscriptions map[rpc.EventType]string `json:"-"`
+	// paused by the wallet with PauseApplication, shared by the copies of the application
+	paused *atomic.Bool `json:"-"`
+	// serializes the permission and handler of the session requests, shared by the copies of the application
+	handling *sync.Mutex `json:"-"`
+	// number of requests made by the application per method, guarded by applications mutex
+	calls map[string]uint64 `json:"-"`
+	// latest events to send once the Subscribe request is answered, guarded by applications mutex
+	replay []rpc.EventNotification `json:"-"`
 }
 
 func (app *ApplicationData) SetIsRequesting(value bool) {
@@ -44,19 +84,59 @@ func (app *ApplicationData) IsRequesting() bool {
 	return app.isRequesting
 }
 
+// Check if the application is paused, its requests are cancelled and it doesn't receive events
+func (app *ApplicationData) IsPaused() bool {
+	return app.paused != nil && app.paused.Load()
+}
+
+// Copy of the application returned to the callers, its permissions and events are changed
+// by the session requests under the applications lock which must be held by the caller
+func (app ApplicationData) snapshot() ApplicationData {
+	if app.Permissions != nil {
+		permissions := make(map[string]Permission, len(app.Permissions))
+		for method, perm := range app.Permissions {
+			permissions[method] = perm
+		}
+		app.Permissions = permissions
+	}
+
+	if app.RegisteredEvents != nil {
+		events := make(map[rpc.EventType]bool, len(app.RegisteredEvents))
+		for event, registered := range app.RegisteredEvents {
+			events[event] = registered
+		}
+		app.RegisteredEvents = events
+	}
+
+	return app
+}
+
+// Notification of event sent to the application with its subscription ID if any
+func (app *ApplicationData) notification(event rpc.EventType, value interface{}) rpc.EventNotification {
+	return rpc.EventNotification{Event: event, Value: value, SubscriptionID: app.subscriptions[event]}
+}
+
+// Response sent to an application, ID is the raw ID of the request so numbers
+// and strings are returned as sent, it is null for events and session errors
 type RPCResponse struct {
-	JsonRPC string      `json:"jsonrpc"`
-	ID      string      `json:"id"`
-	Result  interface{} `json:"result,omitempty"`
-	Error   interface{} `json:"error,omitempty"`
+	JsonRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   interface{}     `json:"error,omitempty"`
 }
 
-func ResponseWithError(request *jrpc2.Request, err *jrpc2.Error) RPCResponse {
-	var id string
-	if request != nil {
-		id = request.ID()
+// Raw ID of the request, nil if there is none
+func requestID(request *jrpc2.Request) json.RawMessage {
+	if request == nil || request.ID() == "" {
+		return nil
 	}
 
+	return json.RawMessage(request.ID())
+}
+
+func ResponseWithError(request *jrpc2.Request, err *jrpc2.Error) RPCResponse {
+	id := requestID(request)
+
 	return RPCResponse{
 		JsonRPC: "2.0",
 		ID:      id,
@@ -65,10 +145,7 @@ func ResponseWithError(request *jrpc2.Request, err *jrpc2.Error) RPCResponse {
 }
 
 func ResponseWithResult(request *jrpc2.Request, result interface{}) RPCResponse {
-	var id string
-	if request != nil {
-		id = request.ID()
-	}
+	id := requestID(request)
 
 	return RPCResponse{
 		JsonRPC: "2.0",
@@ -77,11 +154,79 @@ func ResponseWithResult(request *jrpc2.Request, result interface{}) RPCResponse
 	}
 }
 
+// Data of a PermissionDenied or PermissionAlwaysDenied error,
+// Stored is true if the decision comes from a stored permission instead of the requestHandler
+type PermissionDenied_Data struct {
+	Method     string `json:"method"`
+	Permission string `json:"permission"`
+	Stored     bool   `json:"stored"`
+}
+
+// Data of a daemon proxy error, Source tells if the call failed before reaching
+// the daemon or was rejected by the daemon itself, whose error code is then returned.
+// Params are not checked by the proxy, an invalid params field is reported by the daemon
+type DaemonError_Data struct {
+	Source DaemonErrorSource `json:"source"`
+}
+
+// Data of a MethodNotFound error with the closest methods names, so a mistyped method can be found
+type MethodNotFound_Data struct {
+	Method      string   `json:"method"`
+	Suggestions []string `json:"suggestions"`
+}
+
+// Data of a TransferLimitExceeded error, RetryAfter is the seconds until a transfer is available again
+type TransferLimitExceeded_Data struct {
+	Limit      int     `json:"limit"`
+	Window     float64 `json:"window"` // seconds
+	RetryAfter float64 `json:"retry_after"`
+}
+
+// Data of a RateLimitExceeded error, so a reconnecting application knows how to pace its requests
+type RateLimitExceeded_Data struct {
+	Limit  float64 `json:"limit"` // requests per second
+	Burst  int     `json:"burst"`
+	Window float64 `json:"window"` // seconds to refill the full burst
+}
+
+// Health of the server served as JSON on /health for monitoring tools
+type HealthStatus struct {
+	Running      bool  `json:"running"`
+	Applications int   `json:"applications"`
+	DaemonOnline bool  `json:"daemon_online"`
+	Uptime       int64 `json:"uptime"` // seconds since the server started
+}
+
+// Challenge sent as first message when server requires signature to include it
+// The app signature message must then be its ID followed by the challenge
+type AuthorizationChallenge struct {
+	Challenge string `json:"challenge"`
+}
+
 type AuthorizationResponse struct {
 	Message  string `json:"message"`
 	Accepted bool   `json:"accepted"`
+	// set once accepted so the application doesn't need to call GetVersion
+	Version      string   `json:"version,omitempty"`
+	Capabilities []string `json:"capabilities,omitempty"`
 }
 
+// Reason of an AuthorizationResponse, passed to the message provider set with WithAuthorizationMessages
+type AuthorizationReason string
+
+const (
+	AuthorizationAccepted        AuthorizationReason = "accepted"         // user has authorized the application
+	AuthorizationPreApproved     AuthorizationReason = "pre_approved"     // application is pre-approved by the wallet
+	AuthorizationRejected        AuthorizationReason = "rejected"         // user has rejected the application
+	AuthorizationInvalid         AuthorizationReason = "invalid"          // application data is invalid, see its ValidationStep
+	AuthorizationIDUsed          AuthorizationReason = "id_used"          // application ID is already connected
+	AuthorizationMaxApplications AuthorizationReason = "max_applications" // max connected applications reached
+	AuthorizationURLUsed         AuthorizationReason = "url_used"         // Url is used by another application
+	AuthorizationOffline         AuthorizationReason = "offline"          // server stopped while the user was prompted
+	AuthorizationStopping        AuthorizationReason = "stopping"         // server is stopping
+	AuthorizationTimeout         AuthorizationReason = "timeout"          // application data was not sent in time
+)
+
 type Permission int
 
 const (
@@ -90,10 +235,17 @@ const (
 	Deny
 	AlwaysAllow
 	AlwaysDeny
+	SessionAllow // allowed until the session is closed, never stored
 )
 
+// Stored permission of a method and if it survives a restart or only lasts the session
+type PermissionInfo struct {
+	Permission Permission `json:"permission"`
+	Persisted  bool       `json:"persisted"`
+}
+
 func (perm Permission) IsPositive() bool {
-	return perm == Allow || perm == AlwaysAllow
+	return perm == Allow || perm == AlwaysAllow || perm == SessionAllow
 }
 
 func (perm Permission) String() string {
@@ -108,6 +260,8 @@ func (perm Permission) String() string {
 		str = "Always Allow"
 	} else if perm == AlwaysDeny {
 		str = "Always Deny"
+	} else if perm == SessionAllow {
+		str = "Session Allow"
 	} else {
 		str = "Unknown"
 	}
@@ -115,9 +269,92 @@ func (perm Permission) String() string {
 	return str
 }
 
+// Policy comparing the application Url to the origin of its session
+type OriginPolicy int
+
+const (
+	OriginStrict OriginPolicy = iota // Url must be the origin
+	OriginPrefix                     // Url must share its host with the origin
+	OriginIgnore                     // Url is not compared to the origin
+)
+
+// Step of the application data validation which failed, see checkApplication
+type ValidationStep string
+
+const (
+	ValidateID          ValidationStep = "id"           // ID size or hexadecimal
+	ValidateName        ValidationStep = "name"         // empty, too long or not ASCII
+	ValidateDescription ValidationStep = "description"  // empty, too long or not ASCII
+	ValidateOrigin      ValidationStep = "origin"       // Url does not match the origin
+	ValidateURL         ValidationStep = "url"          // too long or scheme not allowed
+	ValidateWallet      ValidationStep = "wallet"       // targeted wallet not registered
+	ValidateSignature   ValidationStep = "signature"    // signature size or format
+	ValidateSigner      ValidationStep = "signer"       // signer not on DERO network
+	ValidateSignatureID ValidationStep = "signature_id" // signed message does not match ID
+	ValidatePermissions ValidationStep = "permissions"  // permissions without signature or too many
+	ValidateManifest    ValidationStep = "manifest"     // signed permissions hash does not match permissions
+	ValidateFormat      ValidationStep = "format"       // application data is not valid JSON
+)
+
+// Result of the application data validation served on /xswd/validate
+type ValidateApplication_Result struct {
+	Valid   bool           `json:"valid"`
+	Step    ValidationStep `json:"step,omitempty"`
+	Message string         `json:"message,omitempty"` // reason sent in AuthorizationResponse
+}
+
+// Reason of an application disconnection passed to onDisconnect
+type DisconnectReason string
+
+const (
+	DisconnectClosed    DisconnectReason = "connection closed"
+	DisconnectAbnormal  DisconnectReason = "connection closed abnormally"
+	DisconnectRequested DisconnectReason = "application requested disconnect"
+	DisconnectRateLimit DisconnectReason = "rate limit exceeded"
+	DisconnectRemoved   DisconnectReason = "application removed"
+	DisconnectStopped   DisconnectReason = "server stopped"
+	DisconnectReaped    DisconnectReason = "connection reaped by age"
+	DisconnectAccount   DisconnectReason = "wallet account changed"
+)
+
+// Value of AppConnected and AppDisconnected events, only public metadata of the application is shared
+type ApplicationChange struct {
+	Id     string           `json:"id"`
+	Name   string           `json:"name"`
+	Url    string           `json:"url"`
+	Reason DisconnectReason `json:"reason,omitempty"` // set for AppDisconnected
+}
+
+// Value of DaemonChanged event
+type DaemonChange struct {
+	Endpoint string `json:"endpoint"`
+	Online   bool   `json:"online"`
+}
+
+// Value of AccountChanged event, the new address is not shared as applications may not be allowed to get it
+type AccountChange struct {
+	Registered bool `json:"registered"`
+}
+
+// Source of a daemon proxy error set in DaemonError_Data
+type DaemonErrorSource string
+
+const (
+	DaemonErrorDaemon DaemonErrorSource = "daemon" // daemon rejected the request, code is the daemon one
+	DaemonErrorCall   DaemonErrorSource = "call"   // daemon call failed without response, code is InvalidRequest
+)
+
 const PermissionDenied code.Code = -32043
 const PermissionAlwaysDenied code.Code = -32044
 const RateLimitExceeded code.Code = -32070
+const BatchNotSupported code.Code = -32071
+const TransferLimitExceeded code.Code = -32072
+
+// Key of the latest value of an event broadcasted for a wallet
+type eventKey struct {
+	wallet string
+	event  rpc.EventType
+}
 
 type messageRequest struct {
 	app     *ApplicationData
@@ -125,22 +362,89 @@ type messageRequest struct {
 	request *jrpc2.Request
 }
 
+// Request in flight whose response is shared with identical requests
+type coalescedCall struct {
+	done     chan struct{}
+	response interface{}
+}
+
 type messageRegistration struct {
 	app     *ApplicationData
 	conn    *Connection
 	request *http.Request
 }
 
+// DaemonClient calls the daemon for the DERO. requests of applications,
+// the daemon of the wallet is used by default and a fake can be set with WithDaemonClient
+type DaemonClient interface {
+	IsOnline() bool
+	Call(ctx context.Context, method string, params interface{}) (*jrpc2.Response, error)
+}
+
+// Daemon connected with walletapi.Connect
+type walletDaemonClient struct {
+	wallet *walletapi.Wallet_Disk
+}
+
+func (c walletDaemonClient) IsOnline() bool {
+	return c.wallet != nil && c.wallet.IsDaemonOnlineCached()
+}
+
+func (c walletDaemonClient) Call(ctx context.Context, method string, params interface{}) (*jrpc2.Response, error) {
+	return walletapi.GetRPCClient().RPC.Call(ctx, method, params)
+}
+
+// Size of the outgoing messages queue of a connection,
+// if a client is not reading and the queue is full it will be disconnected
+const SendQueueSize = 256
+
+// Time allowed to write a message to a connection
+const writeWait = 10 * time.Second
+
+// Connection of an application, all messages sent are queued
+// and written by a dedicated writer so Send never blocks the caller.
+// Messages are delivered in the order Send was called, responses and
+// events sent from different goroutines are never reordered once queued
 type Connection struct {
-	conn *websocket.Conn
-	w    sync.Mutex
-	r    sync.Mutex
+	conn   *websocket.Conn
+	queue  chan interface{}
+	closed chan struct{} // closed when the writer has exited and the websocket is closed
+	err    error
+	r      sync.Mutex
+	// context of the connection lifecycle, cancelled when the connection is closing
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Create a new Connection from a websocket and start its writer
+// The connection context is derived from ctx
+func newConnection(ctx context.Context, conn *websocket.Conn) *Connection {
+	c := &Connection{
+		conn:   conn,
+		queue:  make(chan interface{}, SendQueueSize),
+		closed: make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+
+	go c.writer()
+
+	return c
 }
 
+// Send queue the message to be written to the connection after the ones already queued
+// If the queue is full the connection is closed as the client is not reading its messages
 func (c *Connection) Send(message interface{}) error {
-	c.w.Lock()
-	defer c.w.Unlock()
-	return c.conn.WriteJSON(message)
+	if c.IsClosed() {
+		return fmt.Errorf("connection is closed")
+	}
+
+	select {
+	case c.queue <- message:
+		return nil
+	default:
+		c.shutdown()
+		return fmt.Errorf("outgoing queue is full, closing connection")
+	}
 }
 
 func (c *Connection) Read() (int, []byte, error) {
@@ -149,10 +453,61 @@ func (c *Connection) Read() (int, []byte, error) {
 	return c.conn.ReadMessage()
 }
 
+// Close the connection once all queued messages are written
 func (c *Connection) Close() error {
-	c.w.Lock()
-	defer c.w.Unlock()
-	return c.conn.Close()
+	c.shutdown()
+	return c.wait()
+}
+
+// Wait until the writer has flushed the queue and the websocket is closed,
+// it can take up to writeWait per queued message with a client not reading them
+func (c *Connection) wait() error {
+	<-c.closed
+	return c.err
+}
+
+// Check if the connection is closing or closed
+func (c *Connection) IsClosed() bool {
+	return c.ctx.Err() != nil
+}
+
+// Signal the writer to flush the queue and close the connection
+func (c *Connection) shutdown() {
+	c.cancel()
+}
+
+// Write all queued messages until the connection is closing, then flush what is left
+func (c *Connection) writer() {
+	defer func() {
+		c.err = c.conn.Close()
+		close(c.closed)
+	}()
+
+	for {
+		select {
+		case message := <-c.queue:
+			if err := c.write(message); err != nil {
+				c.shutdown()
+				return
+			}
+		case <-c.ctx.Done():
+			for {
+				select {
+				case message := <-c.queue:
+					if err := c.write(message); err != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Connection) write(message interface{}) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return c.conn.WriteJSON(message)
 }
 
 type XSWD struct {
@@ -162,20 +517,131 @@ type XSWD struct {
 	appHandler func(*ApplicationData) bool
 	// function to request the permission
 	requestHandler func(*ApplicationData, *jrpc2.Request) Permission
-	handlerMutex   sync.Mutex
+	promptMutex    sync.Mutex // only one prompt is shown to the user at a time
 	server         *http.Server
 	logger         logr.Logger
 	context        *rpcserver.WalletContext
 	wallet         *walletapi.Wallet_Disk
+	daemon         DaemonClient // daemon called for DERO. requests
+	daemonMutex    sync.RWMutex // held by daemon calls, SetDaemonEndpoint waits for the calls in flight
 	rpcHandler     handler.Map
+	events         map[rpc.EventType]bool // events supported by the server
 	running        bool
-	forceAsk       bool     // forceAsk ensures no permissions can be accepted upon initial connection
-	noStore        []string // noStore methods won't store AlwaysAllow permission
-	requests       chan messageRequest
-	registers      chan messageRegistration
+	port           int
+	host           string     // host the server listens on, empty for all interfaces
+	forceAsk       bool       // forceAsk ensures no permissions can be accepted upon initial connection
+	challenge      bool       // challenge requires app signature to include a nonce issued for the session
+	noStore        []string   // noStore methods won't store AlwaysAllow permission
+	urlSchemes     []string   // URL schemes allowed for application Url
+	uniqueURL      bool       // uniqueURL rejects an application Url already used by another application
+	localURL       bool       // localURL rejects a localhost Url from an application with a remote origin
+	trustedProxy   bool       // trustedProxy uses X-Forwarded headers set by a reverse proxy
+	excludeOrigin  bool       // excludeOrigin skips the application which caused an event when broadcasting it
+	signDomain     bool       // signDomain prefixes SignData payload with the application tag
+	rateLimit      rate.Limit // requests per second allowed for each application
+	rateBurst      int        // requests burst allowed for each application
+	rateExempt     []string   // methods not counted by the rate limit
+	// requests per second and burst allowed for each application on the exempt methods
+	exemptRateLimit rate.Limit
+	exemptRateBurst int
+	startedAt       time.Time // startedAt is used for the health uptime
+	requests        chan messageRequest
+	registers       chan messageRegistration
+	// optional audit callback invoked after each request resolved
+	onRequest func(app *ApplicationData, method string, permission Permission, err error)
+	// optional callback invoked when an accepted application is disconnected
+	onDisconnect func(app *ApplicationData, reason DisconnectReason)
+	// optional callback invoked when a stored permission of an application changes
+	onPermissionChange func(appID, method string, perm Permission)
+	// optional batch variant of requestHandler used by RequestPermissions
+	permissionsHandler func(*ApplicationData, []string) map[string]Permission
+	// optional provider replacing the default message of AuthorizationResponse
+	authorizationMessage func(reason AuthorizationReason, step ValidationStep, message string) string
+	// noStore can be changed at runtime
+	noStoreMutex sync.RWMutex
+	// alwaysAllow methods are granted without calling requestHandler
+	alwaysAllow map[string]bool
+	// methods whose identical in-flight requests share their result, coalesced is guarded by applications mutex
+	coalesce  map[string]bool
+	coalesced map[string]*coalescedCall
+	// custom events defined by the host, broadcasted with BroadcastCustomEvent
+	customEvents map[rpc.EventType]bool
+	// namespace of host methods registered with SetNamespacedMethod
+	namespace string
+	// named wallets applications can target, the default wallet is wallet and context
+	wallets map[string]*rpcserver.WalletContext
+	// available requests below which RateLimitWarning is sent to the application, 0 if disabled
+	rateWarning float64
+	// extra requests burst allowed once connected, decaying to rateBurst over gracePeriod, 0 if disabled
+	graceBurst  int
+	gracePeriod time.Duration
+	// allowUTF8 accepts UTF-8 names and descriptions instead of ASCII only
+	allowUTF8 bool
+	// how application Url is compared to the session origin
+	originPolicy OriginPolicy
+	// max events an application can subscribe to
+	maxSubscriptions int
+	// max connected applications, 0 if unlimited
+	maxApplications int
+	// max duration to wait for the application data of a new session, 0 if unlimited
+	handshakeTimeout time.Duration
+	// size of requests and registers queues waiting on handler_loop
+	queueSize int
+	// max requests handled concurrently, 0 if unlimited, workers is its semaphore
+	maxConcurrent int
+	workers       chan struct{}
+	// max requests in flight of an application, 0 if unlimited
+	// appRequests counts them by lowercase application ID, guarded by applications mutex
+	maxAppRequests int
+	appRequests    map[string]int
+	// max method handlers run concurrently across applications, 0 if unlimited, handlers is its semaphore
+	// as requests of a session are serialized, each session has one request at most waiting on it
+	// and sessions are served in turn
+	maxHandlers int
+	handlers    chan struct{}
+	// applications connecting without appHandler by lowercase ID, with their Url and permissions
+	preApproved map[string]preApproval
+	// named permissions the user can apply to an application when accepting it
+	templates map[string]map[string]Permission
+	// optional appHandler returning the template selected by the user
+	templateHandler func(app *ApplicationData) (accepted bool, template string)
+	// last session ID assigned to an application
+	sessions atomic.Uint64
+	// last wallet height broadcasted with WalletHeight event by wallet name, only set at creation
+	walletHeights map[string]*atomic.Uint64
+	// wallet is locked by its owner, requests of every wallet are rejected until unlocked
+	walletLocked atomic.Bool
+	// max duration of custom method handlers, specific methods can have their own, 0 if disabled
+	handlerTimeout time.Duration
+	methodTimeouts map[string]time.Duration
+	// methods provided by the server, wallet and xswd ones, not timed out unless set per method
+	builtinMethods map[string]bool
+	// max age of AlwaysAllow per method, methods without one keep it for the session
+	permissionMaxAge map[string]time.Duration
+	// DERO amount above which transfers are always confirmed with requestHandler, 0 if disabled
+	confirmAbove uint64
+	// max transfers of an application within the window, 0 if unlimited
+	transferLimit  int
+	transferWindow time.Duration
+	// public key of the wallet account granted to the applications, guarded by applications mutex
+	accountKey string
+	// accountDisconnect disconnects signed applications when the account of the wallet changes
+	accountDisconnect bool
+	// times of the transfers within the window by lowercase application ID, guarded by applications mutex
+	// kept once disconnected so reconnecting doesn't reset the limit
+	transfers map[string][]time.Time
+	// known DERO. methods of the daemon, any DERO. method is known if empty
+	daemonMethods []string
 	// context and cancel to cleanly exit handler_loop
 	ctx    context.Context
 	cancel context.CancelFunc
+	// latest value broadcasted of each event by wallet name, guarded by applications mutex
+	latestEvents map[eventKey]interface{}
+	// handler of the root path, guarded by applications mutex as it can be replaced at runtime
+	rootHandler http.HandlerFunc
+	// in-flight requests and if StopGraceful is waiting on them, stopping is guarded by applications mutex
+	inflight sync.WaitGroup
+	stopping bool
 	// mutex for applications map
 	sync.Mutex
 }
@@ -185,24 +651,29 @@ type XSWD struct {
 // Production should always use 44326 as its a way to identify XSWD
 const XSWD_PORT = 44326
 
+// Version of the XSWD protocol implemented, returned by GetVersion
+const ProtocolVersion = "1.1"
+
+// Capabilities an application can detect with GetVersion before relying on them
+const (
+	CapabilityReplayLatest       = "replay_latest"       // Subscribe can replay the latest event value
+	CapabilityRequestPermissions = "request_permissions" // RequestPermissions is available
+	CapabilityRateLimitWarning   = "rate_limit_warning"  // RateLimitWarning event is sent
+	CapabilitySignDomain         = "sign_domain"         // SignData payload is prefixed with the application tag
+	CapabilityChallenge          = "challenge"           // application signature must include the session challenge
+	CapabilityMultiWallet        = "multi_wallet"        // applications can target a named wallet
+	CapabilityManifest           = "manifest"            // application signature can include its PermissionsHash
+)
+
 // Create a new XSWD server which allows to connect any dApp to the wallet safely through a websocket
 // Each request done by the session will wait on the appHandler and requestHandler to be accepted
 // NewXSWDServer will default to forceAsk (call requestHandler) for all wallet method requests,
 // methods from xswd package are default noStore and won't store AlwaysAllow permission
-func NewXSWDServer(wallet *walletapi.Wallet_Disk, appHandler func(*ApplicationData) bool, requestHandler func(*ApplicationData, *jrpc2.Request) Permission) *XSWD {
-	noStore := []string{"Subscribe", "SignData", "CheckSignature", "GetDaemon", "query_key", "QueryKey"}
-	return NewXSWDServerWithPort(XSWD_PORT, wallet, true, noStore, appHandler, requestHandler)
-}
-
-func NewXSWDServerWithPort(port int, wallet *walletapi.Wallet_Disk, forceAsk bool, noStore []string, appHandler func(*ApplicationData) bool, requestHandler func(*ApplicationData, *jrpc2.Request) Permission) *XSWD {
+// Options can be passed to change these defaults
+func NewXSWDServer(wallet *walletapi.Wallet_Disk, appHandler func(*ApplicationData) bool, requestHandler func(*ApplicationData, *jrpc2.Request) Permission, opts ...Option) *XSWD {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("XSWD server"))
-	})
 
 	ctx, cancel := context.WithCancel(context.Background())
-	server := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: mux}
-	logger := globals.Logger.WithName("XSWD")
 
 	// Prevent crossover of custom methods to rpcserver
 	xswdHandler := make(handler.Map)
@@ -214,42 +685,123 @@ func NewXSWDServerWithPort(port int, wallet *walletapi.Wallet_Disk, forceAsk boo
 		applications:   make(map[*Connection]ApplicationData),
 		appHandler:     appHandler,
 		requestHandler: requestHandler,
-		logger:         logger,
-		server:         server,
-		context:        rpcserver.NewWalletContext(logger, wallet),
+		logger:         globals.Logger.WithName("XSWD"),
 		wallet:         wallet,
+		daemon:         walletDaemonClient{wallet},
 		// don't create a different API, we provide the same
 		rpcHandler: xswdHandler,
-		requests:   make(chan messageRequest),
-		registers:  make(chan messageRegistration),
+		events:     make(map[rpc.EventType]bool),
 		running:    true,
-		forceAsk:   forceAsk,
-		noStore:    noStore,
+		port:       XSWD_PORT,
+		host:       DefaultHost,
+		forceAsk:   true,
+		noStore:    DefaultNoStore,
+		urlSchemes: DefaultURLSchemes,
+		rateLimit:  DefaultRateLimit,
+		rateBurst:  DefaultRateBurst,
+		rateExempt: DefaultRateLimitExempt,
 		ctx:        ctx,
 		cancel:     cancel,
+		startedAt:  time.Now(),
+
+		rootHandler:  defaultRootHandler,
+		latestEvents: make(map[eventKey]interface{}),
+		rateWarning:  DefaultRateLimitWarning,
+
+		exemptRateLimit: DefaultExemptRateLimit,
+		exemptRateBurst: DefaultExemptRateBurst,
+
+		handlerTimeout: DefaultHandlerTimeout,
+		methodTimeouts: make(map[string]time.Duration),
+		preApproved:    make(map[string]preApproval),
+		templates:      make(map[string]map[string]Permission),
+		alwaysAllow:    make(map[string]bool),
+		wallets:        make(map[string]*rpcserver.WalletContext),
+		customEvents:   make(map[rpc.EventType]bool),
+		coalesced:      make(map[string]*coalescedCall),
+		namespace:      DefaultMethodNamespace,
+		transfers:      make(map[string][]time.Time),
+
+		maxSubscriptions: DefaultMaxSubscriptions,
+		maxConcurrent:    DefaultMaxConcurrentRequests,
+		maxAppRequests:   DefaultMaxApplicationRequests,
+		appRequests:      make(map[string]int),
+		maxHandlers:      DefaultMaxConcurrentHandlers,
+		queueSize:        DefaultQueueSize,
+		handshakeTimeout: DefaultHandshakeTimeout,
+		permissionMaxAge: make(map[string]time.Duration),
+		daemonMethods:    DefaultDaemonMethods,
 	}
 
-	// Register event listeners
-	wallet.Wallet_Memory.AddListener(rpc.NewBalance, func(change interface{}) {
-		if xswd.IsEventTracked(rpc.NewBalance) {
-			xswd.BroadcastEvent(rpc.NewBalance, change)
-		}
-	})
+	for _, opt := range opts {
+		opt(xswd)
+	}
 
-	wallet.Wallet_Memory.AddListener(rpc.NewTopoheight, func(topo interface{}) {
-		if xswd.IsEventTracked(rpc.NewTopoheight) {
-			xswd.BroadcastEvent(rpc.NewTopoheight, topo)
-		}
-	})
+	// wallet contexts log with the logger set by the options
+	xswd.context = rpcserver.NewWalletContext(xswd.logger, wallet)
+	for name, wallet_context := range xswd.wallets {
+		xswd.wallets[name] = rpcserver.NewWalletContext(xswd.logger, wallet_context.Wallet())
+	}
 
-	wallet.Wallet_Memory.AddListener(rpc.NewEntry, func(entry interface{}) {
-		if xswd.IsEventTracked(rpc.NewEntry) {
-			xswd.BroadcastEvent(rpc.NewEntry, entry)
-		}
+	if xswd.maxConcurrent > 0 {
+		xswd.workers = make(chan struct{}, xswd.maxConcurrent)
+	}
+
+	if xswd.maxHandlers > 0 {
+		xswd.handlers = make(chan struct{}, xswd.maxHandlers)
+	}
+
+	// sessions can queue messages while handler_loop is busy
+	xswd.requests = make(chan messageRequest, xswd.queueSize)
+	xswd.registers = make(chan messageRegistration, xswd.queueSize)
+
+	xswd.server = &http.Server{Addr: net.JoinHostPort(xswd.host, fmt.Sprint(xswd.port)), Handler: mux}
+
+	// Register event listeners, only registered events can be subscribed to
+	xswd.registerEvent(rpc.NewBalance, rpc.NewBalance, nil)
+	xswd.registerEvent(rpc.NewTopoheight, rpc.NewTopoheight, nil)
+	xswd.registerEvent(rpc.NewEntry, rpc.NewEntry, nil)
+	// SyncProgress is derived from NewTopoheight
+	xswd.registerEvent(rpc.NewTopoheight, rpc.SyncProgress, func(name string, topo interface{}) interface{} {
+		return xswd.syncProgress(name)
 	})
+	// WalletHeight is derived from NewTopoheight and only broadcasted when it changes
+	xswd.walletHeights = make(map[string]*atomic.Uint64, len(xswd.wallets)+1)
+	for _, name := range append(xswd.WalletNames(), "") {
+		xswd.walletHeights[name] = new(atomic.Uint64)
+		xswd.walletHeights[name].Store(math.MaxUint64)
+	}
+	xswd.registerEvent(rpc.NewTopoheight, rpc.WalletHeight, func(name string, topo interface{}) interface{} {
+		return xswd.walletHeightChanged(name)
+	})
+	// WalletLocked is broadcasted by SetWalletLocked to the applications of every wallet
+	xswd.events[rpc.WalletLocked] = true
+	// RateLimitWarning is sent to the application reaching its rate limit
+	xswd.events[rpc.RateLimitWarning] = true
+	// AppConnected and AppDisconnected are sent to the other applications
+	xswd.events[rpc.AppConnected] = true
+	xswd.events[rpc.AppDisconnected] = true
+	// DaemonChanged is sent when the host changes the daemon endpoint
+	xswd.events[rpc.DaemonChanged] = true
+	// AccountChanged is sent when the account of the wallet is found switched
+	xswd.events[rpc.AccountChanged] = true
+	xswd.accountKey = xswd.walletAccountKey()
+	// Custom events are broadcasted by the host with BroadcastCustomEvent
+	for event := range xswd.customEvents {
+		if xswd.events[event] {
+			xswd.logger.Info("Custom event is already a server event, ignoring it", "event", event)
+			delete(xswd.customEvents, event)
+			continue
+		}
 
-	// Save the server in the context
+		xswd.events[event] = true
+	}
+
+	// Save the server in the contexts
 	xswd.context.Extra["xswd"] = xswd
+	for _, wallet_context := range xswd.wallets {
+		wallet_context.Extra["xswd"] = xswd
+	}
 
 	// Register custom methods
 	// HasMethod for compatibility reasons in case of custom methods declared
@@ -259,14 +811,39 @@ func NewXSWDServerWithPort(port int, wallet *walletapi.Wallet_Disk, forceAsk boo
 	xswd.SetCustomMethod("SignData", handler.New(SignData))
 	xswd.SetCustomMethod("CheckSignature", handler.New(CheckSignature))
 	xswd.SetCustomMethod("GetDaemon", handler.New(GetDaemon))
+	xswd.SetCustomMethod("GetDaemonStatus", handler.New(GetDaemonStatus))
+	xswd.SetCustomMethod("PreviewTransfer", handler.New(PreviewTransfer))
+	xswd.SetCustomMethod("BuildSignedTransfer", handler.New(BuildSignedTransfer))
+	xswd.SetCustomMethod("PreviewSCInvoke", handler.New(PreviewSCInvoke))
+	xswd.SetCustomMethod("GetTransferPayload", handler.New(GetTransferPayload))
+	xswd.SetCustomMethodWithPolicy("GetRateLimit", handler.New(GetRateLimit), true)
+	xswd.SetCustomMethod("UpdateMetadata", handler.New(UpdateMetadata))
+	xswd.SetCustomMethodWithPolicy("Disconnect", handler.New(Disconnect), true)
+	xswd.SetCustomMethodWithPolicy("RequestPermissions", handler.New(RequestPermissions), true)
+	xswd.SetCustomMethodWithPolicy("GetVersion", handler.New(GetVersion), true)
+	xswd.SetCustomMethodWithPolicy("Ping", handler.New(Ping), true)
+	xswd.SetCustomMethodWithPolicy("GetPermissions", handler.New(GetPermissions), true)
+	xswd.SetCustomMethodWithPolicy("GetNetworkInfo", handler.New(GetNetworkInfo), true)
+	xswd.SetCustomMethodWithPolicy("DecodeAddress", handler.New(DecodeAddress), true)
+	xswd.SetCustomMethodWithPolicy("VerifySignatureFrom", handler.New(VerifySignatureFrom), true)
+	xswd.SetCustomMethodWithPolicy("IsRegistered", handler.New(IsRegistered), true)
+
+	// methods registered from now on are the host ones
+	xswd.builtinMethods = make(map[string]bool, len(xswd.rpcHandler))
+	for method := range xswd.rpcHandler {
+		xswd.builtinMethods[method] = true
+	}
 
+	mux.HandleFunc("/", xswd.handleRoot)
 	mux.HandleFunc("/xswd", xswd.handleWebSocket)
-	logger.Info("Starting XSWD server", "addr", server.Addr)
+	mux.HandleFunc("/xswd/validate", xswd.handleValidate)
+	mux.HandleFunc("/health", xswd.handleHealth)
+	xswd.logger.Info("Starting XSWD server", "addr", xswd.server.Addr)
 
 	go func() {
 		if err := xswd.server.ListenAndServe(); err != nil {
 			if xswd.running {
-				logger.Error(err, "Error while starting XSWD server")
+				xswd.logger.Error(err, "Error while starting XSWD server")
 				xswd.Stop()
 			}
 		}
@@ -277,9 +854,57 @@ func NewXSWDServerWithPort(port int, wallet *walletapi.Wallet_Disk, forceAsk boo
 	return xswd
 }
 
+// Create a new XSWD server on a specific port with forceAsk and noStore methods defined
+// This is kept for compatibility, NewXSWDServer with options should be preferred
+func NewXSWDServerWithPort(port int, wallet *walletapi.Wallet_Disk, forceAsk bool, noStore []string, appHandler func(*ApplicationData) bool, requestHandler func(*ApplicationData, *jrpc2.Request) Permission) *XSWD {
+	return NewXSWDServer(wallet, appHandler, requestHandler, WithPort(port), WithForceAsk(forceAsk), WithNoStore(noStore...))
+}
+
+// Register a listener on source of each wallet which broadcasts event to the applications subscribed to it
+// If derive is set, it computes the broadcast value from the wallet name and the source value only when event is tracked
+func (x *XSWD) registerEvent(source rpc.EventType, event rpc.EventType, derive func(name string, value interface{}) interface{}) {
+	x.events[event] = true
+	x.addEventListener("", x.wallet, source, event, derive)
+	for name, wallet_context := range x.wallets {
+		x.addEventListener(name, wallet_context.Wallet(), source, event, derive)
+	}
+}
+
+// Add a listener on wallet broadcasting event to the applications targeting it by name
+func (x *XSWD) addEventListener(name string, wallet *walletapi.Wallet_Disk, source rpc.EventType, event rpc.EventType, derive func(name string, value interface{}) interface{}) {
+	wallet.Wallet_Memory.AddListener(source, func(value interface{}) {
+		if x.IsEventTracked(event) {
+			// origin is resolved from the source value before it is derived
+			origin := x.eventOrigin(value)
+			// derive returning nil skips the broadcast
+			if derive != nil {
+				if value = derive(name, value); value == nil {
+					return
+				}
+			}
+
+			if !x.isEventValueValid(event, value) {
+				return
+			}
+
+			x.cacheEvent(event, value, name)
+			x.broadcastEvent(event, value, func(app *ApplicationData) bool {
+				return app.Wallet == name && (origin == "" || !strings.EqualFold(app.Id, origin))
+			})
+		}
+	})
+}
+
+// Check if event is supported by the server and can be subscribed to
+func (x *XSWD) IsEventSupported(event rpc.EventType) bool {
+	return x.events[event]
+}
+
 func (x *XSWD) IsEventTracked(event rpc.EventType) bool {
-	applications := x.GetApplications()
-	for _, app := range applications {
+	x.Lock()
+	defer x.Unlock()
+
+	for _, app := range x.applications {
 		if app.RegisteredEvents[event] {
 			return true
 		}
@@ -288,498 +913,2550 @@ func (x *XSWD) IsEventTracked(event rpc.EventType) bool {
 	return false
 }
 
-func (x *XSWD) BroadcastEvent(event rpc.EventType, value interface{}) {
-	for conn, app := range x.applications {
-		if app.RegisteredEvents[event] {
-			if err := conn.Send(ResponseWithResult(nil, rpc.EventNotification{Event: event, Value: value})); err != nil {
-				x.logger.V(2).Error(err, "Error while broadcasting event")
+// Events registered by at least one application, sorted so the result is stable
+func (x *XSWD) TrackedEvents() []rpc.EventType {
+	tracked := map[rpc.EventType]bool{}
+	x.Lock()
+	for _, app := range x.applications {
+		for event, registered := range app.RegisteredEvents {
+			if registered {
+				tracked[event] = true
 			}
 		}
 	}
+	x.Unlock()
+
+	events := make([]rpc.EventType, 0, len(tracked))
+	for event := range tracked {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i] < events[j] })
+
+	return events
 }
 
-func (x *XSWD) handler_loop() {
-	for {
-		select {
-		case msg := <-x.requests:
-			go func(msg messageRequest) {
-				response := x.handleMessage(msg.app, msg.request)
-				if response != nil {
-					if err := msg.conn.Send(response); err != nil {
-						x.logger.V(2).Error(err, "Error while writing JSON", "app", msg.app.Name)
-					}
-				}
-			}(msg)
-		case msg := <-x.registers:
-			response, accepted := x.addApplication(msg.request, msg.conn, msg.app)
-			if accepted {
-				msg.conn.Send(AuthorizationResponse{
-					Message:  response,
-					Accepted: true,
-				})
-			} else {
-				msg.conn.Send(AuthorizationResponse{
-					Message:  fmt.Sprintf("Could not connect the application: %s", response),
-					Accepted: false,
-				})
-				x.removeApplicationOfSession(msg.conn, msg.app)
-			}
-		case <-x.ctx.Done():
-			return
-		}
+// Broadcast event to every subscribed application, each application
+// receives the events in the order they were broadcasted
+func (x *XSWD) BroadcastEvent(event rpc.EventType, value interface{}) {
+	x.BroadcastEventExcept(event, value, "")
+}
+
+// Broadcast event to subscribed applications except the one with app_id,
+// an empty app_id broadcasts to every subscribed application
+func (x *XSWD) BroadcastEventExcept(event rpc.EventType, value interface{}, app_id string) {
+	if !x.isEventValueValid(event, value) {
+		return
 	}
+
+	x.cacheEvent(event, value, append(x.WalletNames(), "")...)
+	x.broadcastEvent(event, value, func(app *ApplicationData) bool {
+		return app_id == "" || !strings.EqualFold(app.Id, app_id)
+	})
 }
 
-func (x *XSWD) IsRunning() bool {
-	return x.running
+// Broadcast a custom event defined by the host to the applications subscribed to it,
+// the event must be registered with WithCustomEvents
+func (x *XSWD) BroadcastCustomEvent(name string, value interface{}) error {
+	event := rpc.EventType(name)
+	if !x.customEvents[event] {
+		return fmt.Errorf("custom event %q is not registered", name)
+	}
+
+	x.BroadcastEvent(event, value)
+
+	return nil
 }
 
-// Stop the XSWD server
-// This will close all the connections
-// and delete all applications
-func (x *XSWD) Stop() {
+// Broadcast event to subscribed applications matching filter, the applications lock is held
+// while the notifications are queued so filter can't call XSWD and sessions can't change meanwhile
+func (x *XSWD) broadcastEvent(event rpc.EventType, value interface{}, filter func(app *ApplicationData) bool) {
 	x.Lock()
 	defer x.Unlock()
-	x.running = false
-	x.cancel()
-
-	if err := x.server.Shutdown(context.Background()); err != nil {
-		x.logger.Error(err, "Error while stopping XSWD server")
-	}
 
 	for conn, app := range x.applications {
-		if app.IsRequesting() {
-			app.OnClose <- true
+		if !filter(&app) || app.IsPaused() {
+			continue
 		}
 
-		conn.Close()
+		if app.RegisteredEvents[event] {
+			if err := conn.Send(ResponseWithResult(nil, app.notification(event, value))); err != nil {
+				x.logger.V(2).Error(err, "Error while broadcasting event")
+			}
+		}
 	}
-	x.applications = make(map[*Connection]ApplicationData)
-	x.logger.Info("XSWD server stopped")
-	x = nil
 }
 
-// Register a custom method easily to be completely configurable
-func (x *XSWD) SetCustomMethod(method string, handler handler.Func) {
-	x.rpcHandler[method] = handler
+// Expected value of the events supported by the server, other events are not checked
+var eventValueValidators = map[rpc.EventType]func(value interface{}) bool{
+	rpc.NewBalance: func(value interface{}) bool {
+		_, ok := value.(rpc.BalanceChange)
+		return ok
+	},
+	rpc.NewTopoheight: isNumber,
+	rpc.NewEntry: func(value interface{}) bool {
+		_, ok := value.(rpc.Entry)
+		return ok
+	},
+	rpc.SyncProgress: func(value interface{}) bool {
+		_, ok := value.(rpc.SyncProgressChange)
+		return ok
+	},
+	rpc.WalletHeight: isNumber,
+	rpc.WalletLocked: func(value interface{}) bool {
+		_, ok := value.(bool)
+		return ok
+	},
+	rpc.RateLimitWarning: func(value interface{}) bool {
+		_, ok := value.(GetRateLimit_Result)
+		return ok
+	},
+	rpc.AccountChanged: func(value interface{}) bool {
+		_, ok := value.(AccountChange)
+		return ok
+	},
+	rpc.DaemonChanged: func(value interface{}) bool {
+		_, ok := value.(DaemonChange)
+		return ok
+	},
+	rpc.AppConnected: func(value interface{}) bool {
+		_, ok := value.(ApplicationChange)
+		return ok
+	},
+	rpc.AppDisconnected: func(value interface{}) bool {
+		_, ok := value.(ApplicationChange)
+		return ok
+	},
 }
 
-// Get all connected Applications
-// This will return a copy of the map
-func (x *XSWD) GetApplications() []ApplicationData {
-	x.Lock()
-	defer x.Unlock()
+// Check the value matches the type expected for event, so applications never receive a value they can't unmarshal
+func (x *XSWD) isEventValueValid(event rpc.EventType, value interface{}) bool {
+	validate, ok := eventValueValidators[event]
+	if !ok || validate(value) {
+		return true
+	}
 
-	apps := make([]ApplicationData, 0, len(x.applications))
-	for _, app := range x.applications {
-		apps = append(apps, app)
+	x.logger.Error(fmt.Errorf("invalid event value type %T", value), "Event not broadcasted", "event", event)
+	return false
+}
+
+// Heights are sent as numbers whatever their integer type
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return true
 	}
 
-	return apps
+	return false
 }
 
-// Remove an application
-// It will automatically close the connection
-func (x *XSWD) RemoveApplication(app *ApplicationData) {
+// Keep value as the latest of event for the wallets so it can be replayed to new subscribers
+func (x *XSWD) cacheEvent(event rpc.EventType, value interface{}, wallets ...string) {
 	x.Lock()
 	defer x.Unlock()
 
-	for conn, a := range x.applications {
-		if a.Id == app.Id {
-			delete(x.applications, conn)
-			if a.IsRequesting() {
-				a.OnClose <- true
-			}
-
-			if err := conn.Close(); err != nil {
-				x.logger.Error(err, "error while closing websocket session")
-			}
-			break
-		}
+	for _, wallet := range wallets {
+		x.latestEvents[eventKey{wallet, event}] = value
 	}
 }
 
-// Check if a application exist by its id
-func (x *XSWD) HasApplicationId(app_id string) bool {
+// Get the latest value broadcasted of event for the wallet name
+func (x *XSWD) latestEvent(wallet string, event rpc.EventType) (value interface{}, ok bool) {
 	x.Lock()
 	defer x.Unlock()
 
-	for _, a := range x.applications {
-		if strings.EqualFold(a.Id, app_id) {
-			return true
-		}
-	}
-	return false
+	value, ok = x.latestEvents[eventKey{wallet, event}]
+	return
 }
 
-// Add an application from a websocket connection,
+// Queue the latest value of event to be sent to the application once its request is answered
+func (x *XSWD) queueReplay(app *ApplicationData, event rpc.EventType) bool {
+	value, ok := x.latestEvent(app.Wallet, event)
+	if !ok {
+		return false
+	}
+
+	x.Lock()
+	app.replay = append(app.replay, app.notification(event, value))
+	x.Unlock()
+
+	return true
+}
+
+// Take the events queued to be replayed to the application
+func (x *XSWD) takeReplay(app *ApplicationData) (replay []rpc.EventNotification) {
+	x.Lock()
+	defer x.Unlock()
+
+	replay, app.replay = app.replay, nil
+	return
+}
+
+// Find the application which caused an event when excludeOrigin is enabled,
+// only entries of transactions sent through XSWD can be attributed
+func (x *XSWD) eventOrigin(value interface{}) string {
+	if !x.excludeOrigin {
+		return ""
+	}
+
+	entry, ok := value.(rpc.Entry)
+	if !ok || entry.TXID == "" {
+		return ""
+	}
+
+	x.Lock()
+	defer x.Unlock()
+
+	for _, app := range x.applications {
+		if app.transactions[entry.TXID] {
+			return app.Id
+		}
+	}
+
+	return ""
+}
+
+// Keep the TXID of a transaction sent by the application
+func (x *XSWD) trackTransaction(app *ApplicationData, result interface{}) {
+	if !x.excludeOrigin {
+		return
+	}
+
+	if r, ok := result.(rpc.Transfer_Result); ok && r.TXID != "" && app.transactions != nil {
+		x.Lock()
+		app.transactions[r.TXID] = true
+		x.Unlock()
+	}
+}
+
+// Public key of the account of the wallet, empty if the wallet is closed
+func (x *XSWD) walletAccountKey() string {
+	if x.wallet == nil || x.wallet.Wallet_Memory == nil {
+		return ""
+	}
+
+	return x.wallet.GetAddress().PublicKey.StringHex()
+}
+
+// Check if the account of the wallet was switched since the server started or the last check,
+// AccountChanged event is broadcasted and, if set with WithAccountChangeDisconnect,
+// signed applications are disconnected so their permissions don't apply to the new account.
+// It is checked before each request, the host can call it once it switches the account
+func (x *XSWD) CheckAccount() bool {
+	key := x.walletAccountKey()
+	if key == "" {
+		return false
+	}
+
+	x.Lock()
+	changed := x.accountKey != key
+	x.accountKey = key
+
+	removed := make(map[*Connection]ApplicationData)
+	if changed && x.accountDisconnect {
+		for conn, app := range x.applications {
+			if len(app.Signature) > 0 {
+				removed[conn] = x.removeApplication(conn, app)
+			}
+		}
+	}
+	x.Unlock()
+
+	if !changed {
+		return false
+	}
+
+	x.logger.Info("Wallet account changed", "disconnected", len(removed))
+	for conn, app := range removed {
+		x.waitClosed(conn)
+		x.notifyDisconnect(app, DisconnectAccount)
+	}
+
+	x.BroadcastEvent(rpc.AccountChanged, AccountChange{Registered: x.wallet.IsRegistered()})
+
+	return true
+}
+
+// Endpoint of the daemon used by the wallet, the active one once connected
+func (x *XSWD) DaemonEndpoint() string {
+	if walletapi.Daemon_Endpoint_Active != "" {
+		return walletapi.Daemon_Endpoint_Active
+	}
+
+	return walletapi.Daemon_Endpoint
+}
+
+// Set the daemon endpoint of the wallet and connect to it, DaemonChanged event is broadcasted
+// once connected. If the connection fails the previous endpoint and client are restored.
+// Daemon calls in flight complete on the previous daemon before it is switched,
+// a DaemonClient set with WithDaemonClient is not changed.
+// The walletapi connection is global: only the XSWD daemon calls are synchronised with the switch,
+// the wallet's own daemon calls and walletapi.Keep_Connectivity are not, and Keep_Connectivity
+// reconnects to --daemon-address when it is set instead of the endpoint set here
+func (x *XSWD) SetDaemonEndpoint(endpoint string) (err error) {
+	x.daemonMutex.Lock()
+	client := walletapi.GetRPCClient()
+	previous := *client
+	previousEndpoint, previousActive, connected := walletapi.Daemon_Endpoint, walletapi.Daemon_Endpoint_Active, walletapi.Connected
+	x.wallet.SetDaemonAddress(endpoint)
+	if err = walletapi.Connect(endpoint); err != nil {
+		// close the client created if the connectivity test failed and keep using the previous one
+		if client.RPC != nil && client.RPC != previous.RPC {
+			client.RPC.Close()
+		}
+		*client = previous
+		walletapi.Daemon_Endpoint, walletapi.Daemon_Endpoint_Active, walletapi.Connected = previousEndpoint, previousActive, connected
+	} else if previous.RPC != nil && previous.RPC != client.RPC {
+		previous.RPC.Close()
+	}
+	x.daemonMutex.Unlock()
+
+	if err != nil {
+		x.logger.Error(err, "Could not connect to daemon", "endpoint", endpoint)
+		return
+	}
+
+	x.logger.Info("Daemon endpoint changed", "endpoint", endpoint)
+	x.BroadcastEvent(rpc.DaemonChanged, DaemonChange{Endpoint: x.DaemonEndpoint(), Online: x.daemon.IsOnline()})
+
+	return
+}
+
+// Call the daemon, SetDaemonEndpoint can't switch it while the call is in flight
+func (x *XSWD) callDaemon(ctx context.Context, method string, params interface{}) (*jrpc2.Response, error) {
+	x.daemonMutex.RLock()
+	defer x.daemonMutex.RUnlock()
+
+	return x.daemon.Call(ctx, method, params)
+}
+
+// Set if the wallets are locked, requests are rejected while they are
+// WalletLocked event is broadcasted to the applications of every wallet when the state changes
+func (x *XSWD) SetWalletLocked(locked bool) {
+	if x.walletLocked.Swap(locked) == locked {
+		return
+	}
+
+	x.logger.Info("Wallet lock changed", "locked", locked)
+	if x.IsEventTracked(rpc.WalletLocked) {
+		x.BroadcastEvent(rpc.WalletLocked, locked)
+	}
+}
+
+// Check if the wallet is closed or locked by its owner
+func (x *XSWD) IsWalletLocked() bool {
+	return x.wallet == nil || x.walletLocked.Load()
+}
+
+// Capabilities supported by the server with its current configuration
+func (x *XSWD) Capabilities() []string {
+	capabilities := []string{CapabilityReplayLatest, CapabilityRequestPermissions, CapabilityManifest}
+	if x.rateWarning > 0 {
+		capabilities = append(capabilities, CapabilityRateLimitWarning)
+	}
+
+	if x.signDomain {
+		capabilities = append(capabilities, CapabilitySignDomain)
+	}
+
+	if x.challenge {
+		capabilities = append(capabilities, CapabilityChallenge)
+	}
+
+	if len(x.wallets) > 0 {
+		capabilities = append(capabilities, CapabilityMultiWallet)
+	}
+
+	return capabilities
+}
+
+// Check if an application can target the wallet name, empty name is the default wallet
+func (x *XSWD) HasWallet(name string) bool {
+	if name == "" {
+		return true
+	}
+
+	_, ok := x.wallets[name]
+	return ok
+}
+
+// Names of the wallets registered with WithWallet, sorted so the result is stable
+func (x *XSWD) WalletNames() []string {
+	names := make([]string, 0, len(x.wallets))
+	for name := range x.wallets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Context of the wallet name used to handle requests, default wallet context if empty
+func (x *XSWD) walletContext(name string) *rpcserver.WalletContext {
+	if wallet_context, ok := x.wallets[name]; ok {
+		return wallet_context
+	}
+
+	return x.context
+}
+
+// Height of the wallet by name if it changed since last call, nil otherwise
+func (x *XSWD) walletHeightChanged(name string) interface{} {
+	height := x.walletContext(name).Wallet().Get_Height()
+	if x.walletHeights[name].Swap(height) == height {
+		return nil
+	}
+
+	return height
+}
+
+// Compare height of the wallet by name against daemon height
+func (x *XSWD) syncProgress(name string) rpc.SyncProgressChange {
+	wallet := x.walletContext(name).Wallet()
+	wallet_height := wallet.Get_Height()
+	daemon_height := wallet.Get_Daemon_Height()
+
+	return rpc.SyncProgressChange{
+		WalletHeight: wallet_height,
+		DaemonHeight: daemon_height,
+		Synced:       daemon_height > 0 && wallet_height >= daemon_height,
+	}
+}
+
+func (x *XSWD) handler_loop() {
+	for {
+		select {
+		case msg := <-x.requests:
+			// in-flight requests are tracked so StopGraceful can wait on them,
+			// an application can't have more than maxAppRequests of them so it can't hold every worker
+			x.Lock()
+			stopping := x.stopping
+			id := strings.ToLower(msg.app.Id)
+			exceeded := !stopping && x.maxAppRequests > 0 && x.appRequests[id] >= x.maxAppRequests
+			if !stopping && !exceeded {
+				x.inflight.Add(1)
+				x.appRequests[id]++
+			}
+			x.Unlock()
+
+			if stopping {
+				msg.conn.Send(ResponseWithError(msg.request, jrpc2.Errorf(code.Cancelled, "XSWD is stopping")))
+				continue
+			}
+
+			if exceeded {
+				x.logger.V(1).Info("Too many requests in flight", "app", msg.app.Name, "max", x.maxAppRequests)
+				msg.conn.Send(ResponseWithError(msg.request, jrpc2.Errorf(code.Cancelled, "Too many requests in flight, max is %d", x.maxAppRequests)))
+				continue
+			}
+
+			// the worker is awaited apart so handler_loop keeps serving other applications and registrations
+			go func(msg messageRequest) {
+				defer x.inflight.Done()
+				defer func() {
+					x.Lock()
+					if x.appRequests[id]--; x.appRequests[id] <= 0 {
+						delete(x.appRequests, id)
+					}
+					x.Unlock()
+				}()
+
+				// excess requests wait until a worker is released
+				if !x.acquireWorker(msg.conn.ctx) {
+					return
+				}
+				defer x.releaseWorker()
+
+				response := x.handleCoalesced(msg.conn.ctx, msg.app, msg.request)
+				// don't write to a connection closed while handling the request
+				if response != nil && !msg.conn.IsClosed() {
+					if err := msg.conn.Send(response); err != nil {
+						x.logger.V(2).Error(err, "Error while writing JSON", "app", msg.app.Name)
+					}
+				}
+
+				// latest events are replayed after the Subscribe response
+				for _, notification := range x.takeReplay(msg.app) {
+					if err := msg.conn.Send(ResponseWithResult(nil, notification)); err != nil {
+						x.logger.V(2).Error(err, "Error while replaying event", "app", msg.app.Name)
+					}
+				}
+
+				// acknowledgment is queued before closing so it is still written
+				if msg.app.disconnect {
+					x.removeApplicationOfSession(msg.conn, msg.app, DisconnectRequested)
+				}
+			}(msg)
+		case msg := <-x.registers:
+			var reason AuthorizationReason
+			var step ValidationStep
+			var response string
+			var accepted bool
+			if x.isStopping() {
+				reason, response = AuthorizationStopping, "XSWD is stopping"
+			} else {
+				reason, step, response, accepted = x.addApplication(msg.request, msg.conn, msg.app)
+			}
+
+			if accepted {
+				msg.conn.Send(x.authorizationResponse(reason, step, response, true))
+			} else {
+				msg.conn.Send(x.authorizationResponse(reason, step, fmt.Sprintf("Could not connect the application: %s", response), false))
+				x.removeApplicationOfSession(msg.conn, msg.app, DisconnectClosed)
+			}
+		case <-x.ctx.Done():
+			return
+		}
+	}
+}
+
+// Build the AuthorizationResponse sent to a session, message is replaced by the provider if set
+func (x *XSWD) authorizationResponse(reason AuthorizationReason, step ValidationStep, message string, accepted bool) AuthorizationResponse {
+	if x.authorizationMessage != nil {
+		message = x.authorizationMessage(reason, step, message)
+	}
+
+	response := AuthorizationResponse{
+		Message:  message,
+		Accepted: accepted,
+	}
+
+	if accepted {
+		response.Version = ProtocolVersion
+		response.Capabilities = x.Capabilities()
+	}
+
+	return response
+}
+
+// Handle a request or wait for the identical request of the application already in flight if its method is coalesced,
+// the shared response is returned with the ID of request
+func (x *XSWD) handleCoalesced(ctx context.Context, app *ApplicationData, request *jrpc2.Request) interface{} {
+	method := request.Method()
+	if !x.coalesce[method] || app.SessionID == "" {
+		return x.handleMessage(ctx, app, request)
+	}
+
+	key := app.SessionID + "\x00" + method + "\x00" + request.ParamString()
+	x.Lock()
+	call, found := x.coalesced[key]
+	if !found {
+		call = &coalescedCall{done: make(chan struct{})}
+		x.coalesced[key] = call
+	}
+	x.Unlock()
+
+	if found {
+		x.logger.V(2).Info("Request coalesced", "method", method, "app", app.Name)
+		x.countCall(app, method)
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil
+		}
+
+		if response, ok := call.response.(RPCResponse); ok {
+			response.ID = requestID(request)
+			return response
+		}
+
+		return call.response
+	}
+
+	call.response = x.handleMessage(ctx, app, request)
+	x.Lock()
+	delete(x.coalesced, key)
+	x.Unlock()
+	close(call.done)
+
+	return call.response
+}
+
+// Methods with side effects which can't share their result with another request
+func isMutatingMethod(method string) bool {
+	switch method {
+	case "scinvoke", "SignData", "BuildSignedTransfer", "Subscribe", "Unsubscribe", "UpdateMetadata", "Disconnect", "RequestPermissions":
+		return true
+	}
+
+	return transferMethods[method]
+}
+
+// Wait for a free worker if concurrent requests are bounded,
+// returns false if the server is stopped or ctx is done while waiting
+func (x *XSWD) acquireWorker(ctx context.Context) bool {
+	if x.workers == nil {
+		return true
+	}
+
+	select {
+	case x.workers <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-x.ctx.Done():
+		return false
+	}
+}
+
+func (x *XSWD) releaseWorker() {
+	if x.workers != nil {
+		<-x.workers
+	}
+}
+
+// Wait for a free handler slot if concurrent handlers are bounded,
+// waiting sessions are served in arrival order, returns false if ctx is done while waiting
+func (x *XSWD) acquireHandler(ctx context.Context) bool {
+	if x.handlers == nil {
+		return true
+	}
+
+	select {
+	case x.handlers <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (x *XSWD) releaseHandler() {
+	if x.handlers != nil {
+		<-x.handlers
+	}
+}
+
+func (x *XSWD) IsRunning() bool {
+	return x.running
+}
+
+// Check if StopGraceful is draining the in-flight requests
+func (x *XSWD) isStopping() bool {
+	x.Lock()
+	defer x.Unlock()
+
+	return x.stopping
+}
+
+// Stop the XSWD server once in-flight requests are handled
+// New requests and applications are rejected while waiting,
+// if requests are not handled before timeout the server is stopped anyway
+func (x *XSWD) StopGraceful(timeout time.Duration) {
+	x.Lock()
+	x.stopping = true
+	x.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		x.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		x.logger.Info("All in-flight requests handled, stopping XSWD server")
+	case <-time.After(timeout):
+		x.logger.Info("In-flight requests not handled in time, stopping XSWD server", "timeout", timeout)
+	}
+
+	x.Stop()
+}
+
+// Stop the XSWD server
+// This will close all the connections
+// and delete all applications
+func (x *XSWD) Stop() {
+	x.Lock()
+	x.running = false
+	x.cancel()
+
+	if err := x.server.Shutdown(context.Background()); err != nil {
+		x.logger.Error(err, "Error while stopping XSWD server")
+	}
+
+	applications := x.applications
+	for conn, app := range applications {
+		if app.IsRequesting() {
+			app.OnClose <- true
+		}
+
+		conn.shutdown()
+	}
+	x.applications = make(map[*Connection]ApplicationData)
+	x.Unlock()
+
+	for conn, app := range applications {
+		conn.wait()
+		x.notifyDisconnect(app, DisconnectStopped)
+	}
+
+	x.logger.Info("XSWD server stopped")
+}
+
+// Remove all applications while keeping the server running
+// Connections are closed outside of the applications lock so
+// any request being handled can still complete
+func (x *XSWD) RemoveAllApplications(reason string) {
+	x.Lock()
+	applications := x.applications
+	x.applications = make(map[*Connection]ApplicationData)
+	x.Unlock()
+
+	for conn, app := range applications {
+		if app.IsRequesting() {
+			app.OnClose <- true
+		}
+
+		if err := conn.Close(); err != nil {
+			x.logger.Error(err, "error while closing websocket session")
+		}
+
+		x.notifyDisconnect(app, DisconnectRemoved)
+	}
+
+	x.logger.Info("All applications removed", "reason", reason, "count", len(applications))
+}
+
+// Remove applications connected for longer than d regardless of their activity,
+// so approvals can be rotated, it returns the number of applications removed
+func (x *XSWD) ReapOlderThan(d time.Duration) int {
+	x.Lock()
+	reaped := make(map[*Connection]ApplicationData)
+	for conn, app := range x.applications {
+		if time.Since(app.ConnectedAt) > d {
+			reaped[conn] = app
+			delete(x.applications, conn)
+		}
+	}
+	x.Unlock()
+
+	for conn, app := range reaped {
+		if app.IsRequesting() {
+			app.OnClose <- true
+		}
+
+		if err := conn.Close(); err != nil {
+			x.logger.Error(err, "error while closing websocket session")
+		}
+
+		x.logger.Info("Application reaped", "id", app.Id, "name", app.Name, "connected", app.ConnectedAt)
+		x.notifyDisconnect(app, DisconnectReaped)
+	}
+
+	return len(reaped)
+}
+
+// Register a custom method easily to be completely configurable
+func (x *XSWD) SetCustomMethod(method string, handler handler.Func) {
+	x.SetCustomMethodWithPolicy(method, handler, false)
+}
+
+// Register a custom method which can be flagged as alwaysAllow,
+// requests of alwaysAllow methods are granted without calling requestHandler
+func (x *XSWD) SetCustomMethodWithPolicy(method string, handler handler.Func, alwaysAllow bool) {
+	x.rpcHandler[method] = handler
+	// a server method replaced by the host is handled as a custom one
+	delete(x.builtinMethods, method)
+	if alwaysAllow {
+		x.alwaysAllow[method] = true
+	} else {
+		delete(x.alwaysAllow, method)
+	}
+}
+
+// Register a custom method under the server namespace so it never clashes with wallet or DERO. methods,
+// the full method name applications must request is returned
+// It is handled as any custom method, its permissions are requested and stored the same way
+func (x *XSWD) SetNamespacedMethod(name string, handler handler.Func, alwaysAllow bool) string {
+	method := x.namespace + name
+	x.SetCustomMethodWithPolicy(method, handler, alwaysAllow)
+
+	return method
+}
+
+// Get the namespace of methods registered with SetNamespacedMethod
+func (x *XSWD) Namespace() string {
+	return x.namespace
+}
+
+// Check if method is granted without calling requestHandler
+func (x *XSWD) IsAlwaysAllowed(method string) bool {
+	return x.alwaysAllow[method]
+}
+
+// Get all connected Applications
+// This will return a copy of the map
+func (x *XSWD) GetApplications() []ApplicationData {
+	return x.FindApplications(func(app ApplicationData) bool { return true })
+}
+
+// Get the connected Applications matching filter
+// This will return copies, filter is called with the applications lock held and can't call XSWD
+func (x *XSWD) FindApplications(filter func(app ApplicationData) bool) []ApplicationData {
+	x.Lock()
+	defer x.Unlock()
+
+	apps := make([]ApplicationData, 0, len(x.applications))
+	for _, app := range x.applications {
+		app.Transfers = len(x.recentTransfers(app.Id))
+		if filter(app) {
+			apps = append(apps, app.snapshot())
+		}
+	}
+
+	return apps
+}
+
+// Get the connected Applications with perm stored for method
+func (x *XSWD) AppsWithPermission(method string, perm Permission) []ApplicationData {
+	return x.FindApplications(func(app ApplicationData) bool {
+		p, ok := app.Permissions[method]
+		return ok && p == perm
+	})
+}
+
+// Get the connected Applications subscribed to event
+func (x *XSWD) AppsSubscribedTo(event rpc.EventType) []ApplicationData {
+	return x.FindApplications(func(app ApplicationData) bool {
+		return app.RegisteredEvents[event]
+	})
+}
+
+// Get the number of connected Applications without copying them
+func (x *XSWD) ApplicationCount() int {
+	x.Lock()
+	defer x.Unlock()
+
+	return len(x.applications)
+}
+
+// Get the max number of connected Applications, 0 if unlimited
+func (x *XSWD) MaxApplications() int {
+	return x.maxApplications
+}
+
+// Get a connected Application by its id
+func (x *XSWD) GetApplicationByID(app_id string) (app ApplicationData, found bool) {
+	x.Lock()
+	defer x.Unlock()
+
+	for _, a := range x.applications {
+		if strings.EqualFold(a.Id, app_id) {
+			a.Transfers = len(x.recentTransfers(a.Id))
+			a.Persisted = make(map[string]bool, len(a.Permissions))
+			for method, info := range x.permissionsInfo(&a) {
+				a.Persisted[method] = info.Persisted
+			}
+
+			return a.snapshot(), true
+		}
+	}
+
+	return
+}
+
+// Get the number of requests made per method by a connected Application
+// This will return a copy of the counters
+func (x *XSWD) MethodStats(app_id string) (stats map[string]uint64, found bool) {
+	x.Lock()
+	defer x.Unlock()
+
+	for _, a := range x.applications {
+		if strings.EqualFold(a.Id, app_id) {
+			stats = make(map[string]uint64, len(a.calls))
+			for method, count := range a.calls {
+				stats[method] = count
+			}
+
+			return stats, true
+		}
+	}
+
+	return
+}
+
+// Max number of distinct methods counted per application,
+// so an application calling random method names can't grow its counters
+const maxMethodStats = 256
+
+// Count a request made by the application to a method
+func (x *XSWD) countCall(app *ApplicationData, method string) {
+	if app.calls == nil {
+		return
+	}
+
+	x.Lock()
+	defer x.Unlock()
+
+	if _, ok := app.calls[method]; ok || len(app.calls) < maxMethodStats {
+		app.calls[method]++
+	}
+}
+
+// Set the last activity of the application of a session to now
+func (x *XSWD) updateLastActivity(conn *Connection) {
+	x.Lock()
+	defer x.Unlock()
+
+	if a, ok := x.applications[conn]; ok {
+		a.LastActivity = time.Now()
+		x.applications[conn] = a
+	}
+}
+
+// Remove an application
+// It will automatically close the connection
+// All sessions using the application Id are removed
+func (x *XSWD) RemoveApplication(app *ApplicationData) {
+	x.Lock()
+	removed := make(map[*Connection]ApplicationData)
+	for conn, a := range x.applications {
+		if a.Id == app.Id {
+			removed[conn] = x.removeApplication(conn, a)
+		}
+	}
+	x.Unlock()
+
+	for conn, a := range removed {
+		x.waitClosed(conn)
+		x.notifyDisconnect(a, DisconnectRemoved)
+	}
+}
+
+// Remove the application of a connection
+// It will automatically close the connection
+func (x *XSWD) RemoveApplicationByConnection(conn *Connection) {
+	x.Lock()
+	a, found := x.applications[conn]
+	if found {
+		x.removeApplication(conn, a)
+	}
+	x.Unlock()
+
+	if found {
+		x.waitClosed(conn)
+		x.notifyDisconnect(a, DisconnectRemoved)
+	}
+}
+
+// Pause all sessions of an application, its requests are cancelled and events are not sent
+// until it is resumed, the application stays connected. Returns false if it is not found
+func (x *XSWD) PauseApplication(app_id string) bool {
+	return x.setPaused(app_id, true)
+}
+
+// Resume a paused application, its subscriptions are kept
+func (x *XSWD) ResumeApplication(app_id string) bool {
+	return x.setPaused(app_id, false)
+}
+
+func (x *XSWD) setPaused(app_id string, paused bool) bool {
+	x.Lock()
+	defer x.Unlock()
+
+	found := false
+	for _, a := range x.applications {
+		if strings.EqualFold(a.Id, app_id) && a.paused != nil {
+			a.paused.Store(paused)
+			found = true
+		}
+	}
+
+	if found {
+		x.logger.Info("Application pause changed", "id", app_id, "paused", paused)
+	}
+
+	return found
+}
+
+// Remove the application of a session by its SessionID
+// It will automatically close the connection, returns false if the session is not found
+func (x *XSWD) RemoveApplicationBySession(session_id string) bool {
+	x.Lock()
+	var removed *ApplicationData
+	var removedConn *Connection
+	for conn, a := range x.applications {
+		if a.SessionID == session_id {
+			a = x.removeApplication(conn, a)
+			removed, removedConn = &a, conn
+			break
+		}
+	}
+	x.Unlock()
+
+	if removed != nil {
+		x.waitClosed(removedConn)
+		x.notifyDisconnect(*removed, DisconnectRemoved)
+	}
+
+	return removed != nil
+}
+
+// Delete an application, signal its prompt and shut down its connection
+// applications lock must be held, the caller waits for the connection with waitClosed once unlocked
+func (x *XSWD) removeApplication(conn *Connection, a ApplicationData) ApplicationData {
+	delete(x.applications, conn)
+	if a.IsRequesting() {
+		a.OnClose <- true
+	}
+
+	conn.shutdown()
+
+	return a
+}
+
+// Wait for a connection shut down by removeApplication to be closed,
+// the applications lock must not be held as a client not reading its messages delays it
+func (x *XSWD) waitClosed(conn *Connection) {
+	if err := conn.wait(); err != nil {
+		x.logger.Error(err, "error while closing websocket session")
+	}
+}
+
+// Check if a application exist by its id
+func (x *XSWD) HasApplicationId(app_id string) bool {
+	x.Lock()
+	defer x.Unlock()
+
+	for _, a := range x.applications {
+		if strings.EqualFold(a.Id, app_id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify the name, description and url of an application
+// returns the step and reason if invalid, empty otherwise
+func (x *XSWD) checkMetadata(app *ApplicationData) (step ValidationStep, response string) {
+	if len(strings.TrimSpace(app.Name)) == 0 || !x.isValidText(app.Name) {
+		step = ValidateName
+		response = "Invalid name"
+		x.logger.V(1).Info(response, "name", len(app.Name))
+		return
+	}
+
+	if len(strings.TrimSpace(app.Description)) == 0 || !x.isValidText(app.Description) {
+		step = ValidateDescription
+		response = "Invalid description"
+		x.logger.V(1).Info(response, "description", len(app.Description))
+		return
+	}
+
+	// Verify that the website url set matches origin (security check)
+	if len(app.origin) > 0 && !x.urlMatchesOrigin(app.Url, app.origin) {
+		step = ValidateOrigin
+		response = "Invalid URL compared to origin"
+		x.logger.V(1).Info(response, "origin", app.origin, "url", app.Url)
+		return
+	}
+
+	// URL can be optional
+	if len(app.Url) > 255 {
+		step = ValidateURL
+		response = "Invalid URL"
+		x.logger.V(1).Info(response, "url", len(app.Url))
+		return
+	}
+
+	// Check that URL is starting with an allowed protocol
+	if !x.isURLSchemeAllowed(app.Url) {
+		step = ValidateURL
+		response = "Invalid application URL"
+		x.logger.V(1).Info(response, "url", app.Url, "schemes", x.urlSchemes)
+		return
+	}
+
+	// Url can't impersonate the wallet itself
+	if x.isServerURL(app.Url) {
+		step = ValidateURL
+		response = "Application URL points to XSWD server"
+		x.logger.V(1).Info(response, "url", app.Url)
+		return
+	}
+
+	// A remote application can't present itself as a local one
+	if x.localURL && len(app.origin) > 0 && !isLoopbackURL(app.origin) && isLoopbackURL(app.Url) {
+		step = ValidateURL
+		response = "Local application URL from a remote origin"
+		x.logger.V(1).Info(response, "origin", app.origin, "url", app.Url)
+		return
+	}
+
+	return
+}
+
+// Check if host is localhost or a loopback address, names are not resolved
+func isLoopbackHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Check if the host of raw_url is localhost or a loopback address
+func isLoopbackURL(raw_url string) bool {
+	u, err := url.Parse(raw_url)
+	return err == nil && isLoopbackHost(u.Hostname())
+}
+
+// Check if raw_url points to the XSWD server, on its port and on a loopback, unspecified or its listening host
+func (x *XSWD) isServerURL(raw_url string) bool {
+	u, err := url.Parse(raw_url)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+
+	port := u.Port()
+	if port == "" {
+		switch strings.ToLower(u.Scheme) {
+		case "http", "ws":
+			port = "80"
+		case "https", "wss":
+			port = "443"
+		}
+	}
+
+	if port != fmt.Sprint(x.port) {
+		return false
+	}
+
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return true
+	}
+
+	return isLoopbackHost(host) || (x.host != "" && strings.EqualFold(host, x.host))
+}
+
+// Get the origin of a request, forwarded by the reverse proxy if trusted
+func (x *XSWD) requestOrigin(r *http.Request) string {
+	if x.trustedProxy {
+		if origin := r.Header.Get("X-Forwarded-Origin"); origin != "" {
+			return origin
+		}
+	}
+
+	return r.Header.Get("Origin")
+}
+
+// Get the remote address of a request, forwarded by the reverse proxy if trusted
+func (x *XSWD) remoteAddr(r *http.Request) string {
+	if x.trustedProxy {
+		// first address is the client, others are proxies
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+		}
+	}
+
+	return r.RemoteAddr
+}
+
+// Check if url starts with one of the allowed schemes
+func (x *XSWD) isURLSchemeAllowed(url string) bool {
+	for _, scheme := range x.urlSchemes {
+		if strings.HasPrefix(url, scheme+"://") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Compare an application Url to its session origin according to the origin policy
+func (x *XSWD) urlMatchesOrigin(app_url, origin string) bool {
+	switch x.originPolicy {
+	case OriginIgnore:
+		return true
+	case OriginPrefix:
+		u, err := url.Parse(app_url)
+		if err != nil {
+			return false
+		}
+
+		o, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+
+		return u.Hostname() != "" && strings.EqualFold(u.Hostname(), o.Hostname())
+	default:
+		return app_url == origin
+	}
+}
+
+// Update the name, description and url of every session of a connected application, empty values are left unchanged
+// Id and Signature of the application can't be updated
+func (x *XSWD) UpdateApplicationMetadata(app *ApplicationData, name, description, url string) error {
+	updated := ApplicationData{Name: app.Name, Description: app.Description, Url: app.Url, origin: app.origin}
+	if name != "" {
+		updated.Name = name
+	}
+
+	if description != "" {
+		updated.Description = description
+	}
+
+	if url != "" {
+		updated.Url = url
+	}
+
+	if _, response := x.checkMetadata(&updated); response != "" {
+		return fmt.Errorf("%s", response)
+	}
+
+	if x.uniqueURL && x.isURLUsedByOther(updated.Url, app.Id) {
+		return fmt.Errorf("URL already in use by another application")
+	}
+
+	x.Lock()
+	defer x.Unlock()
+
+	// every session of the application is updated so they are shown the same
+	for conn, a := range x.applications {
+		if strings.EqualFold(a.Id, app.Id) {
+			a.Name, a.Description, a.Url = updated.Name, updated.Description, updated.Url
+			x.applications[conn] = a
+		}
+	}
+
+	app.Name, app.Description, app.Url = updated.Name, updated.Description, updated.Url
+	x.logger.Info("Application metadata updated", "id", app.Id, "name", app.Name)
+
+	return nil
+}
+
+// Check if url is used by an application with a different id
+func (x *XSWD) isURLUsedByOther(url, app_id string) bool {
+	x.Lock()
+	defer x.Unlock()
+
+	for _, a := range x.applications {
+		if a.Url == url && !strings.EqualFold(a.Id, app_id) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Filter the requested permissions which can be stored for an application
+func (x *XSWD) validPermissions(requested map[string]Permission) map[string]Permission {
+	validPermissions := map[string]Permission{}
+	normalizedMethods := map[string]Permission{}
+
+	for n, p := range requested {
+		if strings.HasPrefix(n, "DERO.") {
+			x.logger.V(1).Info("Daemon requests are AlwaysAllow", n, p)
+			continue
+		}
+
+		// Ensure we are not storing Allow, Deny or SessionAllow permissions as they are not persisted
+		if p == Allow || p == Deny || p == SessionAllow {
+			x.logger.V(1).Info("Invalid permission requested", n, p)
+			continue
+		}
+
+		// Always Ask for custom methods
+		if _, ok := x.rpcHandler[n]; !ok {
+			x.logger.V(1).Info("Invalid method requested", n, p)
+			continue
+		}
+
+		// Check if wallet defined method as noStore
+		if p == AlwaysAllow && !x.CanStorePermission(n) {
+			x.logger.V(1).Info("Method not allowed AlwaysAllow permission", n, p)
+			continue
+		}
+
+		// Normalize all method names
+		normalized := strings.ToLower(strings.ReplaceAll(n, "_", ""))
+
+		// Ensure if permission is added already under another method name, it matches (GetAddress == getaddress)
+		if pcheck, ok := normalizedMethods[normalized]; ok && pcheck != p {
+			x.logger.V(1).Info("Conflicting permissions for", n, p)
+			continue
+		}
+
+		x.logger.Info("Permission requested for", n, p)
+		normalizedMethods[normalized] = p
+		validPermissions[n] = p
+	}
+
+	return validPermissions
+}
+
+// Application pre-approved with PreApprove
+type preApproval struct {
+	url         string
+	permissions map[string]Permission
+}
+
+// Pre-approve an application ID and Url so it connects without calling appHandler
+// permissions are seeded to the application when it connects, subject to CanStorePermission
+// Id and Url are declared by the application so a pre-approval is only trusted from a browser session
+// whose origin matches url, a session without origin such as a local program goes through appHandler.
+// A local program can still forge its Origin header, only pre-approve permissions safe to grant to it
+func (x *XSWD) PreApprove(id, url string, permissions map[string]Permission) {
+	x.Lock()
+	defer x.Unlock()
+
+	x.preApproved[strings.ToLower(id)] = preApproval{url: url, permissions: permissions}
+	x.logger.V(1).Info("Application pre-approved", "id", id, "url", url, "permissions", len(permissions))
+}
+
+// Get the permissions of a pre-approved application, its Url must be the pre-approved one
+// and the origin of its session must be the one of the pre-approved url
+func (x *XSWD) preApprovedPermissions(app *ApplicationData) (permissions map[string]Permission, ok bool) {
+	x.Lock()
+	approval, ok := x.preApproved[strings.ToLower(app.Id)]
+	x.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	if app.Url != approval.url || len(app.origin) == 0 || !strings.EqualFold(app.origin, urlOrigin(approval.url)) {
+		x.logger.Info("Pre-approved application ID with another Url or origin", "id", app.Id, "url", app.Url, "origin", app.origin)
+		return nil, false
+	}
+
+	return approval.permissions, true
+}
+
+// Origin of a url as sent by browsers, scheme and host with its port
+func urlOrigin(raw_url string) string {
+	u, err := url.Parse(raw_url)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+
+	return u.Scheme + "://" + u.Host
+}
+
+// Define a permissions template which can be selected when accepting an application,
+// permissions are seeded to the application subject to CanStorePermission
+func (x *XSWD) DefineTemplate(name string, permissions map[string]Permission) {
+	x.Lock()
+	defer x.Unlock()
+
+	x.templates[name] = permissions
+	x.logger.V(1).Info("Permissions template defined", "name", name, "permissions", len(permissions))
+}
+
+// Get the permissions of a template by its name
+func (x *XSWD) templatePermissions(name string) (permissions map[string]Permission, ok bool) {
+	x.Lock()
+	defer x.Unlock()
+
+	permissions, ok = x.templates[name]
+	return
+}
+
+// Request the user to accept the application, templateHandler is used over appHandler if set
+func (x *XSWD) requestApplication(app *ApplicationData) (accepted bool, template string) {
+	if x.templateHandler != nil {
+		return x.templateHandler(app)
+	}
+
+	return x.appHandler(app), ""
+}
+
+// Verify the application data independently of the connected applications,
+// returns the step and reason if invalid, empty otherwise
+// If challenge is set, the signature must include the challenge issued to the session
+func (x *XSWD) checkApplication(app *ApplicationData, challenge bool) (step ValidationStep, response string) {
+	id := strings.TrimSpace(app.Id)
+	if len(id) != 64 {
+		step = ValidateID
+		response = "Invalid ID size"
+		x.logger.V(1).Info(response, "ID", app.Id)
+		return
+	}
+
+	if _, err := hex.DecodeString(id); err != nil {
+		step = ValidateID
+		response = "Invalid hexadecimal ID"
+		x.logger.V(1).Info(response, "ID", app.Id)
+		return
+	}
+
+	if step, response = x.checkMetadata(app); response != "" {
+		return
+	}
+
+	if !x.HasWallet(app.Wallet) {
+		step = ValidateWallet
+		response = "Wallet not registered"
+		x.logger.V(1).Info(response, "wallet", app.Wallet)
+		return
+	}
+
+	// Signature can be optional but if provided it must be valid for app to be added
+	// and is a requirement for permissions to be set upon initial connection
+	if len(app.Signature) > 0 {
+		if len(app.Signature) > 512 {
+			step = ValidateSignature
+			response = "Invalid signature size"
+			x.logger.V(1).Info(response, "signature", len(app.Signature))
+			return
+		}
+
+		signer, message, err := x.wallet.CheckSignature(app.Signature)
+		if err != nil {
+			step = ValidateSignature
+			response = "Invalid signature"
+			x.logger.V(1).Info(response, "signature", string(app.Signature))
+			return
+		}
+
+		if !signer.IsDERONetwork() {
+			step = ValidateSigner
+			response = "Signer does not belong to DERO network"
+			x.logger.V(1).Info(response, "signer", signer.String())
+			return
+		}
+
+		// Signature message must match app ID, or app ID + challenge when issued,
+		// optionally followed by the hash of the permissions manifest
+		mcheck := strings.TrimSpace(string(message))
+		expected := app.Id
+		if challenge {
+			expected += app.challenge
+		}
+
+		if manifest := strings.TrimPrefix(mcheck, expected); manifest != mcheck && len(manifest) == sha256.Size*2 {
+			if hash := PermissionsHash(app.Permissions); !strings.EqualFold(manifest, hash) {
+				step = ValidateManifest
+				response = "Signed permissions do not match requested permissions"
+				x.logger.V(1).Info(response, "manifest", manifest, "permissions", hash)
+				return
+			}
+		} else if mcheck != expected {
+			step = ValidateSignatureID
+			if challenge {
+				response = "Signature does not match ID and challenge"
+			} else {
+				response = "Signature does not match ID"
+			}
+			x.logger.V(1).Info(response, app.Id, mcheck)
+			return
+		}
+
+		x.logger.V(1).Info("Signature matches ID", app.Id, mcheck)
+	} else if app.Permissions != nil && len(app.Permissions) > 0 {
+		step = ValidatePermissions
+		response = "Application is requesting permissions without signature"
+		x.logger.V(1).Info(response, app.Name, app.Id)
+		return
+	}
+
+	// Check permission len
+	if len(app.Permissions) > 255 {
+		step = ValidatePermissions
+		response = "Invalid permissions"
+		x.logger.V(1).Info(response, "permissions", len(app.Permissions))
+		return
+	}
+
+	return
+}
+
+// Hash of the permissions manifest an application can sign after its ID (and challenge),
+// so the permissions it requests can't be tampered with. The manifest is a line "method=permission"
+// for each permission sorted by method, with the permission as its number, hashed with SHA-256 in hexadecimal
+func PermissionsHash(permissions map[string]Permission) string {
+	methods := make([]string, 0, len(permissions))
+	for m := range permissions {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+
+	var manifest bytes.Buffer
+	for _, m := range methods {
+		fmt.Fprintf(&manifest, "%s=%d\n", m, permissions[m])
+	}
+
+	hash := sha256.Sum256(manifest.Bytes())
+	return hex.EncodeToString(hash[:])
+}
+
+// Add an application from a websocket connection,
 // it verifies that application is valid and will add it to the application list if user accepts the request
-func (x *XSWD) addApplication(r *http.Request, conn *Connection, app *ApplicationData) (response string, accepted bool) {
+// reason and step of the response are set for the message provider
+func (x *XSWD) addApplication(r *http.Request, conn *Connection, app *ApplicationData) (reason AuthorizationReason, step ValidationStep, response string, accepted bool) {
+	var permissions map[string]Permission
+	var preApproved bool
+
 	// Sanity check
 	{
-		id := strings.TrimSpace(app.Id)
-		if len(id) != 64 {
-			response = "Invalid ID size"
-			x.logger.V(1).Info(response, "ID", app.Id)
+		app.origin = x.requestOrigin(r)
+		if len(app.Url) == 0 {
+			app.Url = app.origin
+			if len(app.Url) > 0 {
+				x.logger.V(1).Info("No URL passed, checking origin header")
+			}
+		}
+
+		permissions, preApproved = x.preApprovedPermissions(app)
+
+		if step, response = x.checkApplication(app, x.challenge); response != "" {
+			reason = AuthorizationInvalid
+			return
+		}
+
+		// Check that we don't already have this application
+		if x.HasApplicationId(app.Id) {
+			reason, response = AuthorizationIDUsed, "Application ID already added"
+			return
+		}
+
+		if x.maxApplications > 0 && x.ApplicationCount() >= x.maxApplications {
+			reason, response = AuthorizationMaxApplications, "Maximum applications reached"
+			x.logger.V(1).Info(response, "max", x.maxApplications)
+			return
+		}
+
+		// Prevent an application from using the URL of another one if enabled
+		if x.uniqueURL && x.isURLUsedByOther(app.Url, app.Id) {
+			reason, response = AuthorizationURLUsed, "URL already in use by another application"
+			x.logger.V(1).Info(response, "url", app.Url)
+			return
+		}
+
+		x.logger.Info(fmt.Sprintf("Application %s (%s) is requesting access to your wallet", app.Name, app.Url))
+
+		// If forceAsk all permissions will default to Ask
+		if !x.forceAsk {
+			app.Permissions = x.validPermissions(app.Permissions)
+		} else {
+			app.Permissions = map[string]Permission{}
+		}
+
+		// Pre-approved application permissions are seeded by the wallet
+		if preApproved {
+			app.Permissions = x.validPermissions(permissions)
+		}
+
+		if len(app.Permissions) == 0 {
+			x.logger.Info("All wallet requests will Ask for your permission")
+		}
+	}
+
+	// only one prompt at a time
+	x.promptMutex.Lock()
+	defer x.promptMutex.Unlock()
+
+	app.OnClose = make(chan bool)
+	app.limiter = rate.NewLimiter(x.rateLimit, x.rateBurst+x.graceBurst)
+	app.exempt = rate.NewLimiter(x.exemptRateLimit, x.exemptRateBurst)
+	app.SessionID = fmt.Sprintf("%016x", x.sessions.Add(1))
+	// check the permission from user, unless application is pre-approved
+	app.SetIsRequesting(true)
+	approved, template := preApproved, ""
+	if !preApproved {
+		approved, template = x.requestApplication(app)
+	}
+
+	if approved {
+		app.SetIsRequesting(false)
+
+		// Seed the permissions of the template selected by the user
+		if template != "" {
+			if permissions, ok := x.templatePermissions(template); ok {
+				for n, p := range x.validPermissions(permissions) {
+					app.Permissions[n] = p
+				}
+			} else {
+				x.logger.V(1).Info("Permissions template not found", "name", template)
+			}
+		}
+
+		// Create the map
+		app.RegisteredEvents = map[rpc.EventType]bool{}
+		app.transactions = map[string]bool{}
+		app.subscriptions = map[rpc.EventType]string{}
+		app.calls = map[string]uint64{}
+		app.thresholds = map[string]uint64{}
+		app.grantedAt = map[string]time.Time{}
+		app.paused = new(atomic.Bool)
+		app.handling = new(sync.Mutex)
+		app.ConnectedAt = time.Now()
+		app.LastActivity = app.ConnectedAt
+
+		// check if server has stopped while in appHandler, under the same lock as Stop
+		// so the application is either tracked before Stop resets them or rejected
+		// max applications is checked again as others may have been added while the user was asked
+		x.Lock()
+		running := x.running
+		full := x.maxApplications > 0 && len(x.applications) >= x.maxApplications
+		if running && !full {
+			x.applications[conn] = *app
+		}
+		x.Unlock()
+
+		if !running {
+			conn.Close()
+			reason, response = AuthorizationOffline, "XSWD is offline"
+			x.logger.Info(response, "id", app.Id, "name", app.Name, "description", app.Description, "url", app.Url)
+			return
+		}
+
+		if full {
+			reason, response = AuthorizationMaxApplications, "Maximum applications reached"
+			x.logger.Info(response, "id", app.Id, "name", app.Name, "max", x.maxApplications)
 			return
 		}
 
-		if _, err := hex.DecodeString(id); err != nil {
-			response = "Invalid hexadecimal ID"
-			x.logger.V(1).Info(response, "ID", app.Id)
-			return
+		x.broadcastApplicationChange(rpc.AppConnected, app, "")
+
+		accepted = true
+		reason, response = AuthorizationAccepted, "User has authorized the application"
+		if preApproved {
+			reason, response = AuthorizationPreApproved, "Application is pre-approved"
+		}
+		x.logger.Info(response, "id", app.Id, "name", app.Name, "description", app.Description, "url", app.Url)
+		return
+	} else {
+		app.SetIsRequesting(false)
+		reason, response = AuthorizationRejected, "User has rejected connection request"
+		x.logger.Info(response, "id", app.Id, "name", app.Name, "description", app.Description, "url", app.Url)
+	}
+
+	return
+}
+
+// Remove an application from the list for a session
+// only used in internal
+// Application is deleted before its connection is closed so only the first caller reports its reason
+func (x *XSWD) removeApplicationOfSession(conn *Connection, app *ApplicationData, reason DisconnectReason) {
+	if app != nil && app.IsRequesting() {
+		x.logger.Info(fmt.Sprintf("Closing %s request prompt", app.Name))
+		app.OnClose <- true
+	}
+
+	x.Lock()
+	vapp, found := x.applications[conn]
+	delete(x.applications, conn)
+	x.Unlock()
+
+	conn.Close()
+
+	if found {
+		x.logger.Info("Application deleted", "id", vapp.Id, "name", vapp.Name, "description", vapp.Description, "url", vapp.Url, "reason", reason)
+		x.notifyDisconnect(vapp, reason)
+	}
+}
+
+// Broadcast the connection or disconnection of app to the other applications subscribed to event,
+// it is never cached as the latest event so it is not replayed to new subscribers
+func (x *XSWD) broadcastApplicationChange(event rpc.EventType, app *ApplicationData, reason DisconnectReason) {
+	value := ApplicationChange{Id: app.Id, Name: app.Name, Url: app.Url, Reason: reason}
+	x.broadcastEvent(event, value, func(a *ApplicationData) bool {
+		return !strings.EqualFold(a.Id, app.Id)
+	})
+}
+
+// Report a disconnected application to the other applications and onDisconnect callback if any,
+// it must be called without holding the applications lock
+func (x *XSWD) notifyDisconnect(app ApplicationData, reason DisconnectReason) {
+	x.broadcastApplicationChange(rpc.AppDisconnected, &app, reason)
+
+	if x.onDisconnect != nil {
+		x.onDisconnect(&app, reason)
+	}
+}
+
+// Report a stored permission change to onPermissionChange callback if any,
+// it must be called without holding the applications lock
+func (x *XSWD) notifyPermissionChange(app_id, method string, perm Permission) {
+	if x.onPermissionChange != nil {
+		x.onPermissionChange(app_id, method, perm)
+	}
+}
+
+// Max methods suggested when a method is not found
+const maxMethodSuggestions = 3
+
+// Methods with a name close to method, case-insensitive prefixes first then by edit distance
+func (x *XSWD) suggestMethods(method string) []string {
+	type suggestion struct {
+		name     string
+		distance int
+	}
+
+	lower := strings.ToLower(method)
+	// a few typos are tolerated, more for longer names
+	tolerance := len(lower)/3 + 1
+
+	names := make([]string, 0, len(x.rpcHandler)+len(x.daemonMethods))
+	for name := range x.rpcHandler {
+		names = append(names, name)
+	}
+	names = append(names, x.daemonMethods...)
+
+	var suggestions []suggestion
+	for _, name := range names {
+		lname := strings.ToLower(name)
+		distance := levenshtein(lower, lname)
+		if lower != "" && strings.HasPrefix(lname, lower) {
+			distance = 0
+		}
+
+		if distance <= tolerance {
+			suggestions = append(suggestions, suggestion{name, distance})
+		}
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].distance != suggestions[j].distance {
+			return suggestions[i].distance < suggestions[j].distance
+		}
+		return suggestions[i].name < suggestions[j].name
+	})
+
+	names = make([]string, 0, maxMethodSuggestions)
+	for i := 0; i < len(suggestions) && i < maxMethodSuggestions; i++ {
+		names = append(names, suggestions[i].name)
+	}
+
+	return names
+}
+
+// Check if method is a known DERO. method of the daemon, any is known without daemonMethods
+func (x *XSWD) isDaemonMethod(method string) bool {
+	if len(x.daemonMethods) == 0 {
+		return true
+	}
+
+	for _, m := range x.daemonMethods {
+		if m == method {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			// deletion, insertion or substitution
+			curr[j] = prev[j] + 1
+			if curr[j-1]+1 < curr[j] {
+				curr[j] = curr[j-1] + 1
+			}
+
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			if prev[j-1]+cost < curr[j] {
+				curr[j] = prev[j-1] + cost
+			}
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// Handle a RPC Request from a session
+// We check that the method exists, that the application has the permission to use it
+// ctx is the session context, daemon calls are cancelled when it is done
+func (x *XSWD) handleMessage(ctx context.Context, app *ApplicationData, request *jrpc2.Request) (response interface{}) {
+	// permission applied to the request, Deny unless it is allowed below
+	perm := Deny
+	defer func() {
+		x.auditRequest(app, request, perm, response)
+	}()
+
+	methodName := request.Method()
+	x.countCall(app, methodName)
+
+	// paused application can only check the server is alive
+	if app.IsPaused() && methodName != "Ping" {
+		x.logger.V(1).Info("Application is paused", "method", methodName, "app", app.Name)
+		return ResponseWithError(request, jrpc2.Errorf(code.Cancelled, "application paused"))
+	}
+
+	if x.IsWalletLocked() {
+		x.logger.V(1).Info("Wallet is locked", "method", methodName)
+		return ResponseWithError(request, jrpc2.Errorf(code.Cancelled, "wallet is locked"))
+	}
+
+	handler := x.rpcHandler[methodName]
+
+	// Check that the method exists
+	if handler == nil {
+		// Only requests methods starting with DERO. are sent to daemon
+		if strings.HasPrefix(methodName, "DERO.") {
+			// if daemon is online, request the daemon
+			// wallet play the proxy here
+			// and because no sensitive data can be obtained, we allow without requests
+			if x.daemon.IsOnline() {
+				perm = Allow
+				// params are forwarded as is, those which are not an object or an array are rejected
+				// when the request is parsed and a json.RawMessage can't fail to be unmarshaled,
+				// so invalid params fields are only reported by the daemon
+				var params json.RawMessage
+				request.UnmarshalParams(&params)
+
+				x.logger.V(2).Info("requesting daemon with", "method", request.Method(), "param", request.ParamString())
+				result, err := x.callDaemon(ctx, request.Method(), params)
+				if err != nil {
+					if ctx.Err() != nil {
+						x.logger.V(1).Info("Daemon call cancelled, application disconnected", "method", request.Method())
+						return nil
+					}
+
+					x.logger.V(1).Error(err, "Error on daemon call")
+
+					// daemon responded with an error, its code is kept
+					if derr, ok := err.(*jrpc2.Error); ok {
+						data := DaemonError_Data{Source: DaemonErrorDaemon}
+						return ResponseWithError(request, jrpc2.Errorf(derr.Code, "Error on daemon call: %q", derr.Message).WithData(data))
+					}
+
+					data := DaemonError_Data{Source: DaemonErrorCall}
+					return ResponseWithError(request, jrpc2.Errorf(code.InvalidRequest, "Error on daemon call: %q", err.Error()).WithData(data))
+				}
+
+				// we set original ID
+				result.SetID(request.ID())
+
+				// Raw result is forwarded as is, so large integers don't lose precision as float64
+				var daemon_response json.RawMessage
+				err = result.UnmarshalResult(&daemon_response)
+				if err != nil {
+					x.logger.V(1).Error(err, "Error on unmarshal daemon result")
+					return ResponseWithError(request, jrpc2.Errorf(code.InternalError, "Error on unmarshal daemon call: %q", err.Error()))
+				}
+
+				json, err := result.MarshalJSON()
+				if err != nil {
+					x.logger.V(1).Error(err, "Error on marshal daemon response")
+					return ResponseWithError(request, jrpc2.Errorf(code.InternalError, "Error on marshal daemon call: %q", err.Error()))
+				}
+
+				x.logger.V(2).Info("received response", "response", string(json))
+
+				return ResponseWithResult(request, daemon_response)
+			} else if x.isDaemonMethod(methodName) {
+				x.logger.V(1).Info("Daemon is offline", "endpoint", x.wallet.Daemon_Endpoint)
+				return ResponseWithError(request, jrpc2.Errorf(code.Cancelled, "daemon %s is offline", x.wallet.Daemon_Endpoint))
+			}
+
+			// unknown daemon method is not found rather than cancelled, it would fail once the daemon is online
 		}
 
-		if len(strings.TrimSpace(app.Name)) == 0 || len(app.Name) > 255 || !isASCII(app.Name) {
-			response = "Invalid name"
-			x.logger.V(1).Info(response, "name", len(app.Name))
-			return
+		x.logger.Info("RPC Method not found", "method", methodName)
+		data := MethodNotFound_Data{Method: methodName, Suggestions: x.suggestMethods(methodName)}
+		return ResponseWithError(request, jrpc2.Errorf(code.MethodNotFound, "method %q not found", methodName).WithData(data))
+	}
+
+	// reject malformed params before prompting the user
+	if err := validateParams(request); err != nil {
+		x.logger.V(1).Info("Invalid params for method", "method", methodName, "error", err.Error())
+		return ResponseWithError(request, jrpc2.Errorf(code.InvalidParams, "Invalid params for method %q: %v", methodName, err))
+	}
+
+	// a switched account is detected before a request can get the new identity
+	x.CheckAccount()
+
+	// requests of the session are handled one at a time
+	app.handling.Lock()
+	defer app.handling.Unlock()
+
+	// check that we still have the application connected
+	// otherwise don't accept as it may disconnected between both requests
+	if !x.HasApplicationId(app.Id) {
+		return nil
+	}
+
+	// the user isn't asked for a transfer over the limit
+	if _, data := x.checkTransferLimit(app, methodName, false); data != nil {
+		return x.transferLimitError(app, request, data)
+	}
+
+	app.SetIsRequesting(true)
+	perm, stored := x.requestPermission(app, request)
+	app.SetIsRequesting(false)
+	if perm.IsPositive() {
+		// sessions wait in turn for a handler slot once the permission is resolved, so a user prompt
+		// doesn't hold a slot, RequestPermissions handler waits on prompts and isn't bounded
+		if methodName != "RequestPermissions" || !x.builtinMethods[methodName] {
+			if !x.acquireHandler(ctx) {
+				return nil
+			}
+			defer x.releaseHandler()
 		}
 
-		if len(strings.TrimSpace(app.Description)) == 0 || len(app.Description) > 255 || !isASCII(app.Description) {
-			response = "Invalid description"
-			x.logger.V(1).Info(response, "description", len(app.Description))
-			return
+		// another session of the application may have sent a transfer while the user was asked
+		reserved, data := x.checkTransferLimit(app, methodName, true)
+		if data != nil {
+			return x.transferLimitError(app, request, data)
 		}
 
-		origin := r.Header.Get("Origin")
-		if len(app.Url) == 0 {
-			app.Url = origin
-			if len(app.Url) > 0 {
-				x.logger.V(1).Info("No URL passed, checking origin header")
+		// Extra is copied as handlers of several sessions run concurrently
+		wallet_context := *x.walletContext(app.Wallet)
+		wallet_context.Extra = make(map[string]interface{}, len(wallet_context.Extra)+1)
+		for k, v := range x.walletContext(app.Wallet).Extra {
+			wallet_context.Extra[k] = v
+		}
+		wallet_context.Extra["app_data"] = app
+		timeout := x.handlerTimeoutOf(methodName)
+		handler_ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "wallet_context", &wallet_context))
+		if timeout > 0 {
+			handler_ctx, cancel = context.WithTimeout(handler_ctx, timeout)
+		}
+		defer cancel()
+
+		// handler runs apart so a handler ignoring its context can't hold the session and its handler slot
+		type handlerResult struct {
+			result interface{}
+			err    error
+		}
+		done := make(chan handlerResult, 1)
+		go func() {
+			result, err := handler(handler_ctx, request)
+			if err == nil {
+				// a transaction sent after the timeout still has its origin
+				x.trackTransaction(app, result)
+			} else {
+				// a failed transfer is not counted by the transfer limit
+				x.releaseTransfer(app, reserved)
+			}
+			done <- handlerResult{result, err}
+		}()
+
+		select {
+		case r := <-done:
+			if r.err != nil {
+				return ResponseWithError(request, jrpc2.Errorf(code.InternalError, "Error while handling request method %q: %v", methodName, r.err))
 			}
+
+			return ResponseWithResult(request, r.result)
+		case <-handler_ctx.Done():
+			x.logger.Info("Request method timed out", "method", methodName, "timeout", timeout)
+			return ResponseWithError(request, jrpc2.Errorf(code.DeadlineExceeded, "Request method %q timed out after %s", methodName, timeout))
+		}
+	} else {
+		code := PermissionDenied
+		if perm == AlwaysDeny {
+			code = PermissionAlwaysDenied
 		}
 
-		// Verify that the website url set is the same as origin (security check)
-		if len(origin) > 0 && app.Url != origin {
-			response = "Invalid URL compared to origin"
-			x.logger.V(1).Info(response, "origin", origin, "url", app.Url)
-			return
+		x.logger.Info(fmt.Sprintf("%s permission not granted for method", app.Name), "method", methodName)
+		data := PermissionDenied_Data{Method: methodName, Permission: perm.String(), Stored: stored}
+		return ResponseWithError(request, jrpc2.Errorf(code, "Permission not granted for method %q", methodName).WithData(data))
+	}
+}
+
+// Get the timeout of a method handler, method specific timeout is used over the default one
+// Server methods are not timed out by default, a late transfer would still be sent after the error
+// 0 is returned if the method has no timeout
+func (x *XSWD) handlerTimeoutOf(method string) time.Duration {
+	// RequestPermissions waits on the user like requestPermission prompts, the answers would be lost
+	if method == "RequestPermissions" && x.builtinMethods[method] {
+		return 0
+	}
+
+	if timeout, ok := x.methodTimeouts[method]; ok {
+		return timeout
+	}
+
+	if x.builtinMethods[method] {
+		return 0
+	}
+
+	return x.handlerTimeout
+}
+
+// Report the resolved request to onRequest callback if any
+// A nil response means the request was dropped as application disconnected
+func (x *XSWD) auditRequest(app *ApplicationData, request *jrpc2.Request, perm Permission, response interface{}) {
+	if x.onRequest == nil || response == nil {
+		return
+	}
+
+	var err error
+	if r, ok := response.(RPCResponse); ok {
+		if e, ok := r.Error.(*jrpc2.Error); ok && e != nil {
+			err = e
 		}
+	}
 
-		// URL can be optional
-		if len(app.Url) > 255 {
-			response = "Invalid URL"
-			x.logger.V(1).Info(response, "url", len(app.Url))
-			return
+	x.onRequest(app, request.Method(), perm, err)
+}
+
+// Lower the burst of the application limiter from its grace burst to the steady one as its grace period elapses,
+// requests available above the current burst are dropped by the limiter
+func (x *XSWD) decayGraceBurst(app *ApplicationData) {
+	if x.graceBurst <= 0 || app.limiter == nil || app.limiter.Burst() <= x.rateBurst {
+		return
+	}
+
+	burst := x.rateBurst
+	if elapsed := time.Since(app.ConnectedAt); elapsed < x.gracePeriod {
+		burst += int(float64(x.graceBurst) * (1 - float64(elapsed)/float64(x.gracePeriod)))
+	}
+
+	if burst < app.limiter.Burst() {
+		app.limiter.SetBurst(burst)
+	}
+}
+
+// Send RateLimitWarning to the application if subscribed and its available requests are below the threshold,
+// it returns if the application is currently warned so it is not warned again for each request
+func (x *XSWD) warnRateLimit(conn *Connection, app *ApplicationData, warned bool) bool {
+	if x.rateWarning <= 0 || app.limiter == nil {
+		return false
+	}
+
+	tokens := app.limiter.Tokens()
+	if tokens >= x.rateWarning {
+		return false
+	}
+
+	// RegisteredEvents is changed by the Subscribe handlers
+	x.Lock()
+	subscribed := app.RegisteredEvents[rpc.RateLimitWarning]
+	x.Unlock()
+
+	if !warned && subscribed {
+		x.logger.V(1).Info("Rate limit warning", "app", app.Name, "tokens", tokens)
+		value := GetRateLimit_Result{Limit: float64(app.limiter.Limit()), Burst: app.limiter.Burst(), Tokens: tokens}
+		if err := conn.Send(ResponseWithResult(nil, app.notification(rpc.RateLimitWarning, value))); err != nil {
+			x.logger.V(2).Error(err, "Error while sending rate limit warning")
 		}
+	}
 
-		// Check that URL is starting with valid protocol
-		if !(strings.HasPrefix(app.Url, "http://") || strings.HasPrefix(app.Url, "https://")) {
-			response = "Invalid application URL"
-			x.logger.V(1).Info(response, "url", app.Url)
-			return
+	return true
+}
+
+// Check if method requests are counted by the exempt rate limit instead of the requests one
+func (x *XSWD) IsRateLimitExempt(method string) bool {
+	for _, m := range x.rateExempt {
+		if m == method {
+			return true
 		}
+	}
 
-		// Signature can be optional but if provided it must be valid for app to be added
-		// and is a requirement for permissions to be set upon initial connection
-		if len(app.Signature) > 0 {
-			if len(app.Signature) > 512 {
-				response = "Invalid signature size"
-				x.logger.V(1).Info(response, "signature", len(app.Signature))
-				return
-			}
+	return false
+}
 
-			signer, message, err := x.wallet.CheckSignature(app.Signature)
-			if err != nil {
-				response = "Invalid signature"
-				x.logger.V(1).Info(response, "signature", string(app.Signature))
-				return
-			}
+// Check if method is allowed to store AlwaysAllow permission when adding application or user selection is made
+func (x *XSWD) CanStorePermission(method string) bool {
+	x.noStoreMutex.RLock()
+	defer x.noStoreMutex.RUnlock()
 
-			if !signer.IsDERONetwork() {
-				response = "Signer does not belong to DERO network"
-				x.logger.V(1).Info(response, "signer", signer.String())
-				return
-			}
+	for _, m := range x.noStore {
+		if m == method {
+			return false
+		}
+	}
 
-			// Signature message must match app ID
-			mcheck := strings.TrimSpace(string(message))
-			if mcheck != app.Id {
-				response = "Signature does not match ID"
-				x.logger.V(1).Info(response, app.Id, mcheck)
-				return
-			}
+	return true
+}
 
-			x.logger.V(1).Info("Signature matches ID", app.Id, mcheck)
-		} else if app.Permissions != nil && len(app.Permissions) > 0 {
-			response = "Application is requesting permissions without signature"
-			x.logger.V(1).Info(response, app.Name, app.Id)
+// Check if method won't store AlwaysAllow permission, so the user can't be offered to always allow it
+func (x *XSWD) IsNoStore(method string) bool {
+	return !x.CanStorePermission(method)
+}
+
+// Get a copy of the methods which won't store AlwaysAllow permission
+func (x *XSWD) NoStoreMethods() []string {
+	x.noStoreMutex.RLock()
+	defer x.noStoreMutex.RUnlock()
+
+	methods := make([]string, len(x.noStore))
+	copy(methods, x.noStore)
+
+	return methods
+}
+
+// Add a noStore method at runtime, AlwaysAllow permissions already stored for it are removed
+// It waits for any pending request, so it can't be called from a method handler
+func (x *XSWD) AddNoStore(method string) {
+	x.noStoreMutex.Lock()
+	for _, m := range x.noStore {
+		if m == method {
+			x.noStoreMutex.Unlock()
 			return
 		}
+	}
 
-		// Check that we don't already have this application
-		if x.HasApplicationId(app.Id) {
-			response = "Application ID already added"
+	// a new slice so DefaultNoStore is never modified
+	methods := make([]string, len(x.noStore), len(x.noStore)+1)
+	copy(methods, x.noStore)
+	x.noStore = append(methods, method)
+	x.noStoreMutex.Unlock()
+
+	var revoked []string
+	x.forEachApplication(func(app *ApplicationData) {
+		if perm, ok := app.Permissions[method]; ok && (perm == AlwaysAllow || perm == SessionAllow) {
+			delete(app.Permissions, method)
+			revoked = append(revoked, app.Id)
+			x.logger.V(1).Info("Stored permission removed for noStore method", "id", app.Id, "method", method, "permission", perm)
+		}
+	})
+
+	for _, id := range revoked {
+		x.notifyPermissionChange(id, method, Ask)
+	}
+}
+
+// Revoke the stored permission of a method for all sessions of an application,
+// next request of the method will Ask again. Returns false if no permission was stored
+// It waits for any pending request, so it can't be called from a method handler
+func (x *XSWD) RevokePermission(app_id, method string) bool {
+	revoked, _ := x.revokePermission(method, func(app *ApplicationData) bool {
+		return strings.EqualFold(app.Id, app_id)
+	})
+
+	return revoked
+}
+
+// Revoke the stored permission of a method for the session with session_id only,
+// other sessions sharing its application Id keep their permissions
+// It waits for any pending request, so it can't be called from a method handler
+func (x *XSWD) RevokeSessionPermission(session_id, method string) bool {
+	revoked, _ := x.revokePermission(method, func(app *ApplicationData) bool {
+		return app.SessionID == session_id
+	})
+
+	return revoked
+}
+
+// Call fn with the applications lock held on each application once its pending request is handled,
+// so permissions and thresholds are never modified while a request uses them
+func (x *XSWD) forEachApplication(fn func(app *ApplicationData)) {
+	x.Lock()
+	applications := make([]ApplicationData, 0, len(x.applications))
+	for _, app := range x.applications {
+		applications = append(applications, app)
+	}
+	x.Unlock()
+
+	for i := range applications {
+		app := &applications[i]
+		if app.handling != nil {
+			app.handling.Lock()
+		}
+
+		x.Lock()
+		fn(app)
+		x.Unlock()
+
+		if app.handling != nil {
+			app.handling.Unlock()
+		}
+	}
+}
+
+// Revoke the stored permission of a method for the applications matching filter
+func (x *XSWD) revokePermission(method string, filter func(app *ApplicationData) bool) (revoked bool, app_id string) {
+	x.forEachApplication(func(app *ApplicationData) {
+		if !filter(app) {
 			return
 		}
 
-		// Check permission len
-		if len(app.Permissions) > 255 {
-			response = "Invalid permissions"
-			x.logger.V(1).Info(response, "permissions", len(app.Permissions))
+		if perm, ok := app.Permissions[method]; ok && perm != Ask {
+			delete(app.Permissions, method)
+			revoked, app_id = true, app.Id
+			x.logger.Info("Permission revoked", "id", app.Id, "session", app.SessionID, "method", method, "permission", perm)
+		}
+	})
+
+	if revoked {
+		x.notifyPermissionChange(app_id, method, Ask)
+	}
+
+	return
+}
+
+// Remove a noStore method at runtime, its AlwaysAllow permission can be stored again
+func (x *XSWD) RemoveNoStore(method string) {
+	x.noStoreMutex.Lock()
+	defer x.noStoreMutex.Unlock()
+
+	methods := make([]string, 0, len(x.noStore))
+	for _, m := range x.noStore {
+		if m != method {
+			methods = append(methods, m)
+		}
+	}
+	x.noStore = methods
+}
+
+// Methods sending DERO which can be allowed below an amount with SetPermissionThreshold,
+// BuildSignedTransfer is one as its transaction can be broadcasted by the application with DERO.SendRawTransaction
+var transferMethods = map[string]bool{
+	"transfer":            true,
+	"Transfer":            true,
+	"transfer_split":      true,
+	"BuildSignedTransfer": true,
+}
+
+// Set the amount below which a transfer method is allowed without asking for all sessions of an application,
+// transfers at or above it and token or SC transfers still use the stored permission or requestHandler
+// A threshold of 0 removes it. It waits for any pending request, so it can't be called from a method handler
+func (x *XSWD) SetPermissionThreshold(app_id, method string, threshold uint64) error {
+	if !transferMethods[method] {
+		return fmt.Errorf("method %q can't have a threshold", method)
+	}
+
+	found := false
+	x.forEachApplication(func(app *ApplicationData) {
+		if !strings.EqualFold(app.Id, app_id) || app.thresholds == nil {
 			return
 		}
 
-		x.logger.Info(fmt.Sprintf("Application %s (%s) is requesting access to your wallet", app.Name, app.Url))
+		found = true
+		if threshold == 0 {
+			delete(app.thresholds, method)
+		} else {
+			app.thresholds[method] = threshold
+		}
+	})
 
-		// If forceAsk all permissions will default to Ask
-		if !x.forceAsk {
-			validPermissions := map[string]Permission{}
-			normalizedMethods := map[string]Permission{}
+	if !found {
+		return fmt.Errorf("application %q not found", app_id)
+	}
 
-			for n, p := range app.Permissions {
-				if strings.HasPrefix(n, "DERO.") {
-					x.logger.V(1).Info("Daemon requests are AlwaysAllow", n, p)
-					continue
-				}
+	x.logger.Info("Permission threshold set", "id", app_id, "method", method, "threshold", threshold)
+
+	return nil
+}
+
+// Total DERO amount sent and burned by a transfer request,
+// ok is false if it is not a transfer of DERO only so it can't be compared to a threshold
+func transferAmount(request *jrpc2.Request) (amount uint64, ok bool) {
+	if !transferMethods[request.Method()] {
+		return
+	}
+
+	var p rpc.Transfer_Params
+	if err := request.UnmarshalParams(&p); err != nil || len(p.Transfers) == 0 || p.SC_Code != "" || p.SC_ID != "" {
+		return
+	}
+
+	for _, t := range p.Transfers {
+		if !t.SCID.IsZero() {
+			return 0, false
+		}
+
+		// overflowing amounts can't be compared
+		sent := t.Amount + t.Burn
+		if sent < t.Amount || amount+sent < amount {
+			return 0, false
+		}
+		amount += sent
+	}
+
+	return amount, true
+}
+
+// Check if method sends a transaction counted by the transfer limit
+func sendsTransfer(method string) bool {
+	return transferMethods[method] || method == "scinvoke"
+}
+
+// Times of the transfers of an application within the window, older ones are dropped
+// It must be called while holding the applications mutex
+func (x *XSWD) recentTransfers(app_id string) []time.Time {
+	if x.transferLimit == 0 {
+		return nil
+	}
+
+	id := strings.ToLower(app_id)
+	times := x.transfers[id]
+	cutoff := time.Now().Add(-x.transferWindow)
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+
+	times = times[i:]
+	if len(times) == 0 {
+		delete(x.transfers, id)
+	} else {
+		x.transfers[id] = times
+	}
 
-				// Ensure we are not storing Allow or Deny permissions as they return positive/negative
-				if p == Allow || p == Deny {
-					x.logger.V(1).Info("Invalid permission requested", n, p)
-					continue
-				}
+	return times
+}
 
-				// Always Ask for custom methods
-				if _, ok := x.rpcHandler[n]; !ok {
-					x.logger.V(1).Info("Invalid method requested", n, p)
-					continue
-				}
+// Check if the application can send a transfer with method within the transfer limit,
+// reserve counts the transfer when it is allowed and returns its time to release it if it fails.
+// The error data is returned once the limit is reached
+func (x *XSWD) checkTransferLimit(app *ApplicationData, method string, reserve bool) (reserved time.Time, data *TransferLimitExceeded_Data) {
+	if x.transferLimit == 0 || !sendsTransfer(method) {
+		return
+	}
 
-				// Check if wallet defined method as noStore
-				if p == AlwaysAllow && !x.CanStorePermission(n) {
-					x.logger.V(1).Info("Method not allowed AlwaysAllow permission", n, p)
-					continue
-				}
+	x.Lock()
+	defer x.Unlock()
 
-				// Normalize all method names
-				normalized := strings.ToLower(strings.ReplaceAll(n, "_", ""))
+	times := x.recentTransfers(app.Id)
+	if len(times) >= x.transferLimit {
+		data = &TransferLimitExceeded_Data{
+			Limit:      x.transferLimit,
+			Window:     x.transferWindow.Seconds(),
+			RetryAfter: time.Until(times[0].Add(x.transferWindow)).Seconds(),
+		}
+		return
+	}
 
-				// Ensure if permission is added already under another method name, it matches (GetAddress == getaddress)
-				if pcheck, ok := normalizedMethods[normalized]; ok && pcheck != p {
-					x.logger.V(1).Info("Conflicting permissions for", n, p)
-					continue
-				}
+	if reserve {
+		reserved = time.Now()
+		x.transfers[strings.ToLower(app.Id)] = append(times, reserved)
+	}
 
-				x.logger.Info("Permission requested for", n, p)
-				normalizedMethods[normalized] = p
-				validPermissions[n] = p
-			}
+	return
+}
 
-			if len(validPermissions) > 0 {
-				app.Permissions = validPermissions
-			} else {
-				x.logger.Info("All wallet requests will Ask for your permission")
-				app.Permissions = map[string]Permission{}
-			}
-		} else {
-			x.logger.Info("All wallet requests will Ask for your permission")
-			app.Permissions = map[string]Permission{}
-		}
+// Release a transfer reserved by checkTransferLimit, nothing is done if reserved is zero
+func (x *XSWD) releaseTransfer(app *ApplicationData, reserved time.Time) {
+	if reserved.IsZero() {
+		return
 	}
 
-	// only one request at a time
-	x.handlerMutex.Lock()
-	defer x.handlerMutex.Unlock()
+	x.Lock()
+	defer x.Unlock()
 
-	app.OnClose = make(chan bool)
-	app.limiter = rate.NewLimiter(10.0, 20)
-	// check the permission from user
-	app.SetIsRequesting(true)
-	if x.appHandler(app) {
-		app.SetIsRequesting(false)
-		// check if server has stopped while in appHandler
-		if !x.running {
-			conn.Close()
-			response = "XSWD is offline"
-			x.logger.Info(response, "id", app.Id, "name", app.Name, "description", app.Description, "url", app.Url)
-			return
+	id := strings.ToLower(app.Id)
+	times := x.transfers[id]
+	for i, t := range times {
+		if t.Equal(reserved) {
+			times = append(times[:i:i], times[i+1:]...)
+			break
 		}
+	}
 
-		// Create the map
-		app.RegisteredEvents = map[rpc.EventType]bool{}
+	if len(times) == 0 {
+		delete(x.transfers, id)
+	} else {
+		x.transfers[id] = times
+	}
+}
 
-		x.Lock()
-		x.applications[conn] = *app
-		x.Unlock()
+// Response to a transfer over the limit of the application
+func (x *XSWD) transferLimitError(app *ApplicationData, request *jrpc2.Request, data *TransferLimitExceeded_Data) RPCResponse {
+	x.logger.Info("Transfer limit exceeded", "id", app.Id, "method", request.Method(), "limit", data.Limit)
+	return ResponseWithError(request, jrpc2.Errorf(TransferLimitExceeded, "Transfer limit of %d per %s exceeded", data.Limit, x.transferWindow).WithData(data))
+}
 
-		accepted = true
-		response = "User has authorized the application"
-		x.logger.Info(response, "id", app.Id, "name", app.Name, "description", app.Description, "url", app.Url)
-		return
-	} else {
-		app.SetIsRequesting(false)
-		response = "User has rejected connection request"
-		x.logger.Info(response, "id", app.Id, "name", app.Name, "description", app.Description, "url", app.Url)
+// Check if a transfer request sends more DERO than the confirmation amount set with WithTransferConfirmation,
+// token and SC transfers are not compared as their DERO amount can't be known
+func (x *XSWD) requiresConfirmation(request *jrpc2.Request) bool {
+	if x.confirmAbove == 0 {
+		return false
 	}
 
-	return
+	amount, ok := transferAmount(request)
+	return ok && amount > x.confirmAbove
 }
 
-// Remove an application from the list for a session
-// only used in internal
-func (x *XSWD) removeApplicationOfSession(conn *Connection, app *ApplicationData) {
-	if app != nil && app.IsRequesting() {
-		x.logger.Info(fmt.Sprintf("Closing %s request prompt", app.Name))
-		app.OnClose <- true
+// Request the permission for a method and save its result if it must be persisted,
+// stored is true if the permission was already stored for the application
+func (x *XSWD) requestPermission(app *ApplicationData, request *jrpc2.Request) (perm Permission, stored bool) {
+	method := request.Method()
+	if x.IsAlwaysAllowed(method) {
+		x.logger.V(1).Info("Method is always allowed", "method", method)
+		return Allow, false
 	}
-	conn.Close()
 
-	x.Lock()
-	vapp, found := x.applications[conn]
-	delete(x.applications, conn)
-	x.Unlock()
+	perm, found := app.Permissions[method]
+	if found && x.expirePermission(app, method) {
+		perm, found = Ask, false
+	}
 
-	if found {
-		x.logger.Info("Application deleted", "id", vapp.Id, "name", vapp.Name, "description", vapp.Description, "url", vapp.Url)
+	// high-value transfers are confirmed by the user even if they are allowed or below the threshold
+	confirm := perm != AlwaysDeny && x.requiresConfirmation(request)
+	if confirm {
+		x.logger.Info("Transfer above confirmation amount", "method", method, "permission", perm, "confirm_above", x.confirmAbove)
 	}
-}
 
-// Handle a RPC Request from a session
-// We check that the method exists, that the application has the permission to use it
-func (x *XSWD) handleMessage(app *ApplicationData, request *jrpc2.Request) interface{} {
-	methodName := request.Method()
-	handler := x.rpcHandler[methodName]
+	if !found || perm == Ask || confirm {
+		// transfers below the threshold set for the application are allowed without asking
+		if amount, ok := transferAmount(request); ok && !confirm && amount < app.thresholds[method] {
+			x.logger.V(1).Info("Transfer allowed below threshold", "method", method, "amount", amount, "threshold", app.thresholds[method])
+			return Allow, true
+		}
 
-	// Check that the method exists
-	if handler == nil {
-		// Only requests methods starting with DERO. are sent to daemon
-		if strings.HasPrefix(methodName, "DERO.") {
-			// if daemon is online, request the daemon
-			// wallet play the proxy here
-			// and because no sensitive data can be obtained, we allow without requests
-			if x.wallet.IsDaemonOnlineCached() {
-				var params json.RawMessage
-				err := request.UnmarshalParams(&params)
-				if err != nil {
-					x.logger.V(1).Error(err, "Error while unmarshaling params")
-					return ResponseWithError(request, jrpc2.Errorf(code.InvalidParams, "Error while unmarshaling params: %q", err.Error()))
-				}
+		x.promptMutex.Lock()
+		perm = x.requestHandler(app, request)
+		x.promptMutex.Unlock()
+		x.storePermission(app, method, perm)
 
-				x.logger.V(2).Info("requesting daemon with", "method", request.Method(), "param", request.ParamString())
-				result, err := walletapi.GetRPCClient().RPC.Call(context.Background(), request.Method(), params)
-				if err != nil {
-					x.logger.V(1).Error(err, "Error on daemon call")
-					return ResponseWithError(request, jrpc2.Errorf(code.InvalidRequest, "Error on daemon call: %q", err.Error()))
-				}
+		if perm.IsPositive() {
+			x.logger.Info("Permission granted", "method", method, "permission", perm)
+		} else {
+			x.logger.Info("Permission rejected", "method", method, "permission", perm)
+		}
+	} else {
+		x.logger.V(1).Info("Permission already granted for method", "method", method, "permission", perm)
+		stored = true
+	}
 
-				// we set original ID
-				result.SetID(request.ID())
+	return
+}
 
-				// Unmarshal result into response to sync wallet/daemon as RPCResponse type
-				var response interface{}
-				err = result.UnmarshalResult(&response)
-				if err != nil {
-					x.logger.V(1).Error(err, "Error on unmarshal daemon result")
-					return ResponseWithError(request, jrpc2.Errorf(code.InternalError, "Error on unmarshal daemon call: %q", err.Error()))
-				}
+// Check if a stored permission survives a restart, SessionAllow and noStore AlwaysAllow only last the session
+func (x *XSWD) isPersisted(method string, perm Permission) bool {
+	return perm == AlwaysDeny || (perm == AlwaysAllow && x.CanStorePermission(method))
+}
 
-				json, err := result.MarshalJSON()
-				if err != nil {
-					x.logger.V(1).Error(err, "Error on marshal daemon response")
-					return ResponseWithError(request, jrpc2.Errorf(code.InternalError, "Error on marshal daemon call: %q", err.Error()))
-				}
+// Stored permissions of an application annotated with their persistence
+func (x *XSWD) permissionsInfo(app *ApplicationData) map[string]PermissionInfo {
+	permissions := make(map[string]PermissionInfo, len(app.Permissions))
+	for method, perm := range app.Permissions {
+		permissions[method] = PermissionInfo{Permission: perm, Persisted: x.isPersisted(method, perm)}
+	}
 
-				x.logger.V(2).Info("received response", "response", string(json))
+	return permissions
+}
 
-				return ResponseWithResult(request, response)
-			} else {
-				x.logger.V(1).Info("Daemon is offline", "endpoint", x.wallet.Daemon_Endpoint)
-				return ResponseWithError(request, jrpc2.Errorf(code.Cancelled, "daemon %s is offline", x.wallet.Daemon_Endpoint))
-			}
+// Store the permission selected by the user if it must be persisted
+func (x *XSWD) storePermission(app *ApplicationData, method string, perm Permission) {
+	// SessionAllow is only kept in memory and discarded with the session
+	if perm == AlwaysDeny || ((perm == AlwaysAllow || perm == SessionAllow) && x.CanStorePermission(method)) {
+		// permissions are read by the applications snapshots under the applications lock
+		x.Lock()
+		app.Permissions[method] = perm
+		if perm == AlwaysAllow && app.grantedAt != nil {
+			app.grantedAt[method] = time.Now()
 		}
+		x.Unlock()
+		x.notifyPermissionChange(app.Id, method, perm)
+	}
+}
 
-		x.logger.Info("RPC Method not found", "method", methodName)
-		return ResponseWithError(request, jrpc2.Errorf(code.MethodNotFound, "method %q not found", methodName))
+// Remove the AlwaysAllow permission of method if it is older than the max age of the method,
+// returns true if it was removed and the permission must be requested again
+func (x *XSWD) expirePermission(app *ApplicationData, method string) bool {
+	maxAge, ok := x.permissionMaxAge[method]
+	if !ok || app.Permissions[method] != AlwaysAllow {
+		return false
 	}
 
-	// only one request at a time
-	x.handlerMutex.Lock()
-	defer x.handlerMutex.Unlock()
+	granted, ok := app.grantedAt[method]
+	if !ok {
+		granted = app.ConnectedAt
+	}
 
-	// check that we still have the application connected
-	// otherwise don't accept as it may disconnected between both requests
-	if !x.HasApplicationId(app.Id) {
-		return nil
+	if time.Since(granted) < maxAge {
+		return false
 	}
 
-	app.SetIsRequesting(true)
-	perm := x.requestPermission(app, request)
-	app.SetIsRequesting(false)
-	if perm.IsPositive() {
-		wallet_context := *x.context
-		wallet_context.Extra["app_data"] = app
-		ctx := context.WithValue(context.Background(), "wallet_context", &wallet_context)
-		response, err := handler(ctx, request)
-		if err != nil {
-			return ResponseWithError(request, jrpc2.Errorf(code.InternalError, "Error while handling request method %q: %v", methodName, err))
-		}
+	x.Lock()
+	delete(app.Permissions, method)
+	delete(app.grantedAt, method)
+	x.Unlock()
+	x.logger.Info("AlwaysAllow permission expired", "id", app.Id, "method", method, "max_age", maxAge)
+	x.notifyPermissionChange(app.Id, method, Ask)
 
-		return ResponseWithResult(request, response)
-	} else {
-		code := PermissionDenied
-		if perm == AlwaysDeny {
-			code = PermissionAlwaysDenied
+	return true
+}
+
+// Request the permissions of several methods in one user interaction, permissionsHandler is used over
+// requestHandler if set, methods with a stored permission are not requested again
+// The permission now applying to each method is returned, Ask if none is stored
+func (x *XSWD) requestPermissions(ctx context.Context, app *ApplicationData, methods []string) (map[string]Permission, error) {
+	var pending []string
+	requested := make(map[string]bool, len(methods))
+	for _, method := range methods {
+		if _, ok := x.rpcHandler[method]; !ok {
+			return nil, fmt.Errorf("method %q not found", method)
 		}
 
-		x.logger.Info(fmt.Sprintf("%s permission not granted for method", app.Name), "method", methodName)
-		return ResponseWithError(request, jrpc2.Errorf(code, "Permission not granted for method %q", methodName))
-	}
-}
+		if requested[method] || x.IsAlwaysAllowed(method) {
+			continue
+		}
+		requested[method] = true
 
-// Check if method is allowed to store AlwaysAllow permission when adding application or user selection is made
-func (x *XSWD) CanStorePermission(method string) bool {
-	for _, m := range x.noStore {
-		if m == method {
-			return false
+		x.expirePermission(app, method)
+		if perm, found := app.Permissions[method]; !found || perm == Ask {
+			pending = append(pending, method)
 		}
 	}
 
-	return true
-}
+	if len(pending) > 0 {
+		x.logger.Info(fmt.Sprintf("%s is requesting permissions", app.Name), "methods", pending)
 
-// Request the permission for a method and save its result if it must be persisted
-func (x *XSWD) requestPermission(app *ApplicationData, request *jrpc2.Request) Permission {
-	method := request.Method()
-	perm, found := app.Permissions[method]
-	if !found || perm == Ask {
-		perm = x.requestHandler(app, request)
+		var decisions map[string]Permission
+		x.promptMutex.Lock()
+		if x.permissionsHandler != nil {
+			decisions = x.permissionsHandler(app, pending)
+		} else {
+			decisions = make(map[string]Permission, len(pending))
+			for _, method := range pending {
+				request := (&jrpc2.ParsedRequest{Method: method}).ToRequest()
+				decisions[method] = x.requestHandler(app, request)
+			}
+		}
+		x.promptMutex.Unlock()
 
-		if perm == AlwaysDeny || (perm == AlwaysAllow && x.CanStorePermission(method)) {
-			app.Permissions[method] = perm
+		// session is released and permissions can't be stored anymore
+		if err := ctx.Err(); err != nil {
+			return nil, err
 		}
 
-		if perm.IsPositive() {
-			x.logger.Info("Permission granted", "method", method, "permission", perm)
+		for _, method := range pending {
+			if perm, ok := decisions[method]; ok {
+				x.storePermission(app, method, perm)
+			}
+		}
+	}
+
+	result := make(map[string]Permission, len(methods))
+	for _, method := range methods {
+		if x.IsAlwaysAllowed(method) {
+			result[method] = Allow
+		} else if perm, found := app.Permissions[method]; found {
+			result[method] = perm
 		} else {
-			x.logger.Info("Permission rejected", "method", method, "permission", perm)
+			result[method] = Ask
 		}
-	} else {
-		x.logger.V(1).Info("Permission already granted for method", "method", method, "permission", perm)
 	}
 
-	return perm
+	return result, nil
 }
 
 // block until the session is closed and read all its messages
 func (x *XSWD) readMessageFromSession(conn *Connection, app *ApplicationData) {
-	defer x.removeApplicationOfSession(conn, app)
+	// RateLimitWarning is sent once until tokens are back above the threshold
+	warned := false
+	reason := DisconnectClosed
+	defer func() {
+		x.removeApplicationOfSession(conn, app, reason)
+	}()
 
 	for {
-		// Remove application if it exceeds request rate limit
-		if app.limiter != nil && !app.limiter.Allow() {
-			x.logger.Error(fmt.Errorf("requests have exceeded rate limit"), "Rate limit exceeded", app.Name, "closing connection")
-			if err := conn.Send(ResponseWithError(nil, jrpc2.Errorf(RateLimitExceeded, "Requests have exceeded rate limit, closing connection"))); err != nil {
-				return
-			}
-
-			return
-		}
-
 		// block and read the message bytes from session
 		_, buff, err := conn.Read()
 		if err != nil {
-			x.logger.V(2).Error(err, "Error while reading message from session")
+			// normal close is expected when the application leaves, others such as
+			// a dropped connection (1006) or a protocol error are reported
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				x.logger.Error(err, "Session closed abnormally", "app", app.Name)
+				reason = DisconnectAbnormal
+			} else {
+				x.logger.V(2).Info("Session closed", "app", app.Name, "error", err.Error())
+			}
 			return
 		}
 
@@ -789,8 +3466,42 @@ func (x *XSWD) readMessageFromSession(conn *Connection, app *ApplicationData) {
 			return
 		}
 
+		x.updateLastActivity(conn)
+
 		// unmarshal the request
 		requests, err := jrpc2.ParseRequests(buff)
+
+		// Remove application if it exceeds request rate limit, invalid messages are always limited
+		var method string
+		if err == nil && len(requests) == 1 {
+			method = requests[0].Method
+		}
+
+		x.decayGraceBurst(app)
+		limiter := app.limiter
+		if x.IsRateLimitExempt(method) {
+			limiter = app.exempt
+		}
+
+		if limiter != nil && !limiter.Allow() {
+			x.logger.Error(fmt.Errorf("requests have exceeded rate limit"), "Rate limit exceeded", app.Name, "closing connection")
+			reason = DisconnectRateLimit
+			data := RateLimitExceeded_Data{Limit: float64(limiter.Limit()), Burst: limiter.Burst()}
+			if data.Limit > 0 {
+				data.Window = float64(data.Burst) / data.Limit
+			}
+
+			if err := conn.Send(ResponseWithError(nil, jrpc2.Errorf(RateLimitExceeded, "Requests have exceeded rate limit, closing connection").WithData(data))); err != nil {
+				return
+			}
+
+			return
+		}
+
+		if !x.IsRateLimitExempt(method) {
+			warned = x.warnRateLimit(conn, app, warned)
+		}
+
 		if err != nil {
 			x.logger.Error(err, "Error while parsing request")
 			if err := conn.Send(ResponseWithError(nil, jrpc2.Errorf(code.ParseError, "Error while parsing request"))); err != nil {
@@ -803,7 +3514,7 @@ func (x *XSWD) readMessageFromSession(conn *Connection, app *ApplicationData) {
 		// We only support one request at a time for permission request
 		if len(requests) != 1 {
 			x.logger.V(2).Error(nil, "Invalid number of requests")
-			if err := conn.Send(ResponseWithError(nil, jrpc2.Errorf(code.ParseError, "Batch requests are not supported"))); err != nil {
+			if err := conn.Send(ResponseWithError(nil, jrpc2.Errorf(BatchNotSupported, "Batch requests are not supported"))); err != nil {
 				return
 			}
 			continue
@@ -819,13 +3530,92 @@ func (x *XSWD) readMessageFromSession(conn *Connection, app *ApplicationData) {
 			continue
 		}
 
-		x.requests <- messageRequest{app: app, request: req, conn: conn}
+		// don't block on a stopped handler_loop
+		select {
+		case x.requests <- messageRequest{app: app, request: req, conn: conn}:
+		case <-conn.ctx.Done():
+			return
+		}
+	}
+}
+
+// Default response of the root path
+func defaultRootHandler(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("XSWD server"))
+}
+
+// Replace the handler of the root path, such as to brand it or serve a discovery document,
+// nil restores the default response
+func (x *XSWD) SetRootHandler(handler http.HandlerFunc) {
+	if handler == nil {
+		handler = defaultRootHandler
+	}
+
+	x.Lock()
+	x.rootHandler = handler
+	x.Unlock()
+}
+
+// Handle the root path and any path not served by XSWD
+func (x *XSWD) handleRoot(w http.ResponseWriter, r *http.Request) {
+	x.Lock()
+	handler := x.rootHandler
+	x.Unlock()
+
+	handler(w, r)
+}
+
+// Max size of the application data accepted by the validation endpoint
+const maxValidateSize = 64 * 1024
+
+// Handle the validation of application data posted by a developer, it runs the checks done
+// when connecting without connecting, the session challenge can't be validated so the signature must match the ID
+func (x *XSWD) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var result ValidateApplication_Result
+	var app ApplicationData
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxValidateSize)).Decode(&app); err != nil {
+		result.Step = ValidateFormat
+		result.Message = "Invalid app data format"
+	} else {
+		app.origin = x.requestOrigin(r)
+		if len(app.Url) == 0 {
+			app.Url = app.origin
+		}
+
+		result.Step, result.Message = x.checkApplication(&app, false)
+		result.Valid = result.Message == ""
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		x.logger.V(2).Error(err, "Error while writing validation result")
+	}
+}
+
+// Handle a health check, it only reads cached states so it can be polled
+func (x *XSWD) handleHealth(w http.ResponseWriter, r *http.Request) {
+	health := HealthStatus{
+		Running:      x.IsRunning(),
+		Applications: x.ApplicationCount(),
+		DaemonOnline: x.daemon.IsOnline(),
+		Uptime:       int64(time.Since(x.startedAt).Seconds()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(health); err != nil {
+		x.logger.V(2).Error(err, "Error while writing health")
 	}
 }
 
 // Handle a WebSocket connection
 func (x *XSWD) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	globals.Logger.V(2).Info("New WebSocket connection", "addr", r.RemoteAddr)
+	x.logger.V(2).Info("New WebSocket connection", "addr", x.remoteAddr(r))
 	// Accept from any origin
 	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
 	conn, err := upgrader.Upgrade(w, r, nil)
@@ -835,34 +3625,85 @@ func (x *XSWD) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	// first message of the session should be its ApplicationData
+	// if enabled, first message sent to the session is the challenge to be signed with the app ID
+	var challenge string
+	if x.challenge {
+		nonce := make([]byte, 32)
+		if _, err := rand.Read(nonce); err != nil {
+			x.logger.Error(err, "Error while generating challenge")
+			return
+		}
+
+		challenge = hex.EncodeToString(nonce)
+		if err := conn.WriteJSON(AuthorizationChallenge{Challenge: challenge}); err != nil {
+			x.logger.V(2).Error(err, "Error while sending challenge")
+			return
+		}
+	}
+
+	// first message of the session should be its ApplicationData, sent before handshakeTimeout
+	if x.handshakeTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(x.handshakeTimeout))
+	}
+
 	var app_data ApplicationData
 	if err := conn.ReadJSON(&app_data); err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			x.logger.V(1).Info("Timed out waiting for application data", "addr", x.remoteAddr(r))
+			conn.WriteJSON(x.authorizationResponse(AuthorizationTimeout, "", "Timed out waiting for application data", false))
+
+			return
+		}
+
 		x.logger.V(2).Error(err, "Error while reading app_data")
-		conn.WriteJSON(AuthorizationResponse{
-			Message:  "Invalid app data format",
-			Accepted: false,
-		})
+		conn.WriteJSON(x.authorizationResponse(AuthorizationInvalid, ValidateFormat, "Invalid app data format", false))
 
 		return
 	}
 
+	// handshake succeeded, requests are read without deadline
+	conn.SetReadDeadline(time.Time{})
+
 	if x.HasApplicationId(app_data.Id) {
 		x.logger.Info("App ID is already used", "ID", app_data.Name)
-		conn.WriteJSON(AuthorizationResponse{
-			Message:  "App ID is already used",
-			Accepted: false,
-		})
+		conn.WriteJSON(x.authorizationResponse(AuthorizationIDUsed, "", "App ID is already used", false))
+
+		return
+	}
 
+	app_data.challenge = challenge
+	connection := newConnection(x.ctx, conn)
+	select {
+	case x.registers <- messageRegistration{conn: connection, request: r, app: &app_data}:
+	case <-connection.ctx.Done():
+		// server stopped, the connection writer exits with its context
 		return
 	}
 
-	connection := new(Connection)
-	connection.conn = conn
-	x.registers <- messageRegistration{conn: connection, request: r, app: &app_data}
 	x.readMessageFromSession(connection, &app_data)
 }
 
+// Check a name or description, ASCII only and up to 255 bytes unless UTF-8 is allowed,
+// UTF-8 text is then limited to 255 characters without control characters
+func (x *XSWD) isValidText(s string) bool {
+	if !x.allowUTF8 {
+		return len(s) <= 255 && isASCII(s)
+	}
+
+	if !utf8.ValidString(s) || utf8.RuneCountInString(s) > 255 {
+		return false
+	}
+
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func isASCII(s string) bool {
 	for i := 0; i < len(s); i++ {
 		if s[i] > unicode.MaxASCII {
@@ -5,6 +5,9 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"strings"
@@ -14,11 +17,17 @@ import (
 
 	"github.com/creachadair/jrpc2"
 	"github.com/creachadair/jrpc2/code"
+	"github.com/creachadair/jrpc2/handler"
+	jrpc2server "github.com/creachadair/jrpc2/server"
+	"github.com/deroproject/derohe/config"
+	"github.com/deroproject/derohe/cryptography/crypto"
 	"github.com/deroproject/derohe/rpc"
 	"github.com/deroproject/derohe/walletapi"
+	"github.com/go-logr/logr"
 	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 	"github.com/ybbus/jsonrpc"
+	"golang.org/x/time/rate"
 )
 
 // Test ApplicationData
@@ -863,6 +872,20 @@ func TestXSWDServer(t *testing.T) {
 				assert.NotNil(t, response12b, "Response 12b on application %d should not be nil", i)
 				assert.Nil(t, serverErr, "Response 12b on application %d should not have error: %v", i, serverErr)
 				assert.False(t, server.IsEventTracked(params12.Event), "Event on application %d should not be tracked after %q", i, request12b.Method)
+
+				// Subscribe to unsupported event
+				request12c := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  "Subscribe",
+					Params:  Subscribe_Params{Event: "unknown_event"},
+				}
+				response12c, serverErr, err := testXSWDCall(t, conn, request12c)
+				assert.NoErrorf(t, err, "Request 12c on application %d should not error: %s", i, err)
+				assert.NotNil(t, response12c, "Response 12c on application %d should not be nil", i)
+				assert.Error(t, serverErr, "Response 12c on application %d should have error: %v", i, serverErr)
+				assert.Equal(t, code.InternalError, serverErr.Code, "Response 12c on application %d should be %v: %v", i, code.InternalError, serverErr.Code)
+				assert.False(t, server.IsEventTracked("unknown_event"), "Unsupported event on application %d should not be tracked", i)
 			})
 
 			// // Request 13 request
@@ -888,6 +911,7 @@ func TestXSWDServer(t *testing.T) {
 				signer, message, err := server.wallet.CheckSignature(decodeString)
 				assert.NoErrorf(t, err, "Reading signature on application %d should not error: %s", i, err)
 				assert.Equal(t, testWalletData[0].Address, signer.String(), "Signers walletapi %d does not match %s: %s", i, testWalletData[0].Address, signer.String())
+				assert.Equal(t, signer.String(), response13a.Result.(map[string]interface{})["signer"], "Response 13a signer on application %d should be the signature signer", i)
 				assert.Equal(t, somedata, message, "Signed walletapi messages %d do not match %s: %s", i, somedata, message)
 
 				// AlwaysAllow CheckSignature request to test CanStorePermission as it is a noStore method here
@@ -917,6 +941,7 @@ func TestXSWDServer(t *testing.T) {
 				assert.NoErrorf(t, err, "Request 13b unmarshal on application %d should not error: %s", i, err)
 				assert.Equal(t, testWalletData[0].Address, result13b.Signer, "Signers %q %d does not match %s: %s", request13b.Method, i, testWalletData[0].Address, signer.String())
 				assert.Equal(t, string(message), result13b.Message, "Signed %q messages %d do not match %s: %s", request13b.Method, i, somedata, result13b.Message)
+				assert.True(t, result13b.IsDERONetwork, "Signer %q %d should be DERO network", request13b.Method, i)
 
 				// Test CheckSignature with invalid signature
 				request13b.Params = []byte("not a valid signature")
@@ -947,7 +972,8 @@ func TestXSWDServer(t *testing.T) {
 			t.Run("Request14", func(t *testing.T) {
 				// Allow this request
 				server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission { return Allow }
-				// Call XSWD GetDaemon expecting to fail as daemon is not connected
+				// Call XSWD GetDaemon expecting to succeed without network and height as daemon is not connected
+				var result14a GetDaemon_Result
 				request14 := jsonrpc.RPCRequest{
 					JSONRPC: "2.0",
 					ID:      1,
@@ -956,8 +982,13 @@ func TestXSWDServer(t *testing.T) {
 				response14a, serverErr, err := testXSWDCall(t, conn, request14)
 				assert.NoErrorf(t, err, "Request 14a %q on application %d should not error: %s", request14.Method, i, err)
 				assert.NotNil(t, response14a, "Response 14a on application %d should not be nil", i)
-				assert.Error(t, serverErr, "Response 14a on application %d should have error: %v", i, serverErr)
-				assert.Equal(t, code.InternalError, serverErr.Code, "Response 14a on application %d should be %v: %v", i, code.InternalError, serverErr.Code)
+				assert.Nil(t, serverErr, "Response 14a on application %d should not have error: %v", i, serverErr)
+				js, err := json.Marshal(response14a.Result)
+				assert.NoErrorf(t, err, "Request 14a marshal on application %d should not error: %s", i, err)
+				err = json.Unmarshal(js, &result14a)
+				assert.NoErrorf(t, err, "Request 14a unmarshal on application %d should not error: %s", i, err)
+				assert.Empty(t, result14a.Network, "Response 14a on application %d should not have network", i)
+				assert.Zero(t, result14a.Height, "Response 14a on application %d should not have height", i)
 
 				// Call again with Deny should fail
 				server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission { return Deny }
@@ -968,6 +999,139 @@ func TestXSWDServer(t *testing.T) {
 				assert.Equal(t, PermissionDenied, serverErr.Code, "Response 14b on application %d should be %v: %v", i, PermissionDenied, serverErr.Code)
 			})
 
+			// // Request 15
+			t.Run("Request15", func(t *testing.T) {
+				// Allow this request
+				server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission { return Allow }
+				// Call XSWD GetDaemonStatus expecting to succeed while daemon is not connected
+				var result15 GetDaemonStatus_Result
+				request15 := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  "GetDaemonStatus",
+				}
+				response15, serverErr, err := testXSWDCall(t, conn, request15)
+				assert.NoErrorf(t, err, "Request 15 %q on application %d should not error: %s", request15.Method, i, err)
+				assert.NotNil(t, response15, "Response 15 on application %d should not be nil", i)
+				assert.Nil(t, serverErr, "Response 15 on application %d should not have error: %v", i, serverErr)
+				js, err := json.Marshal(response15.Result)
+				assert.NoErrorf(t, err, "Request 15 marshal on application %d should not error: %s", i, err)
+				err = json.Unmarshal(js, &result15)
+				assert.NoErrorf(t, err, "Request 15 unmarshal on application %d should not error: %s", i, err)
+				assert.False(t, result15.Online, "Response 15 on application %d daemon should be offline", i)
+				assert.Equal(t, server.DaemonEndpoint(), result15.Endpoint, "Response 15 on application %d endpoint does not match", i)
+				assert.False(t, server.CanStorePermission(request15.Method), "%s should be a noStore method", request15.Method)
+			})
+
+			// // Request 16
+			t.Run("Request16", func(t *testing.T) {
+				// Call XSWD PreviewTransfer expecting to fail as wallet is offline, nothing is broadcasted
+				server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission { return Allow }
+				request16 := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  "PreviewTransfer",
+					Params: rpc.Transfer_Params{
+						Transfers: []rpc.Transfer{{Destination: "deto1qyvyeyzrcm2fzf6kyq7egkes2ufgny5xn77y6typhfx9s7w3mvyd5qqynr5hx", Amount: 1}},
+					},
+				}
+				response16a, serverErr, err := testXSWDCall(t, conn, request16)
+				assert.NoErrorf(t, err, "Request 16a %q on application %d should not error: %s", request16.Method, i, err)
+				assert.NotNil(t, response16a, "Response 16a on application %d should not be nil", i)
+				assert.Error(t, serverErr, "Response 16a on application %d should have error: %v", i, serverErr)
+				assert.Equal(t, code.InternalError, serverErr.Code, "Response 16a on application %d should be %v: %v", i, code.InternalError, serverErr.Code)
+
+				// PreviewTransfer is permission gated like transfer
+				server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission { return Deny }
+				response16b, serverErr, err := testXSWDCall(t, conn, request16)
+				assert.NoErrorf(t, err, "Request 16b %q on application %d should not error: %s", request16.Method, i, err)
+				assert.NotNil(t, response16b, "Response 16b on application %d should not be nil", i)
+				assert.Error(t, serverErr, "Response 16b on application %d should have error: %v", i, serverErr)
+				assert.Equal(t, PermissionDenied, serverErr.Code, "Response 16b on application %d should be %v: %v", i, PermissionDenied, serverErr.Code)
+				assert.True(t, server.CanStorePermission(request16.Method), "%s should not be a noStore method", request16.Method)
+			})
+
+			t.Run("Request17", func(t *testing.T) {
+				// Call XSWD BuildSignedTransfer expecting to fail as wallet is offline, nothing is built
+				server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission { return Allow }
+				request17 := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  "BuildSignedTransfer",
+					Params: rpc.Transfer_Params{
+						Transfers: []rpc.Transfer{{Destination: "deto1qyvyeyzrcm2fzf6kyq7egkes2ufgny5xn77y6typhfx9s7w3mvyd5qqynr5hx", Amount: 1}},
+					},
+				}
+				response17a, serverErr, err := testXSWDCall(t, conn, request17)
+				assert.NoErrorf(t, err, "Request 17a %q on application %d should not error: %s", request17.Method, i, err)
+				assert.NotNil(t, response17a, "Response 17a on application %d should not be nil", i)
+				assert.Error(t, serverErr, "Response 17a on application %d should have error: %v", i, serverErr)
+				assert.Equal(t, code.InternalError, serverErr.Code, "Response 17a on application %d should be %v: %v", i, code.InternalError, serverErr.Code)
+
+				// BuildSignedTransfer is permission gated like transfer
+				server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission { return Deny }
+				response17b, serverErr, err := testXSWDCall(t, conn, request17)
+				assert.NoErrorf(t, err, "Request 17b %q on application %d should not error: %s", request17.Method, i, err)
+				assert.NotNil(t, response17b, "Response 17b on application %d should not be nil", i)
+				assert.Error(t, serverErr, "Response 17b on application %d should have error: %v", i, serverErr)
+				assert.Equal(t, PermissionDenied, serverErr.Code, "Response 17b on application %d should be %v: %v", i, PermissionDenied, serverErr.Code)
+
+				// Invalid destination is rejected before permission
+				request17.Params = rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: "deto1invalid", Amount: 1}}}
+				response17c, serverErr, err := testXSWDCall(t, conn, request17)
+				assert.NoErrorf(t, err, "Request 17c %q on application %d should not error: %s", request17.Method, i, err)
+				assert.NotNil(t, response17c, "Response 17c on application %d should not be nil", i)
+				assert.Error(t, serverErr, "Response 17c on application %d should have error: %v", i, serverErr)
+				assert.Equal(t, code.InvalidParams, serverErr.Code, "Response 17c on application %d should be %v: %v", i, code.InvalidParams, serverErr.Code)
+
+				// Name destination is resolved by the wallet so it reaches the permission
+				request17.Params = rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: "registered_name", Amount: 1}}}
+				response17d, serverErr, err := testXSWDCall(t, conn, request17)
+				assert.NoErrorf(t, err, "Request 17d %q on application %d should not error: %s", request17.Method, i, err)
+				assert.NotNil(t, response17d, "Response 17d on application %d should not be nil", i)
+				assert.Error(t, serverErr, "Response 17d on application %d should have error: %v", i, serverErr)
+				assert.Equal(t, PermissionDenied, serverErr.Code, "Response 17d on application %d should be %v: %v", i, PermissionDenied, serverErr.Code)
+			})
+
+			// Break the requests up to stay within rate limit
+			time.Sleep(sleep500)
+
+			t.Run("Request18", func(t *testing.T) {
+				// Call XSWD PreviewSCInvoke expecting to fail as wallet is offline, nothing is broadcasted
+				server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission { return Allow }
+				request18 := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  "PreviewSCInvoke",
+					Params: rpc.SC_Invoke_Params{
+						SC_ID:  "0000000000000000000000000000000000000000000000000000000000000001",
+						SC_RPC: rpc.Arguments{{Name: "entrypoint", DataType: rpc.DataString, Value: "Initialize"}},
+					},
+				}
+				response18a, serverErr, err := testXSWDCall(t, conn, request18)
+				assert.NoErrorf(t, err, "Request 18a %q on application %d should not error: %s", request18.Method, i, err)
+				assert.NotNil(t, response18a, "Response 18a on application %d should not be nil", i)
+				assert.Error(t, serverErr, "Response 18a on application %d should have error: %v", i, serverErr)
+				assert.Equal(t, code.InternalError, serverErr.Code, "Response 18a on application %d should be %v: %v", i, code.InternalError, serverErr.Code)
+
+				// PreviewSCInvoke is permission gated like scinvoke
+				server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission { return Deny }
+				response18b, serverErr, err := testXSWDCall(t, conn, request18)
+				assert.NoErrorf(t, err, "Request 18b %q on application %d should not error: %s", request18.Method, i, err)
+				assert.NotNil(t, response18b, "Response 18b on application %d should not be nil", i)
+				assert.Error(t, serverErr, "Response 18b on application %d should have error: %v", i, serverErr)
+				assert.Equal(t, PermissionDenied, serverErr.Code, "Response 18b on application %d should be %v: %v", i, PermissionDenied, serverErr.Code)
+				assert.Equal(t, server.CanStorePermission("scinvoke"), server.CanStorePermission(request18.Method), "%s should be stored like scinvoke", request18.Method)
+
+				// Invalid SCID is rejected before permission
+				request18.Params = rpc.SC_Invoke_Params{SC_ID: "invalid"}
+				response18c, serverErr, err := testXSWDCall(t, conn, request18)
+				assert.NoErrorf(t, err, "Request 18c %q on application %d should not error: %s", request18.Method, i, err)
+				assert.NotNil(t, response18c, "Response 18c on application %d should not be nil", i)
+				assert.Error(t, serverErr, "Response 18c on application %d should have error: %v", i, serverErr)
+				assert.Equal(t, code.InvalidParams, serverErr.Code, "Response 18c on application %d should be %v: %v", i, code.InvalidParams, serverErr.Code)
+			})
+
 			// Close the app connection
 			conn.Close()
 			time.Sleep(sleep10)
@@ -1336,6 +1500,20 @@ func TestXSWDServer(t *testing.T) {
 
 		wg.Wait()
 	})
+
+	// Tests of the server features, each one starting without applications
+	for _, test := range testServerFeatures {
+		t.Run(test.name, func(t *testing.T) {
+			server.RemoveAllApplications("test started")
+			// Simulate user accepting the application connection request
+			server.appHandler = func(ad *ApplicationData) bool { return true }
+			// Simulate the permission of the test
+			permission := test.permission
+			server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission { return permission }
+
+			test.run(t, xswdWallet, server)
+		})
+	}
 }
 
 // TestXSWDServerWithPort tests request with stored permissions and daemon calls
@@ -1489,6 +1667,9 @@ func TestXSWDServerWithPort(t *testing.T) {
 				JSONRPC: "2.0",
 				ID:      1,
 				Method:  "transfer",
+				Params: rpc.Transfer_Params{
+					Transfers: []rpc.Transfer{{Destination: testWalletData[0].Address, Amount: 1}},
+				},
 			}
 			response3, serverErr, err := testXSWDCall(t, conn, request3)
 			assert.NoErrorf(t, err, "Request 3 %q should not give error: %s", request3.Method, err)
@@ -1519,6 +1700,9 @@ func TestXSWDServerWithPort(t *testing.T) {
 				JSONRPC: "2.0",
 				ID:      1,
 				Method:  "scinvoke",
+				Params: rpc.SC_Invoke_Params{
+					SC_ID: "0000000000000000000000000000000000000000000000000000000000000001",
+				},
 			}
 			response5, serverErr, err := testXSWDCall(t, conn, request5)
 			assert.NoErrorf(t, err, "Request 5 %q should not give error: %s", request5.Method, err)
@@ -1561,7 +1745,7 @@ func TestXSWDServerWithPort(t *testing.T) {
 			assert.NoErrorf(t, err, "Request 7 batch should not give error: %s", err)
 			assert.NotNil(t, response7, "Response 7 should not be nil")
 			assert.Error(t, serverErr, "Response 7 should have error: %v", serverErr)
-			assert.Equal(t, code.ParseError, serverErr.Code, "Response 7 should be %v: %v", code.ParseError, serverErr.Code)
+			assert.Equal(t, BatchNotSupported, serverErr.Code, "Response 7 should be %v: %v", BatchNotSupported, serverErr.Code)
 		})
 
 		// Close the app connection
@@ -1587,22 +1771,8 @@ func TestXSWDServerWithPort(t *testing.T) {
 
 	// Test daemon calls to XSWD server
 	t.Run("Daemon", func(t *testing.T) {
-		var endpoint string
-		var endpoints = []string{"127.0.0.1:10102", "89.38.99.117:10102", "node.derofoundation.org:11012"}
-		for i, ep := range endpoints {
-			err := walletapi.Connect(ep)
-			if err != nil {
-				if i == len(endpoints)-1 {
-					t.Skipf("Could not connect to fallback endpoint, skipping daemon tests")
-				}
-
-				continue
-			}
-
-			endpoint = ep
-			break
-		}
-		t.Logf("Wallet is connected to daemon endpoint: %s", endpoint)
+		// a fake daemon answers with canned results so the test doesn't depend on the network
+		server.daemon = testNewDaemon(t)
 
 		conn, err := testCreateClient(nil)
 		assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
@@ -1645,7 +1815,7 @@ func TestXSWDServerWithPort(t *testing.T) {
 			assert.NoErrorf(t, err, "Response 2 %s marshal should not error: %s", request2.Method, err)
 			err = json.Unmarshal(js, &result2)
 			assert.NoErrorf(t, err, "Response 2 %s unmarshal should not error: %s", request2.Method, err)
-			assert.False(t, result2.Testnet, "Response 2 testnet should be false on %s", endpoint)
+			assert.False(t, result2.Testnet, "Response 2 testnet should be false")
 			assert.Greater(t, result2.Height, int64(0), "Response 2 height should be greater than 0")
 			assert.Greater(t, result2.Total_Supply, uint64(0), "Response 2 DERO supply should be greater than 0")
 			t.Logf("Version: %s", result2.Version)
@@ -1697,7 +1867,21 @@ func TestXSWDServerWithPort(t *testing.T) {
 			}
 			_, serverErr, err := testXSWDCall(t, conn, request5)
 			assert.NoErrorf(t, err, "Request 5 %s should not error: %s", request5.Method, err)
-			assert.Equal(t, code.InvalidRequest, serverErr.Code, "Response 5 should be %v: %v", code.InvalidRequest, serverErr.Code)
+			// Daemon rejection keeps the daemon code
+			assert.Equal(t, code.MethodNotFound, serverErr.Code, "Response 5 should be %v: %v", code.MethodNotFound, serverErr.Code)
+			var data DaemonError_Data
+			err = json.Unmarshal(serverErr.Data, &data)
+			assert.NoErrorf(t, err, "Response 5 data should unmarshal: %s", err)
+			assert.Equal(t, DaemonErrorDaemon, data.Source, "Response 5 source should be %q: %q", DaemonErrorDaemon, data.Source)
+
+			// Invalid params are forwarded and reported by the daemon
+			request5.Method, request5.Params = "DERO.GetBlock", map[string]interface{}{"height": "DERO"}
+			_, serverErr, err = testXSWDCall(t, conn, request5)
+			assert.NoErrorf(t, err, "Request 5 %s should not error: %s", request5.Method, err)
+			assert.Equal(t, code.InvalidParams, serverErr.Code, "Response 5 should be %v: %v", code.InvalidParams, serverErr.Code)
+			err = json.Unmarshal(serverErr.Data, &data)
+			assert.NoErrorf(t, err, "Response 5 data should unmarshal: %s", err)
+			assert.Equal(t, DaemonErrorDaemon, data.Source, "Response 5 source should be %q: %q", DaemonErrorDaemon, data.Source)
 		})
 
 		// // Request 6
@@ -1715,7 +1899,9 @@ func TestXSWDServerWithPort(t *testing.T) {
 			assert.NotNil(t, response6, "Response 6 should not be nil")
 			assert.Nil(t, serverErr, "Response 6 should not have error: %v", serverErr)
 			assert.IsType(t, map[string]interface{}{}, response6.Result, "Response 6 should be map[string]interface{}: %T", response6.Result)
-			assert.Equal(t, endpoint, response6.Result.(map[string]interface{})["endpoint"].(string))
+			assert.Equal(t, server.DaemonEndpoint(), response6.Result.(map[string]interface{})["endpoint"].(string))
+			assert.NotEmpty(t, response6.Result.(map[string]interface{})["network"], "Response 6 should have daemon network")
+			assert.NotZero(t, response6.Result.(map[string]interface{})["height"], "Response 6 should have daemon height")
 		})
 
 		// // Request 7
@@ -1730,8 +1916,8 @@ func TestXSWDServerWithPort(t *testing.T) {
 			}
 			_, serverErr, err := testXSWDCall(t, conn, request7)
 			assert.NoErrorf(t, err, "Request 7 %s should not error: %s", request7.Method, err)
-			// This errors on RPC.Call(), not request.UnmarshalParams()
-			assert.Equal(t, code.InvalidRequest, serverErr.Code, "Response 7 should be %v: %v", code.InvalidRequest, serverErr.Code)
+			// Params are sent as a base64 string, which is not a valid request so it never reaches the daemon
+			assert.Equal(t, code.ParseError, serverErr.Code, "Response 7 should be %v: %v", code.ParseError, serverErr.Code)
 		})
 	})
 }
@@ -2044,10 +2230,11 @@ func TestXSWDRateLimit(t *testing.T) {
 			break
 		}
 
-		// Keep sending requests without waiting for response
+		// Keep sending requests without waiting for response,
+		// the ones over the requests in flight limit of the application are cancelled
 		go func() {
 			_, serverErr, _ := testXSWDCall(t, conn, request1)
-			if serverErr != nil && assert.Equal(t, RateLimitExceeded, serverErr.Code, "Expected error to be %v: %v", RateLimitExceeded, serverErr.Code) {
+			if serverErr != nil && serverErr.Code != code.Cancelled && assert.Equal(t, RateLimitExceeded, serverErr.Code, "Expected error to be %v: %v", RateLimitExceeded, serverErr.Code) {
 				disconnected = true
 				t.Logf("App 6 exceeded rate limit at %d requests %v elapsed: %v", i, time.Since(start), serverErr.Code)
 			}
@@ -2061,115 +2248,5088 @@ func TestXSWDRateLimit(t *testing.T) {
 	assert.Len(t, server.applications, 0, "There should be no applications left")
 }
 
-// Create a testnet wallet and start XSWD server for tests
-// If port, server will use NewXSWDServerWithPort w/ !forceAsk, otherwise will use NewXSWDServer
-// Simulate initial appHandler and requestHandler values
-func testNewXSWDServer(t *testing.T, port, aHandler bool, rHandler Permission) (xswdWallet *walletapi.Wallet_Disk, server *XSWD, err error) {
-	xswdWallet, err = walletapi.Create_Encrypted_Wallet_From_Recovery_Words("xswd_text_wallet.db", "xswd", testWalletData[0].seed)
-	if err != nil {
-		return
-	}
+// Tests of the server features run on the TestXSWDServer server,
+// applications connected by a test are removed once it is done
+var testServerFeatures = []struct {
+	name       string
+	permission Permission
+	run        func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD)
+}{
+	// Test events derived from wallet listeners
+	{
+		name:       "SyncProgress",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
 
-	// Simulate user accepting or denying the application connection request
-	appHandler := func(app *ApplicationData) bool { return aHandler }
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
 
-	// Simulate user permission when requestHandler is called
-	requestHandler := func(app *ApplicationData, request *jrpc2.Request) Permission { return rHandler }
+			// Not computed until subscribed
+			assert.False(t, server.IsEventTracked(rpc.SyncProgress), "Event should not be tracked")
 
-	if port {
-		// Test noStore methods outside NewXSWDServer() defaults
-		testNoStores := []string{"MakeIntegratedAddress"}
-		// NewXSWDServerWithPort will use !forceAsk to allow permission requests
-		server = NewXSWDServerWithPort(XSWD_PORT, xswdWallet, false, testNoStores, appHandler, requestHandler)
-		t.Logf("Starting NewXSWDServerWithPort: [port: %d, appHandler: %t, requestHandler: %s]", XSWD_PORT, aHandler, rHandler.String())
+			subscribe := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Subscribe",
+				Params:  Subscribe_Params{Event: rpc.SyncProgress},
+			}
+			_, serverErr, err := testXSWDCall(t, conn, subscribe)
+			assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+			assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+			assert.True(t, server.IsEventTracked(rpc.SyncProgress), "Event should be tracked")
+
+			// SyncProgress is broadcast on NewTopoheight
+			testListener(xswdWallet, rpc.NewTopoheight, int64(600))
+
+			_, message, err := conn.ReadMessage()
+			assert.NoErrorf(t, err, "Read should not error: %s", err)
+
+			var event RPCResponse
+			err = json.Unmarshal(message, &event)
+			assert.NoErrorf(t, err, "Unmarshal event should not error: %s", err)
+			js, err := json.Marshal(event.Result)
+			assert.NoErrorf(t, err, "Marshal event should not error: %s", err)
+
+			var notification struct {
+				Event rpc.EventType          `json:"event"`
+				Value rpc.SyncProgressChange `json:"value"`
+			}
+			err = json.Unmarshal(js, &notification)
+			assert.NoErrorf(t, err, "Unmarshal notification should not error: %s", err)
+			assert.Equal(t, rpc.EventType(rpc.SyncProgress), notification.Event, "Event should be %s: %s", rpc.SyncProgress, notification.Event)
+			// Wallet is not connected to a daemon
+			assert.Equal(t, xswdWallet.Get_Height(), notification.Value.WalletHeight, "Wallet height does not match")
+			assert.Equal(t, xswdWallet.Get_Daemon_Height(), notification.Value.DaemonHeight, "Daemon height does not match")
+			assert.False(t, notification.Value.Synced, "Wallet should not be synced without daemon")
+		},
+	},
+	// Test WalletHeight event is only broadcasted when wallet height changes
+	{
+		name:       "WalletHeight",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
 
-	} else {
-		// NewXSWDServer defaults all permissions to Ask, noStore methods are all xswd methods
-		server = NewXSWDServer(xswdWallet, appHandler, requestHandler)
-		t.Logf("Starting NewXSWDServer: [appHandler: %t, requestHandler: %s]", aHandler, rHandler.String())
-	}
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
 
-	// Wait for the server to start
-	time.Sleep(time.Second)
+			subscribe := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Subscribe",
+				Params:  Subscribe_Params{Event: rpc.WalletHeight},
+			}
+			_, serverErr, err := testXSWDCall(t, conn, subscribe)
+			assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+			assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+
+			// Wallet height is not changing between both topoheights
+			testListener(xswdWallet, rpc.NewTopoheight, int64(600))
+			testListener(xswdWallet, rpc.NewTopoheight, int64(601))
+
+			_, message, err := conn.ReadMessage()
+			assert.NoErrorf(t, err, "Read should not error: %s", err)
+
+			var event RPCResponse
+			err = json.Unmarshal(message, &event)
+			assert.NoErrorf(t, err, "Unmarshal event should not error: %s", err)
+			js, err := json.Marshal(event.Result)
+			assert.NoErrorf(t, err, "Marshal event should not error: %s", err)
+
+			var notification struct {
+				Event rpc.EventType `json:"event"`
+				Value uint64        `json:"value"`
+			}
+			err = json.Unmarshal(js, &notification)
+			assert.NoErrorf(t, err, "Unmarshal notification should not error: %s", err)
+			assert.Equal(t, rpc.EventType(rpc.WalletHeight), notification.Event, "Event should be %s: %s", rpc.WalletHeight, notification.Event)
+			assert.Equal(t, xswdWallet.Get_Height(), notification.Value, "Wallet height does not match")
+
+			// Second topoheight should not be broadcasted
+			conn.SetReadDeadline(time.Now().Add(sleep500))
+			_, _, err = conn.ReadMessage()
+			assert.Error(t, err, "Unchanged wallet height should not be broadcasted")
+		},
+	},
+	// Test removing all applications without stopping the server
+	{
+		name:       "RemoveAllApplications",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			var conns []*websocket.Conn
+			for i := 0; i < 3; i++ {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application %d failed to dial server: %s", i, err)
+				defer conn.Close()
+
+				err = conn.WriteJSON(testAppData[i])
+				assert.NoErrorf(t, err, "Application %d failed to write data to server: %s", i, err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.True(t, authResponse.Accepted, "Application %d should be accepted and is not", i)
+				conns = append(conns, conn)
+			}
 
-	if !server.IsRunning() {
-		return nil, nil, fmt.Errorf("server is not running and should be")
-	}
+			assert.Len(t, server.GetApplications(), 3, "There should be three applications")
 
-	return
-}
+			server.RemoveAllApplications("wallet locked")
+			assert.Len(t, server.GetApplications(), 0, "There should be no applications")
+			assert.True(t, server.IsRunning(), "XSWD server should still be running")
 
-// Create client for XSWD server tests
-func testCreateClient(headers http.Header) (conn *websocket.Conn, err error) {
-	u := url.URL{Scheme: "ws", Host: "127.0.0.1:44326", Path: "/xswd"}
-	conn, _, err = websocket.DefaultDialer.Dial(u.String(), headers)
+			// All sessions should be closed
+			for i, conn := range conns {
+				_, _, err := conn.ReadMessage()
+				assert.Error(t, err, "Application %d should not be connected", i)
+			}
 
-	return
-}
+			// Server is still accepting applications
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
 
-// Handle XSWD authentication response for tests
-func testHandleAuthResponse(t *testing.T, conn *websocket.Conn) (response AuthorizationResponse) {
-	_, message, err := conn.ReadMessage()
-	if err != nil {
-		t.Fatalf("Failed to receive authorization response: %s", err)
-	}
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+			assert.Len(t, server.GetApplications(), 1, "There should be one application")
+		},
+	},
+	// Test connection outgoing queue
+	{
+		name:       "Connection",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
 
-	err = json.Unmarshal(message, &response)
-	if err != nil {
-		t.Fatalf("Failed to unmarshal authorization response: %s", err)
-	}
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
 
-	return
-}
+			var connection *Connection
+			server.Lock()
+			for c := range server.applications {
+				connection = c
+			}
+			server.Unlock()
+			assert.NotNil(t, connection, "Connection should be present")
 
-// Call and read test requests to XSWD server
-func testXSWDCall(t *testing.T, conn *websocket.Conn, request interface{}) (response RPCResponse, jrpcErr *jrpc2.Error, err error) {
-	method := "unknown"
-	switch r := request.(type) {
-	case jsonrpc.RPCRequest:
-		method = r.Method
-	}
+			// Queued messages are written before closing
+			for i := 0; i < 10; i++ {
+				assert.NoError(t, connection.Send(ResponseWithResult(nil, i)), "Send %d should not error", i)
+			}
 
-	err = conn.WriteJSON(request)
-	if err != nil {
-		err = fmt.Errorf("failed to write %s request: %s", method, err)
-		return
-	}
+			go connection.Close()
 
-	_, message, err := conn.ReadMessage()
-	if err != nil {
-		err = fmt.Errorf("failed to receive %s response: %s", method, err)
-		return
-	}
+			for i := 0; i < 10; i++ {
+				_, message, err := conn.ReadMessage()
+				assert.NoErrorf(t, err, "Read %d should not error: %s", i, err)
 
-	err = json.Unmarshal(message, &response)
-	if err != nil {
-		err = fmt.Errorf("failed to unmarshal %s response: %s", method, err)
-		return
-	}
-	// t.Logf("%s response: %v", method, response)
+				var response RPCResponse
+				err = json.Unmarshal(message, &response)
+				assert.NoErrorf(t, err, "Unmarshal %d should not error: %s", i, err)
+				assert.Equal(t, float64(i), response.Result, "Messages should be received in order")
+			}
 
-	// Parse server response error
-	var result []byte
-	result, err = json.Marshal(response.Error)
-	if err != nil {
-		err = fmt.Errorf("could not marshal error result: %s", err)
-		return
-	}
+			_, _, err = conn.ReadMessage()
+			assert.Error(t, err, "Application should not be connected")
+			assert.Error(t, connection.Send(ResponseWithResult(nil, "closed")), "Send should error on closed connection")
+			assert.True(t, connection.IsClosed(), "Connection should be closed")
+			assert.Error(t, connection.ctx.Err(), "Connection context should be cancelled")
+		},
+	},
+	// Test application updating its metadata after connecting
+	{
+		name:       "UpdateMetadata",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
 
-	err = json.Unmarshal(result, &jrpcErr)
-	if err != nil {
-		err = fmt.Errorf("could not unmarshal error result to jrpc2.Error: %s", err)
-	}
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			tests := []struct {
+				params UpdateMetadata_Params
+				valid  bool
+			}{
+				{UpdateMetadata_Params{Name: "Updated name", Description: "Updated description"}, true},
+				{UpdateMetadata_Params{Url: "https://updated.com"}, true},
+				{UpdateMetadata_Params{Name: "Invalid name ©"}, false},
+				{UpdateMetadata_Params{Description: strings.Repeat("a", 256)}, false},
+				{UpdateMetadata_Params{Url: "ftp://updated.com"}, false},
+			}
 
-	return
-}
+			for i, test := range tests {
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      i,
+					Method:  "UpdateMetadata",
+					Params:  test.params,
+				}
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %d %q should not error: %s", i, request.Method, err)
+				if test.valid {
+					assert.Nil(t, serverErr, "Response %d should not have error: %v", i, serverErr)
+				} else {
+					assert.Error(t, serverErr, "Response %d should have error", i)
+				}
+			}
 
-// Test calling added listeners from account
-func testListener(xswdWallet *walletapi.Wallet_Disk, event rpc.EventType, value interface{}) {
-	if listeners, ok := xswdWallet.GetAccount().EventListeners[event]; ok {
-		for _, listener := range listeners {
-			listener(value)
-		}
-	}
+			apps := server.GetApplications()
+			if assert.Len(t, apps, 1, "There should be one application") {
+				assert.Equal(t, testAppData[0].Id, apps[0].Id, "Application ID should not change")
+				assert.Equal(t, "Updated name", apps[0].Name, "Application name should be updated")
+				assert.Equal(t, "Updated description", apps[0].Description, "Application description should be updated")
+				assert.Equal(t, "https://updated.com", apps[0].Url, "Application url should be updated")
+			}
+		},
+	},
+	// Test UpdateMetadata updates every session of the application
+	{
+		name:       "UpdateMetadataSessions",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			// another session of the same application
+			other := &Connection{closed: make(chan struct{})}
+			other.ctx, other.cancel = context.WithCancel(context.Background())
+			close(other.closed)
+			server.Lock()
+			server.applications[other] = testAppData[0]
+			server.Unlock()
+
+			request := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "UpdateMetadata",
+				Params:  UpdateMetadata_Params{Name: "Updated name"},
+			}
+			_, serverErr, err := testXSWDCall(t, conn, request)
+			assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+			assert.Nil(t, serverErr, "Response should not have error: %v", serverErr)
+
+			apps := server.GetApplications()
+			if assert.Len(t, apps, 2, "There should be two sessions") {
+				for i, app := range apps {
+					assert.Equal(t, "Updated name", app.Name, "Session %d name should be updated", i)
+				}
+			}
+		},
+	},
+	// Test DecodeAddress of integrated and base addresses
+	{
+		name:       "DecodeAddress",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.False(t, server.CanStorePermission("DecodeAddress"), "DecodeAddress should be a noStore method")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			addr, err := rpc.NewAddress(testWalletData[0].Address)
+			assert.NoErrorf(t, err, "Parsing address should not error: %s", err)
+			integrated := addr.Clone()
+			integrated.Arguments = rpc.Arguments{{Name: rpc.RPC_DESTINATION_PORT, DataType: rpc.DataUint64, Value: uint64(1337)}}
+
+			tests := []struct {
+				address    string
+				integrated bool
+				valid      bool
+			}{
+				{testWalletData[0].Address, false, true},
+				{integrated.String(), true, true},
+				{"deto1invalid", false, false},
+			}
+
+			for i, test := range tests {
+				var result DecodeAddress_Result
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      i,
+					Method:  "DecodeAddress",
+					Params:  DecodeAddress_Params{Address: test.address},
+				}
+				response, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %d %q should not error: %s", i, request.Method, err)
+				if !test.valid {
+					assert.Error(t, serverErr, "Response %d should have error", i)
+					continue
+				}
+
+				assert.Nil(t, serverErr, "Response %d should not have error: %v", i, serverErr)
+				js, err := json.Marshal(response.Result)
+				assert.NoErrorf(t, err, "Response %d marshal should not error: %s", i, err)
+				err = json.Unmarshal(js, &result)
+				assert.NoErrorf(t, err, "Response %d unmarshal should not error: %s", i, err)
+				assert.Equal(t, testWalletData[0].Address, result.Address, "Response %d base address does not match", i)
+				assert.False(t, result.Mainnet, "Response %d should not be mainnet", i)
+				assert.Equal(t, test.integrated, result.Integrated, "Response %d integrated does not match", i)
+				if test.integrated {
+					assert.True(t, result.Payload_RPC.Has(rpc.RPC_DESTINATION_PORT, rpc.DataUint64), "Response %d should have destination port", i)
+				}
+			}
+		},
+	},
+	// Test pre-approved application connects without appHandler
+	{
+		name:       "PreApprove",
+		permission: Deny,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			// User would reject any application and deny any request
+			server.appHandler = func(app *ApplicationData) bool { return false }
+
+			server.PreApprove(testAppData[0].Id, testAppData[0].Url, map[string]Permission{
+				"GetAddress": AlwaysAllow,
+				"GetHeight":  AlwaysDeny,
+				"SignData":   AlwaysAllow, // noStore
+				"GetBalance": Allow,       // can't be stored
+			})
+
+			conn, err := testCreateClient(http.Header{"Origin": {testAppData[0].Url}})
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Pre-approved application should be accepted and is not: %s", authResponse.Message)
+
+			apps := server.GetApplications()
+			if assert.Len(t, apps, 1, "There should be one application") {
+				assert.Equal(t, map[string]Permission{"GetAddress": AlwaysAllow, "GetHeight": AlwaysDeny}, apps[0].Permissions, "Seeded permissions do not match")
+			}
+
+			request := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "GetAddress",
+			}
+			_, serverErr, err := testXSWDCall(t, conn, request)
+			assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+			assert.Nil(t, serverErr, "Response should not have error: %v", serverErr)
+
+			// Other applications still go through appHandler
+			conn2, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn2.Close()
+
+			err = conn2.WriteJSON(testAppData[2])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse = testHandleAuthResponse(t, conn2)
+			assert.False(t, authResponse.Accepted, "Application should not be accepted and is")
+
+			// Pre-approved ID is bound to its Url and the origin of the session
+			server.PreApprove(testAppData[2].Id, testAppData[2].Url, nil)
+			impersonated := testAppData[2]
+			impersonated.Url = "http://impersonated.com"
+			tests := []struct {
+				name    string
+				app     ApplicationData
+				origin  string
+				allowed bool
+			}{
+				{"Url", impersonated, "", false},
+				{"Origin", impersonated, impersonated.Url, false},
+				// local program without origin is asked to the user
+				{"NoOrigin", testAppData[2], "", false},
+				{"MatchingOrigin", testAppData[2], testAppData[2].Url, true},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					headers := http.Header{}
+					if test.origin != "" {
+						headers.Set("Origin", test.origin)
+					}
+
+					conn, err := testCreateClient(headers)
+					assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+					defer conn.Close()
+
+					err = conn.WriteJSON(test.app)
+					assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+					authResponse := testHandleAuthResponse(t, conn)
+					assert.Equal(t, test.allowed, authResponse.Accepted, "Application accepted does not match: %s", authResponse.Message)
+					conn.Close()
+					// only the first pre-approved application remains
+					testWaitApplications(t, server, 1)
+				})
+			}
+		},
+	},
+	// Test application connection and last activity timestamps
+	{
+		name:       "ApplicationActivity",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			before := time.Now()
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			_, found := server.GetApplicationByID(testAppData[1].Id)
+			assert.False(t, found, "Application should not be found")
+
+			app, found := server.GetApplicationByID(testAppData[0].Id)
+			assert.True(t, found, "Application should be found")
+			assert.True(t, app.ConnectedAt.After(before), "ConnectedAt should be set when accepted")
+			assert.Equal(t, app.ConnectedAt, app.LastActivity, "LastActivity should be ConnectedAt before any request")
+
+			time.Sleep(sleep50)
+			request := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "GetAddress",
+			}
+			_, _, err = testXSWDCall(t, conn, request)
+			assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+
+			updated, found := server.GetApplicationByID(testAppData[0].Id)
+			assert.True(t, found, "Application should be found")
+			assert.Equal(t, app.ConnectedAt, updated.ConnectedAt, "ConnectedAt should not change")
+			assert.True(t, updated.LastActivity.After(app.LastActivity), "LastActivity should be updated on request")
+			assert.True(t, app.LastActivity.Equal(app.ConnectedAt), "Returned application should be a snapshot")
+		},
+	},
+	// Test alwaysAllow methods are granted without requestHandler
+	{
+		name:       "AlwaysAllow",
+		permission: Deny,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			// User would deny any request
+
+			harmless := handler.New(func(ctx context.Context) bool { return true })
+			server.SetCustomMethodWithPolicy("Harmless", harmless, true)
+			server.SetCustomMethod("Harmful", harmless)
+			assert.True(t, server.IsAlwaysAllowed("Harmless"), "Harmless should be always allowed")
+			assert.False(t, server.IsAlwaysAllowed("Harmful"), "Harmful should not be always allowed")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			call := func(method string, params interface{}) *jrpc2.Error {
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  method,
+					Params:  params,
+				}
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+				return serverErr
+			}
+
+			assert.Nil(t, call("Harmless", nil), "Harmless should be allowed")
+			assert.Nil(t, call("DecodeAddress", DecodeAddress_Params{Address: testWalletData[0].Address}), "DecodeAddress should be allowed")
+			if serverErr := call("Harmful", nil); assert.Error(t, serverErr, "Harmful should be denied") {
+				assert.Equal(t, PermissionDenied, serverErr.Code, "Harmful should be %v: %v", PermissionDenied, serverErr.Code)
+			}
+
+			// Registering again without policy removes the flag
+			server.SetCustomMethod("Harmless", harmless)
+			assert.False(t, server.IsAlwaysAllowed("Harmless"), "Harmless should not be always allowed")
+			if serverErr := call("Harmless", nil); assert.Error(t, serverErr, "Harmless should be denied") {
+				assert.Equal(t, PermissionDenied, serverErr.Code, "Harmless should be %v: %v", PermissionDenied, serverErr.Code)
+			}
+		},
+	},
+	// Test VerifySignatureFrom against expected signers
+	{
+		name:       "VerifySignatureFrom",
+		permission: Deny,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			// User would deny any request
+
+			assert.False(t, server.CanStorePermission("VerifySignatureFrom"), "VerifySignatureFrom should be a noStore method")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			message := "login to dApp"
+			signature := xswdWallet.SignData([]byte(message))
+
+			tests := []struct {
+				signature []byte
+				address   string
+				valid     bool
+				err       bool
+			}{
+				{signature, testWalletData[0].Address, true, false},
+				{signature, "deto1qyvyeyzrcm2fzf6kyq7egkes2ufgny5xn77y6typhfx9s7w3mvyd5qqynr5hx", false, false},
+				{[]byte("not a valid signature"), testWalletData[0].Address, false, false},
+				{signature, "deto1invalid", false, true},
+			}
+
+			for i, test := range tests {
+				var result VerifySignatureFrom_Result
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      i,
+					Method:  "VerifySignatureFrom",
+					Params:  VerifySignatureFrom_Params{Signature: test.signature, Address: test.address},
+				}
+				response, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %d %q should not error: %s", i, request.Method, err)
+				if test.err {
+					assert.Error(t, serverErr, "Response %d should have error", i)
+					continue
+				}
+
+				assert.Nil(t, serverErr, "Response %d should not have error: %v", i, serverErr)
+				js, err := json.Marshal(response.Result)
+				assert.NoErrorf(t, err, "Response %d marshal should not error: %s", i, err)
+				err = json.Unmarshal(js, &result)
+				assert.NoErrorf(t, err, "Response %d unmarshal should not error: %s", i, err)
+				assert.Equal(t, test.valid, result.Valid, "Response %d valid does not match", i)
+				if test.valid {
+					assert.Equal(t, message, result.Message, "Response %d message does not match", i)
+				}
+			}
+		},
+	},
+	// Test removing applications by Id and by connection
+	{
+		name:       "RemoveApplication",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			connect := func(app ApplicationData) *websocket.Conn {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+				err = conn.WriteJSON(app)
+				assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.True(t, authResponse.Accepted, "Application should be accepted and is not: %s", authResponse.Message)
+				return conn
+			}
+
+			// Simulate two sessions sharing the same Id
+			conn := connect(testAppData[0])
+			defer conn.Close()
+			conn2 := connect(testAppData[2])
+			defer conn2.Close()
+
+			server.Lock()
+			for c, a := range server.applications {
+				a.Id = testAppData[0].Id
+				server.applications[c] = a
+			}
+			server.Unlock()
+
+			server.RemoveApplication(&testAppData[0])
+			assert.Equal(t, 0, server.ApplicationCount(), "All sessions with the Id should be removed")
+
+			// Remove by connection
+			conn3 := connect(testAppData[0])
+			defer conn3.Close()
+			conn4 := connect(testAppData[2])
+			defer conn4.Close()
+
+			var session *Connection
+			server.Lock()
+			for c, a := range server.applications {
+				if a.Id == testAppData[0].Id {
+					session = c
+				}
+			}
+			server.Unlock()
+
+			server.RemoveApplicationByConnection(session)
+			apps := server.GetApplications()
+			if assert.Len(t, apps, 1, "There should be one application") {
+				assert.Equal(t, testAppData[2].Id, apps[0].Id, "Other application should not be removed")
+			}
+			assert.True(t, session.IsClosed(), "Connection should be closed")
+		},
+	},
+	// Test malformed params of well-known methods are rejected before requesting permission
+	{
+		name:       "ValidateParams",
+		permission: Deny,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			var prompted int
+			server.requestHandler = func(app *ApplicationData, request *jrpc2.Request) Permission {
+				prompted++
+				return Deny
+			}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			tests := []struct {
+				method string
+				params interface{}
+				code   code.Code
+			}{
+				{"transfer", nil, code.InvalidParams},
+				{"transfer", map[string]interface{}{"transfers": "DERO"}, code.InvalidParams},
+				{"Transfer", rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: "deto1invalid", Amount: 1}}}, code.InvalidParams},
+				{"transfer_split", rpc.Transfer_Params{SC_ID: "DERO"}, code.InvalidParams},
+				{"PreviewTransfer", rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: "deto1invalid", Amount: 1}}}, code.InvalidParams},
+				{"scinvoke", rpc.SC_Invoke_Params{}, code.InvalidParams},
+				{"transfer", rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: testWalletData[0].Address, Amount: 1}}}, PermissionDenied},
+				{"scinvoke", rpc.SC_Invoke_Params{SC_ID: "0000000000000000000000000000000000000000000000000000000000000001"}, PermissionDenied},
+			}
+
+			for i, test := range tests {
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      i,
+					Method:  test.method,
+					Params:  test.params,
+				}
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %d %q should not error: %s", i, request.Method, err)
+				if assert.Error(t, serverErr, "Response %d should have error", i) {
+					assert.Equal(t, test.code, serverErr.Code, "Response %d should be %v: %v", i, test.code, serverErr.Code)
+				}
+			}
+
+			assert.Equal(t, 2, prompted, "User should only be prompted for valid params")
+		},
+	},
+	// Test registration status is returned without asking
+	{
+		name:       "IsRegistered",
+		permission: Deny,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.True(t, server.IsAlwaysAllowed("IsRegistered"), "IsRegistered should be always allowed")
+			assert.True(t, server.IsNoStore("IsRegistered"), "IsRegistered should be noStore")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			response, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "IsRegistered"})
+			assert.NoErrorf(t, err, "IsRegistered should not error: %s", err)
+			assert.Nil(t, serverErr, "IsRegistered should not have error: %v", serverErr)
+			assert.Equal(t, xswdWallet.IsRegistered(), response.Result, "Registration does not match")
+
+			// requestHandler denies, so GetAddress is still not granted
+			_, serverErr, err = testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 2, Method: "GetAddress"})
+			assert.NoErrorf(t, err, "GetAddress should not error: %s", err)
+			assert.NotNil(t, serverErr, "GetAddress should not be allowed")
+		},
+	},
+	// Test other applications are notified when an application connects and disconnects
+	{
+		name:       "ApplicationEvents",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			for i, event := range []rpc.EventType{rpc.AppConnected, rpc.AppDisconnected} {
+				subscribe := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      i + 1,
+					Method:  "Subscribe",
+					Params:  Subscribe_Params{Event: event},
+				}
+				_, serverErr, err := testXSWDCall(t, conn, subscribe)
+				assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+				assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+			}
+
+			readEvent := func() (event rpc.EventType, value ApplicationChange, raw string) {
+				conn.SetReadDeadline(time.Now().Add(time.Second))
+				_, message, err := conn.ReadMessage()
+				assert.NoErrorf(t, err, "Read should not error: %s", err)
+
+				var response RPCResponse
+				err = json.Unmarshal(message, &response)
+				assert.NoErrorf(t, err, "Unmarshal event should not error: %s", err)
+				js, err := json.Marshal(response.Result)
+				assert.NoErrorf(t, err, "Marshal event should not error: %s", err)
+
+				var notification struct {
+					Event rpc.EventType     `json:"event"`
+					Value ApplicationChange `json:"value"`
+				}
+				err = json.Unmarshal(js, &notification)
+				assert.NoErrorf(t, err, "Unmarshal notification should not error: %s", err)
+
+				return notification.Event, notification.Value, string(js)
+			}
+
+			other, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer other.Close()
+
+			err = other.WriteJSON(testAppData[2])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse = testHandleAuthResponse(t, other)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			event, value, raw := readEvent()
+			assert.Equal(t, rpc.EventType(rpc.AppConnected), event, "Event should be %s: %s", rpc.AppConnected, event)
+			assert.Equal(t, ApplicationChange{Id: testAppData[2].Id, Name: testAppData[2].Name, Url: testAppData[2].Url}, value, "Connected application does not match")
+			assert.NotContains(t, raw, "signature", "Event should not expose the application signature")
+			assert.NotContains(t, raw, "description", "Event should not expose the application description")
+
+			// The application is not notified of its own connection
+			other.SetReadDeadline(time.Now().Add(sleep50))
+			_, _, err = other.ReadMessage()
+			assert.Error(t, err, "Application should not receive its own connection event")
+
+			other.Close()
+
+			event, value, _ = readEvent()
+			assert.Equal(t, rpc.EventType(rpc.AppDisconnected), event, "Event should be %s: %s", rpc.AppDisconnected, event)
+			assert.Equal(t, testAppData[2].Id, value.Id, "Disconnected application does not match")
+			assert.NotEmpty(t, value.Reason, "Disconnect reason should be set")
+
+			// applications removed by the host are also reported
+			other, err = testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer other.Close()
+
+			err = other.WriteJSON(testAppData[2])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse = testHandleAuthResponse(t, other)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			event, _, _ = readEvent()
+			assert.Equal(t, rpc.EventType(rpc.AppConnected), event, "Event should be %s: %s", rpc.AppConnected, event)
+
+			server.RemoveApplication(&testAppData[2])
+			event, value, _ = readEvent()
+			assert.Equal(t, rpc.EventType(rpc.AppDisconnected), event, "Event should be %s: %s", rpc.AppDisconnected, event)
+			assert.Equal(t, DisconnectRemoved, value.Reason, "Disconnect reason does not match")
+
+			// Application events are never replayed to new subscribers
+			_, cached := server.latestEvent(testAppData[2].Wallet, rpc.AppConnected)
+			assert.False(t, cached, "Event should not be cached")
+		},
+	},
+	// Test payload arguments of a transfer are decoded by TXID
+	{
+		name:       "GetTransferPayload",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			args := rpc.Arguments{
+				{Name: rpc.RPC_COMMENT, DataType: rpc.DataString, Value: "order 42"},
+				{Name: rpc.RPC_DESTINATION_PORT, DataType: rpc.DataUint64, Value: uint64(42)},
+			}
+			payload, err := args.MarshalBinary()
+			assert.NoErrorf(t, err, "Arguments should marshal: %s", err)
+
+			txid := strings.Repeat("a", 64)
+			invalid := strings.Repeat("b", 64)
+			coinbase := strings.Repeat("c", 64)
+			xswdWallet.InsertReplace(crypto.ZEROHASH, rpc.Entry{Height: 1, TopoHeight: 1, TXID: txid, Incoming: true, Payload: payload})
+			xswdWallet.InsertReplace(crypto.ZEROHASH, rpc.Entry{Height: 2, TopoHeight: 2, TXID: invalid, Incoming: true, Payload: []byte{0xff}, PayloadError: "invalid payload"})
+			xswdWallet.InsertReplace(crypto.ZEROHASH, rpc.Entry{Height: 3, TopoHeight: 3, TXID: coinbase, Coinbase: true})
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			call := func(txid string) (RPCResponse, *jrpc2.Error) {
+				response, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  "GetTransferPayload",
+					Params:  rpc.Get_Transfer_By_TXID_Params{TXID: txid},
+				})
+				assert.NoErrorf(t, err, "GetTransferPayload should not error: %s", err)
+				return response, serverErr
+			}
+
+			response, serverErr := call(txid)
+			assert.Nil(t, serverErr, "GetTransferPayload should not return an error")
+			js, err := json.Marshal(response.Result)
+			assert.NoErrorf(t, err, "Marshal result should not error: %s", err)
+			var result GetTransferPayload_Result
+			err = json.Unmarshal(js, &result)
+			assert.NoErrorf(t, err, "Unmarshal result should not error: %s", err)
+			assert.Equal(t, uint64(42), result.DestinationPort, "Destination port does not match")
+			assert.Equal(t, "order 42", result.Payload_RPC.Value(rpc.RPC_COMMENT, rpc.DataString), "Comment does not match")
+
+			for _, tt := range []struct {
+				txid    string
+				code    code.Code
+				message string
+			}{
+				{strings.Repeat("d", 64), code.InternalError, "not found"},
+				{invalid, code.InternalError, "could not be decoded"},
+				{coinbase, code.InternalError, "no payload"},
+				{"zz", code.InvalidParams, "txid"},
+			} {
+				_, serverErr = call(tt.txid)
+				if assert.NotNil(t, serverErr, "GetTransferPayload of %s should return an error", tt.txid) {
+					assert.Equal(t, tt.code, serverErr.Code, "Error code of %s does not match", tt.txid)
+					assert.Contains(t, serverErr.Message, tt.message, "Error message of %s does not match", tt.txid)
+				}
+			}
+		},
+	},
+	// Test response ID keeps the type of the request ID
+	{
+		name:       "ResponseID",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			tests := []struct {
+				id       string
+				method   string
+				expected string
+			}{
+				{`1`, "Ping", `1`},
+				{`"1"`, "Ping", `"1"`},
+				{`"abc"`, "Ping", `"abc"`},
+				{`42`, "Unknown", `42`},
+				{`"err"`, "Unknown", `"err"`},
+			}
+
+			for _, tt := range tests {
+				err = conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"method":%q}`, tt.id, tt.method)))
+				assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+
+				var response struct {
+					ID json.RawMessage `json:"id"`
+				}
+				err = conn.ReadJSON(&response)
+				assert.NoErrorf(t, err, "Application failed to read response: %s", err)
+				assert.Equal(t, tt.expected, string(response.ID), "Response ID of %s does not match", tt.method)
+			}
+
+			// session errors have no request to respond to
+			err = conn.WriteMessage(websocket.TextMessage, []byte(`{invalid`))
+			assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+
+			_, message, err := conn.ReadMessage()
+			assert.NoErrorf(t, err, "Application failed to read response: %s", err)
+			assert.Contains(t, string(message), `"id":null`, "Session error should have a null ID")
+		},
+	},
+	// Test host methods registered under the namespace
+	{
+		name:       "MethodNamespace",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			t.Run("Options", func(t *testing.T) {
+				tests := []struct {
+					namespace string
+					expected  string
+				}{
+					{"", DefaultMethodNamespace},
+					{"dapp", "dapp."},
+					{"dapp.", "dapp."},
+					{"DERO", DefaultMethodNamespace},
+					{"DERO.", DefaultMethodNamespace},
+				}
+
+				for _, tt := range tests {
+					x := &XSWD{namespace: DefaultMethodNamespace}
+					WithMethodNamespace(tt.namespace)(x)
+					assert.Equal(t, tt.expected, x.Namespace(), "Namespace %q does not match", tt.namespace)
+				}
+			})
+
+			method := server.SetNamespacedMethod("GetAddress", handler.New(func(ctx context.Context) string {
+				return "namespaced"
+			}), false)
+			assert.Equal(t, DefaultMethodNamespace+"GetAddress", method, "Namespaced method name does not match")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			response, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method})
+			assert.NoErrorf(t, err, "%s should not error: %s", method, err)
+			assert.Nil(t, serverErr, "%s should not return an error", method)
+			assert.Equal(t, "namespaced", response.Result, "Namespaced method should be handled by the host handler")
+
+			// wallet method of the same name is left untouched
+			response, serverErr, err = testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 2, Method: "GetAddress"})
+			assert.NoErrorf(t, err, "GetAddress should not error: %s", err)
+			assert.Nil(t, serverErr, "GetAddress should not return an error")
+			assert.NotEqual(t, "namespaced", response.Result, "Wallet method should not be overridden")
+
+			_, serverErr, err = testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 3, Method: DefaultMethodNamespace + "Unknown"})
+			assert.NoErrorf(t, err, "Unknown namespaced method should not error: %s", err)
+			if assert.NotNil(t, serverErr, "Unknown namespaced method should return an error") {
+				assert.Equal(t, code.MethodNotFound, serverErr.Code, "Unknown namespaced method should not be found")
+			}
+		},
+	},
+	// Test stored permissions report if they survive a restart
+	{
+		name:       "GetPermissions",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.True(t, server.IsAlwaysAllowed("GetPermissions"), "GetPermissions should be always allowed")
+
+			permissions := map[string]Permission{
+				"GetAddress": AlwaysAllow,
+				"GetHeight":  SessionAllow,
+				"GetBalance": AlwaysDeny,
+			}
+			server.requestHandler = func(app *ApplicationData, r *jrpc2.Request) Permission {
+				return permissions[r.Method()]
+			}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			for method := range permissions {
+				_, _, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method})
+				assert.NoErrorf(t, err, "Call %s should not error: %s", method, err)
+			}
+
+			response, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "GetPermissions"})
+			assert.NoErrorf(t, err, "GetPermissions should not error: %s", err)
+			assert.Nil(t, serverErr, "GetPermissions should not have error: %v", serverErr)
+
+			var result GetPermissions_Result
+			js, err := json.Marshal(response.Result)
+			assert.NoErrorf(t, err, "GetPermissions result marshal should not error: %s", err)
+			err = json.Unmarshal(js, &result)
+			assert.NoErrorf(t, err, "GetPermissions result unmarshal should not error: %s", err)
+
+			expected := map[string]PermissionInfo{
+				"GetAddress": {Permission: AlwaysAllow, Persisted: true},
+				"GetHeight":  {Permission: SessionAllow, Persisted: false},
+				"GetBalance": {Permission: AlwaysDeny, Persisted: true},
+			}
+			assert.Equal(t, expected, result.Permissions, "Permissions do not match")
+
+			app, found := server.GetApplicationByID(testAppData[0].Id)
+			assert.True(t, found, "Application should be found")
+			assert.Equal(t, map[string]bool{"GetAddress": true, "GetHeight": false, "GetBalance": true}, app.Persisted, "Persisted permissions do not match")
+		},
+	},
+	// Test paused application requests are cancelled and it doesn't receive events until resumed
+	{
+		name:       "PauseApplication",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			call := func(method string, params interface{}) (RPCResponse, *jrpc2.Error) {
+				response, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+				assert.NoErrorf(t, err, "Call %s should not error: %s", method, err)
+				return response, serverErr
+			}
+
+			_, serverErr := call("Subscribe", Subscribe_Params{Event: rpc.NewTopoheight})
+			assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+
+			id := testAppData[0].Id
+			assert.False(t, server.PauseApplication(testAppData[1].Id), "Not connected application should not be paused")
+			assert.True(t, server.PauseApplication(id), "Application should be paused")
+			app, _ := server.GetApplicationByID(id)
+			assert.True(t, app.IsPaused(), "Application snapshot should be paused")
+
+			_, serverErr = call("GetAddress", nil)
+			if assert.NotNil(t, serverErr, "Paused application request should be cancelled") {
+				assert.Equal(t, code.Cancelled, serverErr.Code, "Paused application error code does not match")
+				assert.Equal(t, "application paused", serverErr.Message, "Paused application error message does not match")
+			}
+
+			response, serverErr := call("Ping", nil)
+			assert.Nil(t, serverErr, "Ping should be answered while paused: %v", serverErr)
+			assert.Equal(t, "Pong", response.Result, "Ping result does not match")
+
+			// event is not sent while paused
+			testListener(xswdWallet, rpc.NewTopoheight, int64(700))
+
+			assert.True(t, server.ResumeApplication(id), "Application should be resumed")
+			_, serverErr = call("GetAddress", nil)
+			assert.Nil(t, serverErr, "Resumed application request should not have error: %v", serverErr)
+
+			// subscription is kept, first event read is the one sent once resumed
+			testListener(xswdWallet, rpc.NewTopoheight, int64(701))
+			var event struct {
+				Result rpc.EventNotification `json:"result"`
+			}
+			err = conn.ReadJSON(&event)
+			assert.NoErrorf(t, err, "Read event should not error: %s", err)
+			assert.Equal(t, rpc.EventType(rpc.NewTopoheight), event.Result.Event, "Event does not match")
+			assert.Equal(t, float64(701), event.Result.Value, "Event sent while paused should not be received")
+		},
+	},
+	// Test network info returns the transfer defaults of the wallet without asking
+	{
+		name:       "GetNetworkInfo",
+		permission: Deny,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.True(t, server.IsAlwaysAllowed("GetNetworkInfo"), "GetNetworkInfo should be always allowed")
+			assert.True(t, server.IsNoStore("GetNetworkInfo"), "GetNetworkInfo should be noStore")
+
+			xswdWallet.SetRingSize(32)
+			xswdWallet.SetFeeMultiplier(1.5)
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			response, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "GetNetworkInfo"})
+			assert.NoErrorf(t, err, "GetNetworkInfo should not error: %s", err)
+			assert.Nil(t, serverErr, "GetNetworkInfo should not have error: %v", serverErr)
+
+			var result GetNetworkInfo_Result
+			js, err := json.Marshal(response.Result)
+			assert.NoErrorf(t, err, "GetNetworkInfo result marshal should not error: %s", err)
+			err = json.Unmarshal(js, &result)
+			assert.NoErrorf(t, err, "GetNetworkInfo result unmarshal should not error: %s", err)
+
+			assert.Equal(t, xswdWallet.GetNetwork(), result.Mainnet, "Network does not match")
+			assert.Equal(t, 32, result.Ringsize, "Ringsize does not match")
+			assert.Equal(t, float32(1.5), result.FeesMultiplier, "Fees multiplier does not match")
+			assert.Equal(t, config.FEE_PER_KB, result.FeePerKB, "Fee per KB does not match")
+		},
+	},
+	// Test transfers below the permission threshold are allowed without asking
+	{
+		name:       "PermissionThreshold",
+		permission: Deny,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			id := testAppData[0].Id
+			assert.Error(t, server.SetPermissionThreshold(id, "GetAddress", 100000), "Non transfer method should not have a threshold")
+			assert.Error(t, server.SetPermissionThreshold(testAppData[1].Id, "transfer", 100000), "Not connected application should not have a threshold")
+			assert.NoError(t, server.SetPermissionThreshold(id, "transfer", 100000), "Transfer threshold should be set")
+
+			// requestHandler denies, so only transfers allowed by the threshold reach the handler
+			denied := func(method string, params rpc.Transfer_Params) bool {
+				_, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+				assert.NoErrorf(t, err, "Call %s should not error: %s", method, err)
+				return serverErr != nil && serverErr.Code == PermissionDenied
+			}
+
+			destination := testWalletData[0].Address
+			below := rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: destination, Amount: 60000}, {Destination: destination, Burn: 30000}}}
+			above := rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: destination, Amount: 60000}, {Destination: destination, Amount: 40000}}}
+			token := rpc.Transfer_Params{Transfers: []rpc.Transfer{{SCID: crypto.HashHexToHash("0000000000000000000000000000000000000000000000000000000000000001"), Destination: destination, Amount: 1}}}
+			sc := rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: destination, Amount: 1}}, SC_ID: "0000000000000000000000000000000000000000000000000000000000000001"}
+			overflow := rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: destination, Amount: math.MaxUint64}, {Destination: destination, Amount: 2}}}
+
+			assert.False(t, denied("transfer", below), "Transfer below threshold should be allowed")
+			assert.True(t, denied("transfer", above), "Transfer at threshold should ask")
+			assert.True(t, denied("transfer", token), "Token transfer should ask")
+			assert.True(t, denied("transfer", sc), "SC transfer should ask")
+			assert.True(t, denied("transfer", overflow), "Overflowing transfer should ask")
+			assert.True(t, denied("transfer_split", below), "Threshold should only apply to its method")
+
+			assert.NoError(t, server.SetPermissionThreshold(id, "transfer", 0), "Transfer threshold should be removed")
+			assert.True(t, denied("transfer", below), "Transfer should ask once threshold is removed")
+		},
+	},
+	// Test sessions are identified by their SessionID to revoke permissions and remove them
+	{
+		name:       "SessionID",
+		permission: AlwaysAllow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			var conns []*websocket.Conn
+			for _, app := range testAppData[:2] {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+				defer conn.Close()
+
+				err = conn.WriteJSON(app)
+				assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+				conns = append(conns, conn)
+			}
+
+			app0, _ := server.GetApplicationByID(testAppData[0].Id)
+			app1, _ := server.GetApplicationByID(testAppData[1].Id)
+			assert.NotEmpty(t, app0.SessionID, "Application session ID should be assigned")
+			assert.NotEqual(t, app0.SessionID, app1.SessionID, "Session IDs should be unique")
+
+			_, serverErr, err := testXSWDCall(t, conns[0], jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "GetAddress"})
+			assert.NoErrorf(t, err, "GetAddress should not error: %s", err)
+			assert.Nil(t, serverErr, "GetAddress should not have error: %v", serverErr)
+
+			assert.False(t, server.RevokeSessionPermission(app1.SessionID, "GetAddress"), "Other session should not have GetAddress permission")
+			assert.True(t, server.RevokeSessionPermission(app0.SessionID, "GetAddress"), "GetAddress permission should be revoked")
+			assert.False(t, server.RevokeSessionPermission(app0.SessionID, "GetAddress"), "GetAddress permission should already be revoked")
+
+			assert.False(t, server.RemoveApplicationBySession("unknown"), "Unknown session should not be removed")
+			assert.True(t, server.RemoveApplicationBySession(app0.SessionID), "Session should be removed")
+			assert.False(t, server.HasApplicationId(testAppData[0].Id), "Removed application should not be connected")
+			assert.True(t, server.HasApplicationId(testAppData[1].Id), "Other application should still be connected")
+		},
+	},
+	// Test requests are counted per method for each application
+	{
+		name:       "MethodStats",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			stats, found := server.MethodStats(testAppData[0].Id)
+			assert.True(t, found, "Application stats should be found")
+			assert.Empty(t, stats, "Application should not have made any request")
+
+			calls := map[string]int{"GetAddress": 3, "GetHeight": 1, "Unknown": 2}
+			for method, count := range calls {
+				for i := 0; i < count; i++ {
+					_, _, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method})
+					assert.NoErrorf(t, err, "Call %s should not error: %s", method, err)
+				}
+			}
+
+			stats, found = server.MethodStats(strings.ToUpper(testAppData[0].Id))
+			assert.True(t, found, "Application stats should be found case insensitively")
+			assert.Len(t, stats, len(calls), "Stats should count each method called")
+			for method, count := range calls {
+				assert.Equal(t, uint64(count), stats[method], "Calls to %s do not match", method)
+			}
+
+			// returned stats are a copy
+			stats["GetAddress"] = 0
+			stats, _ = server.MethodStats(testAppData[0].Id)
+			assert.Equal(t, uint64(3), stats["GetAddress"], "Stats should not be modified by the caller")
+
+			_, found = server.MethodStats(testAppData[1].Id)
+			assert.False(t, found, "Stats of a not connected application should not be found")
+		},
+	},
+	// Test the validation endpoint reports the failing step without connecting
+	{
+		name:       "Validate",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			u := url.URL{Scheme: "http", Host: "127.0.0.1:44326", Path: "/xswd/validate"}
+
+			resp, err := http.Get(u.String())
+			assert.NoErrorf(t, err, "Validate GET should not error: %s", err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode, "Validate should only accept POST")
+
+			validate := func(body []byte, origin string) (result ValidateApplication_Result) {
+				request, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(string(body)))
+				assert.NoErrorf(t, err, "Create validate request should not error: %s", err)
+				if origin != "" {
+					request.Header.Set("Origin", origin)
+				}
+
+				resp, err := http.DefaultClient.Do(request)
+				assert.NoErrorf(t, err, "Validate request should not error: %s", err)
+				defer resp.Body.Close()
+
+				err = json.NewDecoder(resp.Body).Decode(&result)
+				assert.NoErrorf(t, err, "Decode validation result should not error: %s", err)
+
+				return
+			}
+
+			invalidID := testAppData[0]
+			invalidID.Id = "invalid"
+			invalidName := testAppData[0]
+			invalidName.Name = "Appé"
+			invalidURL := testAppData[0]
+			invalidURL.Url = "ftp://testapp0.com"
+			mismatch := testAppData[1]
+			mismatch.Id = testAppData[0].Id
+			unsigned := testAppData[0]
+			unsigned.Permissions = map[string]Permission{"GetAddress": AlwaysAllow}
+
+			tests := []struct {
+				name   string
+				app    ApplicationData
+				origin string
+				step   ValidationStep
+			}{
+				{"Valid", testAppData[0], "", ""},
+				{"Signed", testAppData[1], "", ""},
+				{"ID", invalidID, "", ValidateID},
+				{"Name", invalidName, "", ValidateName},
+				{"URL", invalidURL, "", ValidateURL},
+				{"Origin", testAppData[0], "http://invalidtestorigin.com", ValidateOrigin},
+				{"SignatureID", mismatch, "", ValidateSignatureID},
+				{"Permissions", unsigned, "", ValidatePermissions},
+			}
+
+			for _, test := range tests {
+				body, err := json.Marshal(test.app)
+				assert.NoErrorf(t, err, "Marshal %s should not error: %s", test.name, err)
+				result := validate(body, test.origin)
+				assert.Equal(t, test.step == "", result.Valid, "%s valid does not match: %s", test.name, result.Message)
+				assert.Equal(t, test.step, result.Step, "%s step does not match: %s", test.name, result.Message)
+			}
+
+			result := validate([]byte(`{"id":`), "")
+			assert.False(t, result.Valid, "Invalid JSON should not be valid")
+			assert.Equal(t, ValidateFormat, result.Step, "Invalid JSON step does not match")
+
+			assert.Zero(t, server.ApplicationCount(), "Validation should not connect any application")
+		},
+	},
+	// Test subscription ID set by the application is echoed in its notifications
+	{
+		name:       "SubscriptionID",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			call := func(method string, p Subscribe_Params) *jrpc2.Error {
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  method,
+					Params:  p,
+				}
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %q should not error: %s", method, err)
+
+				return serverErr
+			}
+
+			readEvent := func() (event rpc.EventNotification) {
+				_, message, err := conn.ReadMessage()
+				assert.NoErrorf(t, err, "Read event should not error: %s", err)
+
+				var response RPCResponse
+				err = json.Unmarshal(message, &response)
+				assert.NoErrorf(t, err, "Unmarshal event should not error: %s", err)
+				js, err := json.Marshal(response.Result)
+				assert.NoErrorf(t, err, "Marshal event should not error: %s", err)
+				err = json.Unmarshal(js, &event)
+				assert.NoErrorf(t, err, "Unmarshal event result should not error: %s", err)
+
+				return
+			}
+
+			serverErr := call("Subscribe", Subscribe_Params{Event: rpc.NewTopoheight, SubscriptionID: strings.Repeat("a", 65)})
+			assert.NotNil(t, serverErr, "Subscription ID too long should error")
+
+			serverErr = call("Subscribe", Subscribe_Params{Event: rpc.NewTopoheight, SubscriptionID: "topo-1"})
+			assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+			serverErr = call("Subscribe", Subscribe_Params{Event: rpc.NewBalance})
+			assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+
+			server.BroadcastEvent(rpc.NewTopoheight, 10)
+			assert.Equal(t, "topo-1", readEvent().SubscriptionID, "Subscription ID should be echoed")
+			server.BroadcastEvent(rpc.NewBalance, rpc.BalanceChange{})
+			assert.Empty(t, readEvent().SubscriptionID, "Subscription without ID should not have one")
+
+			// Subscription ID is discarded when unsubscribing
+			serverErr = call("Unsubscribe", Subscribe_Params{Event: rpc.NewTopoheight})
+			assert.Nil(t, serverErr, "Unsubscribe should not have error: %v", serverErr)
+			serverErr = call("Subscribe", Subscribe_Params{Event: rpc.NewTopoheight})
+			assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+			server.BroadcastEvent(rpc.NewTopoheight, 11)
+			assert.Empty(t, readEvent().SubscriptionID, "Subscription ID should be discarded")
+		},
+	},
+	// Test event values not matching their event type are not broadcasted
+	{
+		name:       "EventValueType",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			tests := []struct {
+				event rpc.EventType
+				value interface{}
+				valid bool
+			}{
+				{rpc.NewTopoheight, int64(600), true},
+				{rpc.NewTopoheight, float64(600), true},
+				{rpc.NewTopoheight, rpc.BalanceChange{}, false},
+				{rpc.NewBalance, rpc.BalanceChange{}, true},
+				{rpc.NewBalance, uint64(1), false},
+				{rpc.NewEntry, rpc.Entry{}, true},
+				{rpc.NewEntry, &rpc.Entry{}, false},
+				{rpc.SyncProgress, rpc.SyncProgressChange{}, true},
+				{rpc.WalletHeight, uint64(1), true},
+				{rpc.WalletLocked, true, true},
+				{rpc.WalletLocked, "true", false},
+				{rpc.EventType("custom"), "anything", true},
+			}
+
+			for _, test := range tests {
+				assert.Equal(t, test.valid, server.isEventValueValid(test.event, test.value), "Event %s with %T valid should be %t", test.event, test.value, test.valid)
+			}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			subscribe := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Subscribe",
+				Params:  Subscribe_Params{Event: rpc.NewTopoheight},
+			}
+			_, serverErr, err := testXSWDCall(t, conn, subscribe)
+			assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+			assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+
+			// Mismatched value is skipped, next message is the valid one
+			server.BroadcastEvent(rpc.NewTopoheight, rpc.BalanceChange{})
+			server.BroadcastEvent(rpc.NewTopoheight, 11)
+
+			_, message, err := conn.ReadMessage()
+			assert.NoErrorf(t, err, "Read event should not error: %s", err)
+			var response RPCResponse
+			err = json.Unmarshal(message, &response)
+			assert.NoErrorf(t, err, "Unmarshal event should not error: %s", err)
+			if result, ok := response.Result.(map[string]interface{}); assert.True(t, ok, "Event result should be a map") {
+				assert.Equal(t, float64(11), result["value"], "Only the valid event should be received")
+			}
+		},
+	},
+	// Test method not found error suggests close methods
+	{
+		name:       "MethodSuggestions",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.Equal(t, 0, levenshtein("GetAddress", "GetAddress"), "Same names should have no distance")
+			assert.Equal(t, 1, levenshtein("getadress", "getaddress"), "Missing letter should be one edit")
+			assert.Equal(t, 3, levenshtein("", "abc"), "Empty name distance should be its length")
+
+			assert.Contains(t, server.suggestMethods("getadress"), "GetAddress", "Mistyped method should suggest GetAddress")
+			assert.Contains(t, server.suggestMethods("PreviewSC"), "PreviewSCInvoke", "Prefix should suggest the method")
+			assert.LessOrEqual(t, len(server.suggestMethods("get")), maxMethodSuggestions, "Suggestions should be limited")
+			assert.Empty(t, server.suggestMethods("UnknownMethodWithoutMatch"), "Unrelated method should not have suggestions")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			request := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "getadress",
+			}
+			_, serverErr, err := testXSWDCall(t, conn, request)
+			assert.NoErrorf(t, err, "Request should not error: %s", err)
+			if assert.NotNil(t, serverErr, "Unknown method should error") {
+				assert.Equal(t, code.MethodNotFound, serverErr.Code, "Error should be %v: %v", code.MethodNotFound, serverErr.Code)
+
+				var data MethodNotFound_Data
+				err = json.Unmarshal(serverErr.Data, &data)
+				assert.NoErrorf(t, err, "Unmarshal error data should not error: %s", err)
+				assert.Equal(t, "getadress", data.Method, "Error data method does not match")
+				assert.Contains(t, data.Suggestions, "GetAddress", "Error data should suggest GetAddress")
+			}
+		},
+	},
+	// Test subscribing with a replay of the latest event value
+	{
+		name:       "SubscribeReplay",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			call := func(method string, replay bool) {
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  method,
+					Params:  Subscribe_Params{Event: rpc.NewTopoheight, ReplayLatest: replay},
+				}
+				response, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %q should not error: %s", method, err)
+				assert.Nil(t, serverErr, "Request %q should not have error: %v", method, serverErr)
+				assert.Equal(t, true, response.Result, "Request %q should succeed", method)
+			}
+
+			readEvent := func() (event rpc.EventNotification) {
+				_, message, err := conn.ReadMessage()
+				assert.NoErrorf(t, err, "Read event should not error: %s", err)
+
+				var response RPCResponse
+				err = json.Unmarshal(message, &response)
+				assert.NoErrorf(t, err, "Unmarshal event should not error: %s", err)
+				js, err := json.Marshal(response.Result)
+				assert.NoErrorf(t, err, "Marshal event should not error: %s", err)
+				err = json.Unmarshal(js, &event)
+				assert.NoErrorf(t, err, "Unmarshal event result should not error: %s", err)
+
+				return
+			}
+
+			// Nothing broadcasted yet, nothing is replayed
+			server.Lock()
+			delete(server.latestEvents, eventKey{"", rpc.NewTopoheight})
+			server.Unlock()
+			call("Subscribe", true)
+			server.BroadcastEvent(rpc.NewTopoheight, 10)
+			event := readEvent()
+			assert.Equal(t, float64(10), event.Value, "Broadcast value does not match")
+			call("Unsubscribe", false)
+
+			// Latest value is sent after the Subscribe response
+			call("Subscribe", true)
+			event = readEvent()
+			assert.Equal(t, rpc.EventType(rpc.NewTopoheight), event.Event, "Replayed event does not match")
+			assert.Equal(t, float64(10), event.Value, "Replayed value does not match")
+			call("Unsubscribe", false)
+
+			// Without replay the next message is the Unsubscribe response
+			call("Subscribe", false)
+			call("Unsubscribe", false)
+		},
+	},
+	// Test health endpoint
+	{
+		name:       "Health",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			u := url.URL{Scheme: "http", Host: "127.0.0.1:44326", Path: "/health"}
+			resp, err := http.Get(u.String())
+			assert.NoErrorf(t, err, "Health request should not error: %s", err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode, "Health status code should be OK")
+			assert.Equal(t, "application/json", resp.Header.Get("Content-Type"), "Health should be JSON")
+
+			var health HealthStatus
+			err = json.NewDecoder(resp.Body).Decode(&health)
+			assert.NoErrorf(t, err, "Decode health should not error: %s", err)
+			assert.True(t, health.Running, "Health should be running")
+			assert.Equal(t, 1, health.Applications, "Health should have one application")
+			assert.False(t, health.DaemonOnline, "Health daemon should be offline")
+			assert.GreaterOrEqual(t, health.Uptime, int64(1), "Health uptime should be at least one second")
+		},
+	},
+	// Test GetTransfers pagination
+	{
+		name:       "GetTransfersPagination",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			for i := 1; i <= 5; i++ {
+				xswdWallet.InsertReplace(crypto.ZEROHASH, rpc.Entry{Height: uint64(i), TopoHeight: int64(i), Incoming: true, Amount: uint64(i)})
+			}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			tests := []struct {
+				name    string
+				offset  uint64
+				limit   uint64
+				heights []uint64
+			}{
+				{"All", 0, 0, []uint64{1, 2, 3, 4, 5}},
+				{"Page", 1, 2, []uint64{2, 3}},
+				{"Offset", 3, 0, []uint64{4, 5}},
+				{"Limit", 0, 10, []uint64{1, 2, 3, 4, 5}},
+				{"OutOfRange", 10, 2, []uint64{}},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					request := jsonrpc.RPCRequest{
+						JSONRPC: "2.0",
+						ID:      1,
+						Method:  "GetTransfers",
+						Params:  rpc.Get_Transfers_Params{In: true, Offset: test.offset, Limit: test.limit},
+					}
+					response, serverErr, err := testXSWDCall(t, conn, request)
+					assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+					assert.Nil(t, serverErr, "Request %q should not have error: %v", request.Method, serverErr)
+
+					js, err := json.Marshal(response.Result)
+					assert.NoErrorf(t, err, "Marshal result should not error: %s", err)
+					var result rpc.Get_Transfers_Result
+					err = json.Unmarshal(js, &result)
+					assert.NoErrorf(t, err, "Unmarshal result should not error: %s", err)
+
+					heights := []uint64{}
+					for _, e := range result.Entries {
+						heights = append(heights, e.Height)
+					}
+					assert.Equal(t, test.heights, heights, "Entries heights do not match")
+					assert.Equal(t, uint64(5), result.Total, "Total should be all entries")
+				})
+			}
+		},
+	},
+	// Test noStore methods changed at runtime
+	{
+		name:       "NoStoreRuntime",
+		permission: AlwaysAllow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			defaults := append([]string{}, DefaultNoStore...)
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			method := "GetAddress"
+			call := func() Permission {
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  method,
+				}
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %q should not error: %s", method, err)
+				assert.Nil(t, serverErr, "Request %q should not have error: %v", method, serverErr)
+
+				app, _ := server.GetApplicationByID(testAppData[0].Id)
+				return app.Permissions[method]
+			}
+
+			assert.Equal(t, AlwaysAllow, call(), "%s should be stored", method)
+
+			// Stored permission is removed immediately
+			server.AddNoStore(method)
+			server.AddNoStore(method)
+			assert.True(t, server.IsNoStore(method), "%s should be noStore", method)
+			assert.Equal(t, len(defaults)+1, len(server.NoStoreMethods()), "%s should be added once", method)
+			assert.Equal(t, defaults, DefaultNoStore, "DefaultNoStore should not be modified")
+			app, _ := server.GetApplicationByID(testAppData[0].Id)
+			_, found := app.Permissions[method]
+			assert.False(t, found, "%s stored permission should be removed", method)
+			assert.Equal(t, Ask, call(), "%s should not be stored", method)
+
+			server.RemoveNoStore(method)
+			assert.False(t, server.IsNoStore(method), "%s should not be noStore", method)
+			assert.Equal(t, defaults, server.NoStoreMethods(), "noStore methods should be back to defaults")
+			assert.Equal(t, AlwaysAllow, call(), "%s should be stored again", method)
+		},
+	},
+	// Test events tracked by all applications
+	{
+		name:       "TrackedEvents",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.Empty(t, server.TrackedEvents(), "There should be no tracked events")
+
+			subscriptions := [][]rpc.EventType{
+				{rpc.NewTopoheight, rpc.NewEntry},
+				{rpc.NewTopoheight, rpc.NewBalance},
+			}
+
+			for i, app := range []ApplicationData{testAppData[0], testAppData[2]} {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application %d failed to dial server: %s", i, err)
+				defer conn.Close()
+
+				err = conn.WriteJSON(app)
+				assert.NoErrorf(t, err, "Application %d failed to write data to server: %s", i, err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.True(t, authResponse.Accepted, "Application %d should be accepted and is not: %s", i, authResponse.Message)
+
+				for _, event := range subscriptions[i] {
+					subscribe := jsonrpc.RPCRequest{
+						JSONRPC: "2.0",
+						ID:      1,
+						Method:  "Subscribe",
+						Params:  Subscribe_Params{Event: event},
+					}
+					_, serverErr, err := testXSWDCall(t, conn, subscribe)
+					assert.NoErrorf(t, err, "Subscribe %d should not error: %s", i, err)
+					assert.Nil(t, serverErr, "Subscribe %d should not have error: %v", i, serverErr)
+				}
+			}
+
+			expected := []rpc.EventType{rpc.NewBalance, rpc.NewEntry, rpc.NewTopoheight}
+			assert.Equal(t, expected, server.TrackedEvents(), "Tracked events do not match")
+		},
+	},
+	// Test requests are rejected while wallet is locked
+	{
+		name:       "WalletLocked",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.True(t, server.IsEventSupported(rpc.WalletLocked), "WalletLocked should be supported")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			subscribe := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Subscribe",
+				Params:  Subscribe_Params{Event: rpc.WalletLocked},
+			}
+			_, serverErr, err := testXSWDCall(t, conn, subscribe)
+			assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+			assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+
+			for _, locked := range []bool{true, false} {
+				server.SetWalletLocked(locked)
+				assert.Equal(t, locked, server.IsWalletLocked(), "Wallet locked should be %t", locked)
+
+				var event RPCResponse
+				err = conn.ReadJSON(&event)
+				assert.NoErrorf(t, err, "Read event should not error: %s", err)
+				assert.Equal(t, map[string]interface{}{"event": rpc.WalletLocked, "value": locked}, event.Result, "WalletLocked event does not match")
+
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      2,
+					Method:  "GetAddress",
+				}
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+				if locked {
+					if assert.Error(t, serverErr, "Request %q should error while wallet is locked", request.Method) {
+						assert.Equal(t, code.Cancelled, serverErr.Code, "Request %q should be %v: %v", request.Method, code.Cancelled, serverErr.Code)
+					}
+				} else {
+					assert.Nil(t, serverErr, "Request %q should not have error when unlocked: %v", request.Method, serverErr)
+				}
+			}
+		},
+	},
+	// Test an application requesting the permissions of several methods at once
+	{
+		name:       "RequestPermissions",
+		permission: AlwaysAllow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn := testConnectApplication(t, testAppData[0])
+
+			_, serverErr := testRequestPermissions(t, conn, nil)
+			if assert.NotNil(t, serverErr, "Empty methods should error") {
+				assert.Equal(t, code.InvalidParams, serverErr.Code, "Empty methods should be %v: %v", code.InvalidParams, serverErr.Code)
+			}
+
+			_, serverErr = testRequestPermissions(t, conn, []string{"GetAddress", "UnknownMethod"})
+			assert.NotNil(t, serverErr, "Unknown method should error")
+			app, _ := server.GetApplicationByID(testAppData[0].Id)
+			assert.Empty(t, app.Permissions, "No permission should be stored when a method is unknown")
+
+			permissions, serverErr := testRequestPermissions(t, conn, []string{"GetAddress", "GetHeight", "GetAddress", "SignData", "DecodeAddress"})
+			assert.Nil(t, serverErr, "RequestPermissions should not have error: %v", serverErr)
+			assert.Equal(t, map[string]Permission{
+				"GetAddress":    AlwaysAllow,
+				"GetHeight":     AlwaysAllow,
+				"SignData":      Ask, // noStore
+				"DecodeAddress": Allow,
+			}, permissions, "Permissions do not match")
+
+			app, _ = server.GetApplicationByID(testAppData[0].Id)
+			assert.Equal(t, AlwaysAllow, app.Permissions["GetAddress"], "GetAddress permission should be stored")
+			_, found := app.Permissions["SignData"]
+			assert.False(t, found, "SignData permission should not be stored")
+		},
+	},
+}
+
+// TestXSWDServerWithOptions tests features depending on server options, each test runs on its own server
+func TestXSWDServerWithOptions(t *testing.T) {
+	for _, test := range testServerOptions {
+		t.Run(test.name, func(t *testing.T) {
+			xswdWallet, server, err := testNewXSWDServerWithOptions(t, true, test.permission, test.options...)
+			assert.NoErrorf(t, err, "testNewXSWDServerWithOptions should not error: %s", err)
+			t.Cleanup(server.Stop)
+
+			test.run(t, xswdWallet, server)
+		})
+	}
+}
+
+// Tests of the server options, each test runs on its own server started with the options
+var testServerOptions = []struct {
+	name       string
+	permission Permission
+	options    []Option
+	run        func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD)
+}{
+	// Test signature bound to a challenge issued by the server
+	{
+		name:       "SignatureChallenge",
+		permission: Allow,
+		options:    []Option{WithSignatureChallenge(true)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			// Read the challenge sent as first message
+			readChallenge := func(conn *websocket.Conn) (challenge AuthorizationChallenge) {
+				_, message, err := conn.ReadMessage()
+				assert.NoErrorf(t, err, "Reading challenge should not error: %s", err)
+				err = json.Unmarshal(message, &challenge)
+				assert.NoErrorf(t, err, "Unmarshal challenge should not error: %s", err)
+				assert.Len(t, challenge.Challenge, 64, "Challenge should be 32 bytes hex encoded")
+				return
+			}
+
+			// App 1 signature only signs its ID and can't be replayed
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			readChallenge(conn)
+			err = conn.WriteJSON(testAppData[1])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.False(t, authResponse.Accepted, "Application should not be accepted without challenge and is")
+			assert.Len(t, server.GetApplications(), 0, "There should be no applications")
+
+			// Sign ID with issued challenge
+			conn2, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn2.Close()
+
+			challenge := readChallenge(conn2)
+			app := testAppData[1]
+			app.Signature = xswdWallet.SignData([]byte(app.Id + challenge.Challenge))
+			err = conn2.WriteJSON(app)
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse = testHandleAuthResponse(t, conn2)
+			assert.True(t, authResponse.Accepted, "Application should be accepted with challenge and is not: %s", authResponse.Message)
+			assert.Len(t, server.GetApplications(), 1, "There should be one application")
+
+			// Apps without signature are not affected
+			conn3, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn3.Close()
+
+			readChallenge(conn3)
+			err = conn3.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse = testHandleAuthResponse(t, conn3)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not: %s", authResponse.Message)
+		},
+	},
+	// Test onRequest audit callback fires for each resolved request
+	{
+		name:       "OnRequest",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			type audit struct {
+				app        string
+				method     string
+				permission Permission
+				err        error
+			}
+
+			var mu sync.Mutex
+			var audits []audit
+			server.onRequest = func(app *ApplicationData, method string, permission Permission, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				audits = append(audits, audit{app.Id, method, permission, err})
+			}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			tests := []struct {
+				method     string
+				handler    Permission
+				permission Permission
+				code       code.Code
+			}{
+				{"GetAddress", Allow, Allow, 0},
+				{"GetAddress", Deny, Deny, PermissionDenied},
+				{"UnknownMethod", Allow, Deny, code.MethodNotFound},
+				{"DERO.GetInfo", Allow, Deny, code.Cancelled}, // daemon is offline
+			}
+
+			for i, test := range tests {
+				server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission { return test.handler }
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      i,
+					Method:  test.method,
+				}
+				_, _, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %d %q should not error: %s", i, request.Method, err)
+
+				mu.Lock()
+				if assert.Len(t, audits, i+1, "Request %d %q should be audited once", i, request.Method) {
+					a := audits[i]
+					assert.Equal(t, testAppData[0].Id, a.app, "Audit %d app does not match", i)
+					assert.Equal(t, test.method, a.method, "Audit %d method does not match", i)
+					assert.Equal(t, test.permission, a.permission, "Audit %d permission does not match", i)
+					if test.code == 0 {
+						assert.NoError(t, a.err, "Audit %d should not have error", i)
+					} else if assert.Error(t, a.err, "Audit %d should have error", i) {
+						assert.Equal(t, test.code, a.err.(*jrpc2.Error).Code, "Audit %d error code does not match", i)
+					}
+				}
+				mu.Unlock()
+			}
+		},
+	},
+	// Test Disconnect method and onDisconnect reasons
+	{
+		name:       "Disconnect",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			reasons := make(chan DisconnectReason, 10)
+			server.onDisconnect = func(app *ApplicationData, reason DisconnectReason) {
+				reasons <- reason
+			}
+
+			connect := func() *websocket.Conn {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+				err = conn.WriteJSON(testAppData[0])
+				assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+				return conn
+			}
+
+			expectReason := func(expected DisconnectReason) {
+				select {
+				case reason := <-reasons:
+					assert.Equal(t, expected, reason, "Disconnect reason does not match")
+				case <-time.After(time.Second):
+					t.Errorf("onDisconnect should have been called with %q", expected)
+				}
+			}
+
+			// Application requests to be disconnected and receives acknowledgment first
+			conn := connect()
+			defer conn.Close()
+			request := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Disconnect",
+			}
+			response, serverErr, err := testXSWDCall(t, conn, request)
+			assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+			assert.Nil(t, serverErr, "Response should not have error: %v", serverErr)
+			assert.Equal(t, true, response.Result, "Disconnect should be acknowledged")
+			_, _, err = conn.ReadMessage()
+			assert.Error(t, err, "Connection should be closed after Disconnect")
+			expectReason(DisconnectRequested)
+			assert.Len(t, server.GetApplications(), 0, "There should be no applications")
+
+			// Application closes its connection
+			conn2 := connect()
+			err = conn2.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			assert.NoErrorf(t, err, "Application failed to write close message: %s", err)
+			conn2.Close()
+			expectReason(DisconnectClosed)
+
+			// Application going away is a normal close too
+			conn2 = connect()
+			err = conn2.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(time.Second))
+			assert.NoErrorf(t, err, "Application failed to write close message: %s", err)
+			conn2.Close()
+			expectReason(DisconnectClosed)
+
+			// Application drops its connection without close message
+			conn2 = connect()
+			conn2.Close()
+			expectReason(DisconnectAbnormal)
+
+			// Application closes with a protocol error
+			conn2 = connect()
+			err = conn2.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseProtocolError, ""), time.Now().Add(time.Second))
+			assert.NoErrorf(t, err, "Application failed to write close message: %s", err)
+			conn2.Close()
+			expectReason(DisconnectAbnormal)
+
+			// Wallet removes the application
+			conn3 := connect()
+			defer conn3.Close()
+			apps := server.GetApplications()
+			assert.Len(t, apps, 1, "There should be one application")
+			server.RemoveApplication(&apps[0])
+			expectReason(DisconnectRemoved)
+
+			// Each disconnection is only reported once
+			select {
+			case reason := <-reasons:
+				t.Errorf("onDisconnect should only be called once per application: %s", reason)
+			case <-time.After(sleep50):
+			}
+		},
+	},
+	// Test applications Url are restricted to allowed schemes
+	{
+		name:       "URLSchemes",
+		permission: Allow,
+		options:    []Option{WithURLSchemes("https", "dapp")},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			tests := []struct {
+				url      string
+				accepted bool
+			}{
+				{"http://testapp0.com", false},
+				{"https://testapp0.com", true},
+				{"dapp://testapp0", true},
+				{"ftp://testapp0.com", false},
+			}
+
+			for i, test := range tests {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application %d failed to dial server: %s", i, err)
+
+				app := testAppData[0]
+				app.Url = test.url
+				err = conn.WriteJSON(app)
+				assert.NoErrorf(t, err, "Application %d failed to write data to server: %s", i, err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.Equal(t, test.accepted, authResponse.Accepted, "Application %d with url %q accepted does not match: %s", i, test.url, authResponse.Message)
+
+				conn.Close()
+				testWaitApplications(t, server, 0)
+			}
+
+			assert.Len(t, server.GetApplications(), 0, "There should be no applications")
+		},
+	},
+	// Test applications can't use the Url of another application when enabled
+	{
+		name:       "UniqueURL",
+		permission: Allow,
+		options:    []Option{WithUniqueURL(true)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			// Different ID with same Url is rejected
+			conn2, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn2.Close()
+
+			app := testAppData[2]
+			app.Url = testAppData[0].Url
+			err = conn2.WriteJSON(app)
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse = testHandleAuthResponse(t, conn2)
+			assert.False(t, authResponse.Accepted, "Application should not be accepted and is")
+			assert.Contains(t, authResponse.Message, "URL already in use by another application", "Rejection message does not match")
+
+			// Different ID with different Url is accepted
+			conn3, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn3.Close()
+
+			err = conn3.WriteJSON(testAppData[2])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse = testHandleAuthResponse(t, conn3)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not: %s", authResponse.Message)
+
+			// Url can't be updated to the one of another application
+			request := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "UpdateMetadata",
+				Params:  UpdateMetadata_Params{Url: testAppData[0].Url},
+			}
+			_, serverErr, err := testXSWDCall(t, conn3, request)
+			assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+			assert.Error(t, serverErr, "Response should have error")
+			assert.Len(t, server.GetApplications(), 2, "There should be two applications")
+		},
+	},
+	// Test method handlers are cancelled when running too long
+	{
+		name:       "HandlerTimeout",
+		permission: Allow,
+		options:    []Option{WithHandlerTimeout(100*time.Millisecond, "Hang")},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.Zero(t, server.handlerTimeoutOf("GetAddress"), "Wallet method should not be timed out")
+			assert.Zero(t, server.handlerTimeoutOf("transfer"), "Transfer method should not be timed out")
+			assert.Zero(t, server.handlerTimeoutOf("BuildSignedTransfer"), "xswd method should not be timed out")
+
+			// Buggy handler ignoring its context
+			release := make(chan struct{})
+			defer close(release)
+			server.SetCustomMethod("Hang", handler.New(func(ctx context.Context) bool {
+				<-release
+				return true
+			}))
+			server.SetCustomMethod("Custom", handler.New(func(ctx context.Context) bool { return true }))
+			assert.Equal(t, DefaultHandlerTimeout, server.handlerTimeoutOf("Custom"), "Default timeout should be used for custom method")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			request := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Hang",
+			}
+			_, serverErr, err := testXSWDCall(t, conn, request)
+			assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+			if assert.Error(t, serverErr, "Response should have error") {
+				assert.Equal(t, code.DeadlineExceeded, serverErr.Code, "Response should be %v: %v", code.DeadlineExceeded, serverErr.Code)
+			}
+
+			// Next request is not blocked by the hanging handler
+			request = jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      2,
+				Method:  "HasMethod",
+				Params:  HasMethod_Params{Name: "Hang"},
+			}
+			response, serverErr, err := testXSWDCall(t, conn, request)
+			assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+			assert.Nil(t, serverErr, "Response should not have error: %v", serverErr)
+			assert.Equal(t, true, response.Result, "Hang method should exist")
+		},
+	},
+	// Test a zero handler timeout disables it
+	{
+		name:       "HandlerTimeoutDisabled",
+		permission: Allow,
+		options:    []Option{WithHandlerTimeout(0)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			server.SetCustomMethod("Slow", handler.New(func(ctx context.Context) bool {
+				time.Sleep(50 * time.Millisecond)
+				return ctx.Err() == nil
+			}))
+			assert.Zero(t, server.handlerTimeoutOf("Slow"), "Custom method should not be timed out")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			request := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Slow",
+			}
+			response, serverErr, err := testXSWDCall(t, conn, request)
+			assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+			assert.Nil(t, serverErr, "Response should not have error: %v", serverErr)
+			assert.Equal(t, true, response.Result, "Slow method context should not be done")
+		},
+	},
+	// Test applications can't subscribe to more events than allowed
+	{
+		name:       "MaxSubscriptions",
+		permission: Allow,
+		options:    []Option{WithMaxSubscriptions(2)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			tests := []struct {
+				method string
+				event  rpc.EventType
+				valid  bool
+			}{
+				{"Subscribe", rpc.NewBalance, true},
+				{"Subscribe", rpc.NewTopoheight, true},
+				{"Subscribe", rpc.NewEntry, false},
+				{"Unsubscribe", rpc.NewBalance, true},
+				{"Subscribe", rpc.NewEntry, true},
+			}
+
+			for i, test := range tests {
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      i,
+					Method:  test.method,
+					Params:  Subscribe_Params{Event: test.event},
+				}
+				response, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %d %q should not error: %s", i, request.Method, err)
+				if test.valid {
+					assert.Nil(t, serverErr, "Response %d should not have error: %v", i, serverErr)
+					assert.Equal(t, true, response.Result, "Response %d %s %s should be true", i, test.method, test.event)
+				} else {
+					assert.Error(t, serverErr, "Response %d should have error", i)
+				}
+			}
+
+			apps := server.GetApplications()
+			if assert.Len(t, apps, 1, "There should be one application") {
+				assert.Len(t, apps[0].RegisteredEvents, 2, "Application should have two subscriptions")
+			}
+		},
+	},
+	// Test applications count and capacity
+	{
+		name:       "MaxApplications",
+		permission: Allow,
+		options:    []Option{WithMaxApplications(2)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.Equal(t, 2, server.MaxApplications(), "Max applications does not match")
+			assert.Equal(t, 0, server.ApplicationCount(), "There should be no applications")
+
+			for i, app := range []ApplicationData{testAppData[0], testAppData[2], testAppData[5]} {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application %d failed to dial server: %s", i, err)
+				defer conn.Close()
+
+				err = conn.WriteJSON(app)
+				assert.NoErrorf(t, err, "Application %d failed to write data to server: %s", i, err)
+				authResponse := testHandleAuthResponse(t, conn)
+				if i < 2 {
+					assert.True(t, authResponse.Accepted, "Application %d should be accepted and is not: %s", i, authResponse.Message)
+				} else {
+					assert.False(t, authResponse.Accepted, "Application %d should not be accepted over max", i)
+				}
+			}
+
+			assert.Equal(t, 2, server.ApplicationCount(), "There should be two applications")
+			assert.Len(t, server.GetApplications(), server.ApplicationCount(), "Count should match applications")
+		},
+	},
+	// Test max applications is checked again once the user accepted the application
+	{
+		name:       "MaxApplicationsAccepted",
+		permission: Allow,
+		options:    []Option{WithMaxApplications(1)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			// another session is added while the user is asked
+			other := &Connection{closed: make(chan struct{})}
+			other.ctx, other.cancel = context.WithCancel(context.Background())
+			close(other.closed)
+			server.appHandler = func(app *ApplicationData) bool {
+				server.Lock()
+				server.applications[other] = testAppData[2]
+				server.Unlock()
+				return true
+			}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.False(t, authResponse.Accepted, "Application should not be accepted over max")
+			assert.Contains(t, authResponse.Message, "Maximum applications reached", "Message does not match")
+			assert.Equal(t, 1, server.ApplicationCount(), "There should be one application")
+		},
+	},
+	// Test setup requests right after connecting can use the grace burst decaying to the steady one
+	{
+		name:       "RateLimitGrace",
+		permission: Allow,
+		options:    []Option{WithRateLimit(2, 4), WithRateLimitGrace(8, 400*time.Millisecond)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			period := server.gracePeriod
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			// burst of setup requests above the steady burst
+			for i := 0; i < 10; i++ {
+				err = conn.WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: i, Method: "Ping"})
+				assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+			}
+
+			for i := 0; i < 10; i++ {
+				var response struct {
+					Result string       `json:"result"`
+					Error  *jrpc2.Error `json:"error"`
+				}
+				err := conn.ReadJSON(&response)
+				assert.NoErrorf(t, err, "Read should not error: %s", err)
+				assert.Nil(t, response.Error, "Setup request %d should not exceed rate limit: %v", i, response.Error)
+			}
+
+			// steady burst applies once the grace period elapsed
+			time.Sleep(period + sleep50)
+			response, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 10, Method: "GetRateLimit"})
+			assert.NoErrorf(t, err, "GetRateLimit should not error: %s", err)
+			assert.Nil(t, serverErr, "GetRateLimit should not have error: %v", serverErr)
+			assert.Equal(t, float64(4), response.Result.(map[string]interface{})["burst"], "Burst should be back to the steady one")
+
+			for i := 0; i < 6; i++ {
+				err = conn.WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: i, Method: "Ping"})
+				assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+			}
+
+			exceeded := false
+			for !exceeded {
+				var response struct {
+					Error *jrpc2.Error `json:"error"`
+				}
+				if err := conn.ReadJSON(&response); err != nil {
+					t.Fatalf("Rate limit error should be read before closing: %s", err)
+				}
+
+				exceeded = response.Error != nil && response.Error.Code == RateLimitExceeded
+			}
+		},
+	},
+	// Test a switched wallet account is broadcasted and disconnects signed applications
+	{
+		name:       "AccountChanged",
+		permission: Allow,
+		options:    []Option{WithForceAsk(false), WithAccountChangeDisconnect(true)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.False(t, server.CheckAccount(), "Account should not be changed")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			signed, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer signed.Close()
+
+			err = signed.WriteJSON(testAppData[1])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse = testHandleAuthResponse(t, signed)
+			assert.True(t, authResponse.Accepted, "Signed application should be accepted and is not: %s", authResponse.Message)
+
+			subscribe := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Subscribe",
+				Params:  Subscribe_Params{Event: rpc.AccountChanged},
+			}
+			_, serverErr, err := testXSWDCall(t, conn, subscribe)
+			assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+			assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+
+			// host switches the account of the wallet
+			account, err := walletapi.Create_Encrypted_Wallet_Random_Memory("xswd")
+			assert.NoErrorf(t, err, "Random wallet should be created: %s", err)
+			previous := xswdWallet.Wallet_Memory
+			xswdWallet.Wallet_Memory = account
+			t.Cleanup(func() { xswdWallet.Wallet_Memory = previous })
+
+			// detected on the next request, before it is handled
+			err = conn.WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 2, Method: "GetAddress"})
+			assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, message, err := conn.ReadMessage()
+			assert.NoErrorf(t, err, "Read should not error: %s", err)
+
+			var event RPCResponse
+			err = json.Unmarshal(message, &event)
+			assert.NoErrorf(t, err, "Unmarshal event should not error: %s", err)
+			js, err := json.Marshal(event.Result)
+			assert.NoErrorf(t, err, "Marshal event should not error: %s", err)
+
+			var notification struct {
+				Event rpc.EventType `json:"event"`
+				Value AccountChange `json:"value"`
+			}
+			err = json.Unmarshal(js, &notification)
+			assert.NoErrorf(t, err, "Unmarshal notification should not error: %s", err)
+			assert.Equal(t, rpc.EventType(rpc.AccountChanged), notification.Event, "Event should be %s: %s", rpc.AccountChanged, notification.Event)
+			assert.Equal(t, account.IsRegistered(), notification.Value.Registered, "Registration does not match")
+			assert.NotContains(t, string(js), account.GetAddress().String(), "Event should not expose the new address")
+
+			var response struct {
+				Result rpc.GetAddress_Result `json:"result"`
+			}
+			err = conn.ReadJSON(&response)
+			assert.NoErrorf(t, err, "Read should not error: %s", err)
+			assert.Equal(t, account.GetAddress().String(), response.Result.Address, "Address should be the new account one")
+
+			// signed application was granted its permissions by the previous account
+			signed.SetReadDeadline(time.Now().Add(time.Second))
+			_, _, err = signed.ReadMessage()
+			assert.Error(t, err, "Signed application should be disconnected")
+			_, found := server.GetApplicationByID(testAppData[1].Id)
+			assert.False(t, found, "Signed application should be removed")
+			_, found = server.GetApplicationByID(testAppData[0].Id)
+			assert.True(t, found, "Unsigned application should stay connected")
+
+			assert.False(t, server.CheckAccount(), "Account change should only be reported once")
+		},
+	},
+	// Test transfers of an application are limited within a window even with AlwaysAllow
+	{
+		name:       "TransferLimit",
+		permission: AlwaysAllow,
+		options:    []Option{WithTransferLimit(2, 300*time.Millisecond)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			window := server.transferWindow
+
+			var mutex sync.Mutex
+			requested := 0
+			server.requestHandler = func(app *ApplicationData, request *jrpc2.Request) Permission {
+				mutex.Lock()
+				defer mutex.Unlock()
+				requested++
+				return AlwaysAllow
+			}
+
+			// transfers succeed unless sending 2 atomic units
+			transfer := handler.New(func(ctx context.Context, p rpc.Transfer_Params) (rpc.Transfer_Result, error) {
+				if len(p.Transfers) > 0 && p.Transfers[0].Amount == 2 {
+					return rpc.Transfer_Result{}, fmt.Errorf("transfer failed")
+				}
+
+				return rpc.Transfer_Result{TXID: "0000000000000000000000000000000000000000000000000000000000000001"}, nil
+			})
+			for _, method := range []string{"transfer", "BuildSignedTransfer"} {
+				server.SetCustomMethod(method, transfer)
+			}
+
+			connect := func() *websocket.Conn {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+
+				err = conn.WriteJSON(testAppData[0])
+				assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+				return conn
+			}
+
+			conn := connect()
+			defer conn.Close()
+
+			params := rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: testWalletData[0].Address, Amount: 1}}}
+			limited := func(conn *websocket.Conn, method string, params interface{}) bool {
+				_, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+				assert.NoErrorf(t, err, "Call %s should not error: %s", method, err)
+				if serverErr == nil || serverErr.Code != TransferLimitExceeded {
+					return false
+				}
+
+				var data TransferLimitExceeded_Data
+				err = json.Unmarshal(serverErr.Data, &data)
+				assert.NoErrorf(t, err, "Error data should unmarshal: %s", err)
+				assert.Equal(t, 2, data.Limit, "Transfer limit does not match")
+				assert.Equal(t, window.Seconds(), data.Window, "Transfer window does not match")
+				assert.True(t, data.RetryAfter > 0 && data.RetryAfter <= window.Seconds(), "Retry after should be within the window: %f", data.RetryAfter)
+
+				return true
+			}
+
+			failed := rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: testWalletData[0].Address, Amount: 2}}}
+			assert.False(t, limited(conn, "transfer", failed), "Failed transfer should not be limited")
+			assert.False(t, limited(conn, "transfer", params), "First transfer should not be limited as failed one is not counted")
+			assert.False(t, limited(conn, "BuildSignedTransfer", params), "Second transfer should not be limited")
+			assert.True(t, limited(conn, "transfer", params), "Third transfer should be limited")
+			assert.True(t, limited(conn, "BuildSignedTransfer", params), "Signed transfer should be limited")
+
+			mutex.Lock()
+			assert.Equal(t, 2, requested, "Limited transfer should not ask the user")
+			mutex.Unlock()
+
+			assert.False(t, limited(conn, "GetAddress", nil), "Non transfer method should not be limited")
+
+			app, _ := server.GetApplicationByID(testAppData[0].Id)
+			assert.Equal(t, 2, app.Transfers, "Snapshot transfers count does not match")
+
+			// reconnecting doesn't reset the limit
+			conn.Close()
+			testWaitApplications(t, server, 0)
+			conn2 := connect()
+			defer conn2.Close()
+			assert.True(t, limited(conn2, "transfer", params), "Transfer should be limited once reconnected")
+
+			time.Sleep(window)
+			assert.Equal(t, 0, server.GetApplications()[0].Transfers, "Snapshot transfers should be reset after the window")
+			assert.False(t, limited(conn2, "transfer", params), "Transfer should be available after the window")
+		},
+	},
+	// Test accepted applications receive the protocol version and capabilities with their authorization
+	{
+		name:       "AuthorizationCapabilities",
+		permission: Allow,
+		options:    []Option{WithSignDomain(true)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+			assert.Equal(t, ProtocolVersion, authResponse.Version, "Version does not match")
+			assert.Equal(t, server.Capabilities(), authResponse.Capabilities, "Capabilities do not match")
+			assert.Contains(t, authResponse.Capabilities, CapabilitySignDomain, "Sign domain should be enabled")
+
+			// rejected applications only receive the message
+			server.appHandler = func(app *ApplicationData) bool {
+				return false
+			}
+
+			conn2, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn2.Close()
+
+			err = conn2.WriteJSON(testAppData[2])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			_, message, err := conn2.ReadMessage()
+			assert.NoErrorf(t, err, "Read should not error: %s", err)
+
+			var fields map[string]interface{}
+			err = json.Unmarshal(message, &fields)
+			assert.NoErrorf(t, err, "Unmarshal authorization response should not error: %s", err)
+			assert.Equal(t, false, fields["accepted"], "Application should not be accepted")
+			assert.NotContains(t, fields, "version", "Rejected application should not receive the version")
+			assert.NotContains(t, fields, "capabilities", "Rejected application should not receive capabilities")
+		},
+	},
+	// Test transfers above the confirmation amount always ask even with AlwaysAllow
+	{
+		name:       "TransferConfirmation",
+		permission: AlwaysAllow,
+		options:    []Option{WithTransferConfirmation(100000)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			var mutex sync.Mutex
+			requested := map[string]int{}
+			server.requestHandler = func(app *ApplicationData, request *jrpc2.Request) Permission {
+				mutex.Lock()
+				defer mutex.Unlock()
+				requested[request.Method()]++
+				return AlwaysAllow
+			}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			call := func(method string, params interface{}) int {
+				_, _, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method, Params: params})
+				assert.NoErrorf(t, err, "Call %s should not error: %s", method, err)
+
+				mutex.Lock()
+				defer mutex.Unlock()
+				return requested[method]
+			}
+
+			destination := testWalletData[0].Address
+			small := rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: destination, Amount: 60000}, {Destination: destination, Burn: 40000}}}
+			large := rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: destination, Amount: 60000}, {Destination: destination, Amount: 40001}}}
+			token := rpc.Transfer_Params{Transfers: []rpc.Transfer{{SCID: crypto.HashHexToHash("0000000000000000000000000000000000000000000000000000000000000001"), Destination: destination, Amount: 1000000}}}
+
+			assert.Equal(t, 1, call("transfer", small), "First transfer should ask")
+			assert.Equal(t, 1, call("transfer", small), "Transfer at confirmation amount should use AlwaysAllow")
+			assert.Equal(t, 2, call("transfer", large), "Transfer above confirmation amount should ask")
+			assert.Equal(t, 3, call("transfer", large), "Transfer above confirmation amount should ask each time")
+			assert.Equal(t, 3, call("transfer", token), "Token transfer should not be compared")
+
+			// threshold can't allow a transfer above the confirmation amount
+			assert.NoError(t, server.SetPermissionThreshold(testAppData[0].Id, "transfer", 1000000), "Transfer threshold should be set")
+			assert.Equal(t, 4, call("transfer", large), "Transfer above confirmation amount should ask below threshold")
+
+			assert.Equal(t, 1, call("GetAddress", nil), "First GetAddress should ask")
+			assert.Equal(t, 1, call("GetAddress", nil), "Non transfer method should not be affected")
+
+			assert.Equal(t, 1, call("BuildSignedTransfer", large), "First signed transfer should ask")
+			assert.Equal(t, 2, call("BuildSignedTransfer", large), "Signed transfer above confirmation amount should ask")
+		},
+	},
+	// Test a threshold above the confirmation amount can't allow a transfer without stored permission
+	{
+		name:       "TransferConfirmationThreshold",
+		permission: Allow,
+		options:    []Option{WithTransferConfirmation(100000)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			var mutex sync.Mutex
+			requested := 0
+			server.requestHandler = func(app *ApplicationData, request *jrpc2.Request) Permission {
+				mutex.Lock()
+				defer mutex.Unlock()
+				requested++
+				return Deny
+			}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			assert.NoError(t, server.SetPermissionThreshold(testAppData[0].Id, "transfer", 1000000), "Transfer threshold should be set")
+
+			call := func(params rpc.Transfer_Params) *jrpc2.Error {
+				_, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "transfer", Params: params})
+				assert.NoErrorf(t, err, "Call transfer should not error: %s", err)
+				return serverErr
+			}
+
+			destination := testWalletData[0].Address
+			large := rpc.Transfer_Params{Transfers: []rpc.Transfer{{Destination: destination, Amount: 100001}}}
+			serverErr := call(large)
+			if assert.Error(t, serverErr, "Transfer above confirmation amount should not be allowed by threshold") {
+				assert.Equal(t, PermissionDenied, serverErr.Code, "Response should be %v: %v", PermissionDenied, serverErr.Code)
+			}
+			mutex.Lock()
+			assert.Equal(t, 1, requested, "Transfer above confirmation amount should ask")
+			mutex.Unlock()
+
+			app, found := server.GetApplicationByID(testAppData[0].Id)
+			assert.True(t, found, "Application should be found")
+			assert.NotContains(t, app.Permissions, "transfer", "Transfer permission should not be stored")
+		},
+	},
+	// Test changing the daemon endpoint waits for daemon calls in flight and restores the previous daemon on failure
+	{
+		name:       "SetDaemonEndpoint",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			endpoint, active := walletapi.Daemon_Endpoint, walletapi.Daemon_Endpoint_Active
+			t.Cleanup(func() {
+				walletapi.Daemon_Endpoint, walletapi.Daemon_Endpoint_Active = endpoint, active
+			})
+			previous := *walletapi.GetRPCClient()
+
+			entered := make(chan struct{})
+			release := make(chan struct{})
+			daemon := testDaemon{jrpc2server.NewLocal(handler.Map{
+				"DERO.Ping": handler.New(func(ctx context.Context) string {
+					close(entered)
+					<-release
+					return "Pong "
+				}),
+			}, nil)}
+			t.Cleanup(func() { daemon.Close() })
+			server.daemon = daemon
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			subscribe := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Subscribe",
+				Params:  Subscribe_Params{Event: rpc.DaemonChanged},
+			}
+			_, serverErr, err := testXSWDCall(t, conn, subscribe)
+			assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+			assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+
+			err = conn.WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 2, Method: "DERO.Ping"})
+			assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+
+			select {
+			case <-entered:
+			case <-time.After(time.Second):
+				t.Fatal("Daemon call should be in flight")
+			}
+
+			// nothing listens on this endpoint
+			newEndpoint := "127.0.0.1:1"
+			changed := make(chan error, 1)
+			go func() {
+				changed <- server.SetDaemonEndpoint(newEndpoint)
+			}()
+
+			select {
+			case <-changed:
+				t.Fatal("Daemon endpoint should not change while a daemon call is in flight")
+			case <-time.After(sleep50):
+			}
+
+			close(release)
+
+			// call in flight completes on the previous daemon
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			var response struct {
+				ID     int              `json:"id"`
+				Result string           `json:"result"`
+				Error  *json.RawMessage `json:"error"`
+			}
+			err = conn.ReadJSON(&response)
+			assert.NoErrorf(t, err, "Read should not error: %s", err)
+			assert.Nil(t, response.Error, "Daemon call in flight should not have error")
+			assert.Equal(t, "Pong ", response.Result, "Daemon call in flight result does not match")
+
+			select {
+			case err := <-changed:
+				assert.Error(t, err, "Connecting to an unreachable daemon should error")
+			case <-time.After(5 * time.Second):
+				t.Fatal("Daemon endpoint should be changed once the call completed")
+			}
+
+			assert.Equal(t, endpoint, walletapi.Daemon_Endpoint, "Daemon endpoint should be restored")
+			assert.Equal(t, active, walletapi.Daemon_Endpoint_Active, "Active daemon endpoint should be restored")
+			assert.Equal(t, previous, *walletapi.GetRPCClient(), "Daemon client should be restored")
+
+			// daemon did not change
+			conn.SetReadDeadline(time.Now().Add(sleep50))
+			_, _, err = conn.ReadMessage()
+			assert.Error(t, err, "DaemonChanged should not be broadcasted when the connection fails")
+		},
+	},
+	// Test application signing its permissions manifest can't have its permissions tampered with
+	{
+		name:       "PermissionsManifest",
+		permission: Allow,
+		options:    []Option{WithForceAsk(false)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			// manifest is "GetAddress=3\nGetHeight=4\n"
+			permissions := map[string]Permission{"GetAddress": AlwaysAllow, "GetHeight": AlwaysDeny}
+			hash := PermissionsHash(permissions)
+			assert.Equal(t, "42d0968ecd4d6f2eff9f62cdaec1063ab5d60698ab83d1c1cd8798679ce00c47", hash, "Permissions hash does not match")
+
+			assert.Contains(t, server.Capabilities(), CapabilityManifest, "Manifest should be supported")
+
+			tampered := map[string]Permission{"GetAddress": AlwaysAllow, "GetHeight": AlwaysAllow}
+
+			tests := []struct {
+				name        string
+				permissions map[string]Permission
+				message     string
+				accepted    bool
+				expected    string
+			}{
+				{"ID", permissions, testAppData[1].Id, true, ""},
+				{"Manifest", permissions, testAppData[1].Id + hash, true, ""},
+				{"UpperCase", permissions, testAppData[1].Id + strings.ToUpper(hash), true, ""},
+				{"Tampered", tampered, testAppData[1].Id + hash, false, "Signed permissions do not match requested permissions"},
+				{"Truncated", permissions, testAppData[1].Id + hash[:32], false, "Signature does not match ID"},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					conn, err := testCreateClient(nil)
+					assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+					defer conn.Close()
+
+					app := testAppData[1]
+					app.Permissions = test.permissions
+					app.Signature = xswdWallet.SignData([]byte(test.message))
+					err = conn.WriteJSON(app)
+					assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+					authResponse := testHandleAuthResponse(t, conn)
+					assert.Equal(t, test.accepted, authResponse.Accepted, "Application accepted does not match: %s", authResponse.Message)
+					if !test.accepted {
+						assert.Contains(t, authResponse.Message, test.expected, "Authorization message does not match")
+						return
+					}
+
+					stored, found := server.GetApplicationByID(app.Id)
+					assert.True(t, found, "Application should be found")
+					assert.Equal(t, AlwaysAllow, stored.Permissions["GetAddress"], "Signed permission should be applied")
+					server.RemoveApplication(&stored)
+				})
+			}
+		},
+	},
+	// Test AlwaysAllow of methods with a max age is requested again once expired
+	{
+		name:       "PermissionMaxAge",
+		permission: AlwaysAllow,
+		options:    []Option{WithPermissionMaxAge(200*time.Millisecond, "GetAddress", "GetHeight"), WithPermissionMaxAge(0, "GetHeight")},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			maxAge := 200 * time.Millisecond
+
+			assert.Equal(t, map[string]time.Duration{"GetAddress": maxAge}, server.permissionMaxAge, "Max age of 0 should remove it")
+
+			var mutex sync.Mutex
+			requested := map[string]int{}
+			server.requestHandler = func(app *ApplicationData, request *jrpc2.Request) Permission {
+				mutex.Lock()
+				defer mutex.Unlock()
+				requested[request.Method()]++
+				return AlwaysAllow
+			}
+
+			count := func(method string) int {
+				mutex.Lock()
+				defer mutex.Unlock()
+				return requested[method]
+			}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			call := func(method string) {
+				_, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method})
+				assert.NoErrorf(t, err, "%s should not error: %s", method, err)
+				assert.Nil(t, serverErr, "%s should not return an error", method)
+			}
+
+			for _, method := range []string{"GetAddress", "GetHeight"} {
+				call(method)
+				call(method)
+				assert.Equal(t, 1, count(method), "%s AlwaysAllow should be requested once", method)
+			}
+
+			time.Sleep(maxAge)
+
+			call("GetAddress")
+			call("GetAddress")
+			assert.Equal(t, 2, count("GetAddress"), "Expired GetAddress AlwaysAllow should be requested again once")
+
+			call("GetHeight")
+			assert.Equal(t, 1, count("GetHeight"), "GetHeight AlwaysAllow without max age should not expire")
+		},
+	},
+	// Test connected applications are found by filter, stored permission or subscribed event
+	{
+		name:       "FindApplications",
+		permission: Allow,
+		options:    []Option{WithForceAsk(false)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			var conns []*websocket.Conn
+			for i, app := range []ApplicationData{testAppData[0], testAppData[1]} {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application %d failed to dial server: %s", i, err)
+				defer conn.Close()
+
+				err = conn.WriteJSON(app)
+				assert.NoErrorf(t, err, "Application %d failed to write data to server: %s", i, err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.True(t, authResponse.Accepted, "Application %d should be accepted and is not: %s", i, authResponse.Message)
+				conns = append(conns, conn)
+			}
+
+			_, serverErr, err := testXSWDCall(t, conns[0], jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Subscribe",
+				Params:  Subscribe_Params{Event: rpc.NewEntry},
+			})
+			assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+			assert.Nil(t, serverErr, "Subscribe should not return an error")
+
+			ids := func(apps []ApplicationData) (ids []string) {
+				for _, app := range apps {
+					ids = append(ids, app.Id)
+				}
+				return
+			}
+
+			assert.Len(t, server.GetApplications(), 2, "There should be two applications")
+			assert.Equal(t, []string{testAppData[1].Id}, ids(server.FindApplications(func(app ApplicationData) bool {
+				return app.Name == testAppData[1].Name
+			})), "Application should be found by name")
+			assert.Empty(t, server.FindApplications(func(app ApplicationData) bool { return false }), "No application should match")
+
+			assert.Equal(t, []string{testAppData[1].Id}, ids(server.AppsWithPermission("GetAddress", AlwaysAllow)), "Application with GetAddress AlwaysAllow should be found")
+			assert.Equal(t, []string{testAppData[1].Id}, ids(server.AppsWithPermission("GetHeight", AlwaysDeny)), "Application with GetHeight AlwaysDeny should be found")
+			assert.Empty(t, server.AppsWithPermission("GetHeight", AlwaysAllow), "No application should have GetHeight AlwaysAllow")
+
+			assert.Equal(t, []string{testAppData[0].Id}, ids(server.AppsSubscribedTo(rpc.NewEntry)), "Subscribed application should be found")
+			assert.Empty(t, server.AppsSubscribedTo(rpc.NewBalance), "No application should be subscribed to NewBalance")
+		},
+	},
+	// Test application Url can't point to the XSWD server or present a remote application as local
+	{
+		name:       "ServerURL",
+		permission: Allow,
+		options:    []Option{WithOriginPolicy(OriginIgnore)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			check := func(app_url, origin string) string {
+				app := ApplicationData{Name: "App", Description: "Description", Url: app_url, origin: origin}
+				_, response := server.checkMetadata(&app)
+				return response
+			}
+
+			for _, tt := range []struct {
+				url   string
+				valid bool
+			}{
+				{"http://127.0.0.1:44326", false},
+				{"ws://localhost:44326/xswd", false},
+				{"http://[::1]:44326", false},
+				{"http://0.0.0.0:44326", false},
+				{"http://127.0.0.1:8080", true},
+				{"http://testapp.com:44326", true},
+				{"http://testapp.com", true},
+			} {
+				assert.Equal(t, tt.valid, check(tt.url, "") == "", "Url %q validity does not match", tt.url)
+			}
+
+			// Server listening on all interfaces is reached through any of its addresses
+			server.host = ""
+			assert.NotEmpty(t, check("http://127.0.0.1:44326", ""), "Loopback Url of server should be invalid")
+			server.host = DefaultHost
+
+			// Local Url from a remote origin is only rejected when enabled
+			assert.Empty(t, check("http://localhost:3000", "http://remote.com"), "Local Url should be valid when not checked")
+			server.localURL = true
+			for _, tt := range []struct {
+				url    string
+				origin string
+				valid  bool
+			}{
+				{"http://localhost:3000", "http://remote.com", false},
+				{"http://127.0.0.1:3000", "https://remote.com", false},
+				{"http://localhost:3000", "http://localhost:3000", true},
+				{"http://localhost:3000", "", true},
+				{"http://remote.com", "http://remote.com", true},
+			} {
+				assert.Equal(t, tt.valid, check(tt.url, tt.origin) == "", "Url %q from origin %q validity does not match", tt.url, tt.origin)
+			}
+			server.localURL = false
+
+			// Application is rejected when connecting with the server Url
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			app := testAppData[0]
+			app.Url = "http://localhost:44326"
+			err = conn.WriteJSON(app)
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.False(t, authResponse.Accepted, "Application with server Url should not be accepted")
+			assert.Contains(t, authResponse.Message, "points to XSWD server", "Rejection reason does not match")
+		},
+	},
+	// Test events are delivered to a connection in the order they were broadcasted
+	{
+		name:       "EventOrder",
+		permission: Allow,
+		options:    []Option{WithCustomEvents("counter")},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			_, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Subscribe",
+				Params:  Subscribe_Params{Event: "counter"},
+			})
+			assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+			assert.Nil(t, serverErr, "Subscribe should not return an error")
+
+			count := SendQueueSize / 2
+			for i := 0; i < count; i++ {
+				err = server.BroadcastCustomEvent("counter", i)
+				assert.NoErrorf(t, err, "Custom event should be broadcasted: %s", err)
+			}
+
+			for i := 0; i < count; i++ {
+				var event struct {
+					Result struct {
+						Event rpc.EventType `json:"event"`
+						Value int           `json:"value"`
+					} `json:"result"`
+				}
+				err = conn.ReadJSON(&event)
+				assert.NoErrorf(t, err, "Read should not error: %s", err)
+				assert.Equal(t, i, event.Result.Value, "Events should be received in broadcast order")
+			}
+		},
+	},
+	// Test daemon results are forwarded without losing integer precision
+	{
+		name:       "DaemonRawResult",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			daemon := testDaemon{jrpc2server.NewLocal(handler.Map{
+				"DERO.GetInfo": handler.New(func(ctx context.Context) rpc.GetInfo_Result {
+					return rpc.GetInfo_Result{Network: "Mainnet", Difficulty: math.MaxUint64}
+				}),
+			}, nil)}
+			t.Cleanup(func() { daemon.Close() })
+			server.daemon = daemon
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			err = conn.WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "DERO.GetInfo"})
+			assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+
+			var response struct {
+				ID     json.RawMessage    `json:"id"`
+				Result rpc.GetInfo_Result `json:"result"`
+			}
+			err = conn.ReadJSON(&response)
+			assert.NoErrorf(t, err, "DERO.GetInfo response should unmarshal: %s", err)
+			assert.Equal(t, "1", string(response.ID), "Response ID does not match")
+			assert.Equal(t, uint64(math.MaxUint64), response.Result.Difficulty, "Difficulty should keep its exact value")
+			assert.Equal(t, "Mainnet", response.Result.Network, "Network does not match")
+		},
+	},
+	// Test daemon requests use the injected daemon client
+	{
+		name:       "DaemonClient",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			server.daemon = testNewDaemon(t)
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			call := func(method string, result interface{}) *jrpc2.Error {
+				response, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: method})
+				assert.NoErrorf(t, err, "Call %s should not error: %s", method, err)
+				if serverErr == nil {
+					js, err := json.Marshal(response.Result)
+					assert.NoErrorf(t, err, "%s result marshal should not error: %s", method, err)
+					err = json.Unmarshal(js, result)
+					assert.NoErrorf(t, err, "%s result unmarshal should not error: %s", method, err)
+				}
+
+				return serverErr
+			}
+
+			var pong string
+			assert.Nil(t, call("DERO.Ping", &pong), "DERO.Ping should not have error")
+			assert.Equal(t, "Pong ", pong, "DERO.Ping result does not match")
+
+			var info rpc.GetInfo_Result
+			assert.Nil(t, call("DERO.GetInfo", &info), "DERO.GetInfo should not have error")
+			assert.Equal(t, int64(100), info.Height, "DERO.GetInfo height does not match")
+
+			var daemon GetDaemon_Result
+			assert.Nil(t, call("GetDaemon", &daemon), "GetDaemon should not have error")
+			assert.Equal(t, "Mainnet", daemon.Network, "GetDaemon network does not match")
+			assert.Equal(t, uint64(100), daemon.Height, "GetDaemon height does not match")
+
+			var status GetDaemonStatus_Result
+			assert.Nil(t, call("GetDaemonStatus", &status), "GetDaemonStatus should not have error")
+			assert.True(t, status.Online, "Fake daemon should be online")
+			assert.Equal(t, daemon.Endpoint, status.Endpoint, "GetDaemonStatus endpoint should match GetDaemon")
+
+			serverErr := call("DERO.Unknown", nil)
+			if assert.NotNil(t, serverErr, "Unknown daemon method should have error") {
+				assert.Equal(t, code.MethodNotFound, serverErr.Code, "Daemon error code should be kept")
+			}
+		},
+	},
+	// Test a session not sending its application data in time is closed
+	{
+		name:       "HandshakeTimeout",
+		permission: Allow,
+		options:    []Option{WithHandshakeTimeout(sleep50 * 2)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.False(t, authResponse.Accepted, "Application should not be accepted")
+			assert.Equal(t, "Timed out waiting for application data", authResponse.Message, "Timeout message does not match")
+
+			_, _, err = conn.ReadMessage()
+			assert.Error(t, err, "Connection should be closed after timeout")
+
+			// deadline is cleared once the application data is read
+			conn2, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn2.Close()
+
+			err = conn2.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse = testHandleAuthResponse(t, conn2)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			time.Sleep(sleep50 * 3)
+			_, serverErr, err := testXSWDCall(t, conn2, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "GetAddress"})
+			assert.NoErrorf(t, err, "GetAddress should not error after the handshake timeout: %s", err)
+			assert.Nil(t, serverErr, "GetAddress should not have error: %v", serverErr)
+		},
+	},
+	// Test rate limit error tells the application its limit before closing
+	{
+		name:       "RateLimitExceededData",
+		permission: Allow,
+		options:    []Option{WithRateLimit(2, 4)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			for i := 0; i < 5; i++ {
+				err = conn.WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: i, Method: "GetAddress"})
+				assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+			}
+
+			var serverErr *jrpc2.Error
+			for serverErr == nil {
+				var response struct {
+					Error *jrpc2.Error `json:"error"`
+				}
+				if err := conn.ReadJSON(&response); err != nil {
+					t.Fatalf("Rate limit error should be read before closing: %s", err)
+				}
+
+				if response.Error != nil && response.Error.Code == RateLimitExceeded {
+					serverErr = response.Error
+				}
+			}
+
+			var data RateLimitExceeded_Data
+			err = json.Unmarshal(serverErr.Data, &data)
+			assert.NoErrorf(t, err, "Unmarshal rate limit data should not error: %s", err)
+			assert.Equal(t, RateLimitExceeded_Data{Limit: 2, Burst: 4, Window: 2}, data, "Rate limit data does not match")
+		},
+	},
+	// Test requests are queued while the server is busy and stopping doesn't block sessions
+	{
+		name:       "QueueSize",
+		permission: Allow,
+		options:    []Option{WithMaxConcurrentRequests(1), WithQueueSize(4)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.Equal(t, 4, cap(server.requests), "Requests queue size does not match")
+			assert.Equal(t, 4, cap(server.registers), "Registers queue size does not match")
+
+			release := make(chan struct{})
+			defer close(release)
+			server.SetCustomMethod("Block", handler.New(func(ctx context.Context) bool {
+				<-release
+				return true
+			}))
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			// first request holds the worker, the others wait on it apart from the queue
+			for i := 1; i <= 4; i++ {
+				err = conn.WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: i, Method: "Block"})
+				assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+			}
+			assert.Eventually(t, func() bool { return len(server.workers) == 1 }, time.Second, sleep10, "One request should be handled")
+			assert.Len(t, server.requests, 0, "Requests waiting on the worker should not hold the queue")
+
+			stopped := make(chan struct{})
+			go func() {
+				server.Stop()
+				close(stopped)
+			}()
+
+			select {
+			case <-stopped:
+			case <-time.After(sleep500):
+				t.Fatal("Stop should not block on queued requests")
+			}
+		},
+	},
+	// Test AuthorizationResponse messages can be replaced by the host
+	{
+		name:       "AuthorizationMessages",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			server.authorizationMessage = func(reason AuthorizationReason, step ValidationStep, message string) string {
+				switch reason {
+				case AuthorizationAccepted:
+					return "Application autorisée"
+				case AuthorizationInvalid:
+					return fmt.Sprintf("Données invalides: %s", step)
+				}
+
+				return message
+			}
+
+			connect := func(app ApplicationData) AuthorizationResponse {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+				t.Cleanup(func() { conn.Close() })
+
+				err = conn.WriteJSON(app)
+				assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+				return testHandleAuthResponse(t, conn)
+			}
+
+			authResponse := connect(testAppData[0])
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+			assert.Equal(t, "Application autorisée", authResponse.Message, "Accepted message should be replaced")
+
+			invalid := testAppData[1]
+			invalid.Name = ""
+			authResponse = connect(invalid)
+			assert.False(t, authResponse.Accepted, "Invalid application should not be accepted")
+			assert.Equal(t, "Données invalides: name", authResponse.Message, "Invalid message should be replaced with its step")
+
+			// reasons not handled by the provider keep the default message
+			authResponse = connect(testAppData[0])
+			assert.False(t, authResponse.Accepted, "Duplicate application should not be accepted")
+			assert.Equal(t, "App ID is already used", authResponse.Message, "Default message should be kept")
+		},
+	},
+	// Test identical requests in flight share the result of the first one
+	{
+		name:       "Coalescing",
+		permission: Allow,
+		options:    []Option{WithCoalescing("SlowQuery", "transfer", "SignData")},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.True(t, server.coalesce["SlowQuery"], "SlowQuery should be coalesced")
+			assert.False(t, server.coalesce["transfer"], "transfer should never be coalesced")
+			assert.False(t, server.coalesce["SignData"], "SignData should never be coalesced")
+
+			var mu sync.Mutex
+			calls := map[string]int{}
+			server.SetCustomMethodWithPolicy("SlowQuery", handler.New(func(ctx context.Context, p []string) (string, error) {
+				mu.Lock()
+				calls[strings.Join(p, ",")]++
+				mu.Unlock()
+				time.Sleep(sleep500)
+				return strings.Join(p, ","), nil
+			}), true)
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			// burst of identical requests and a different one
+			for i := 1; i <= 5; i++ {
+				err = conn.WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: i, Method: "SlowQuery", Params: []string{"a"}})
+				assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+			}
+			err = conn.WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 6, Method: "SlowQuery", Params: []string{"b"}})
+			assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+
+			results := map[string]string{}
+			for i := 0; i < 6; i++ {
+				var response RPCResponse
+				err = conn.ReadJSON(&response)
+				assert.NoErrorf(t, err, "Application failed to read response: %s", err)
+				assert.Nil(t, response.Error, "Response should not have error: %v", response.Error)
+				results[string(response.ID)], _ = response.Result.(string)
+			}
+
+			for i := 1; i <= 5; i++ {
+				assert.Equal(t, "a", results[fmt.Sprint(i)], "Coalesced response %d does not match", i)
+			}
+			assert.Equal(t, "b", results["6"], "Different params should not be coalesced")
+
+			mu.Lock()
+			assert.Equal(t, map[string]int{"a": 1, "b": 1}, calls, "Identical requests should be handled once")
+			mu.Unlock()
+
+			stats, _ := server.MethodStats(testAppData[0].Id)
+			assert.Equal(t, uint64(6), stats["SlowQuery"], "Coalesced requests should still be counted")
+
+			// once answered, the same request is handled again
+			_, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 7, Method: "SlowQuery", Params: []string{"a"}})
+			assert.NoErrorf(t, err, "SlowQuery should not error: %s", err)
+			assert.Nil(t, serverErr, "SlowQuery should not have error: %v", serverErr)
+			mu.Lock()
+			assert.Equal(t, 2, calls["a"], "Request should be handled again once the previous is answered")
+			mu.Unlock()
+		},
+	},
+	// Test custom events registered by the host can be subscribed to and broadcasted
+	{
+		name:       "CustomEvent",
+		permission: Allow,
+		options:    []Option{WithCustomEvents("settings_changed", rpc.NewBalance)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			subscribe := func(event rpc.EventType) *jrpc2.Error {
+				_, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  "Subscribe",
+					Params:  Subscribe_Params{Event: event},
+				})
+				assert.NoErrorf(t, err, "Subscribe %s should not error: %s", event, err)
+				return serverErr
+			}
+
+			assert.Nil(t, subscribe("settings_changed"), "Registered custom event should be subscribed")
+			assert.NotNil(t, subscribe("not_registered"), "Not registered custom event should not be subscribed")
+
+			assert.Error(t, server.BroadcastCustomEvent("not_registered", true), "Not registered custom event should not be broadcasted")
+			assert.Error(t, server.BroadcastCustomEvent(rpc.NewBalance, true), "Server event should not be broadcasted as a custom event")
+
+			err = server.BroadcastCustomEvent("settings_changed", map[string]string{"theme": "dark"})
+			assert.NoErrorf(t, err, "Custom event should be broadcasted: %s", err)
+
+			_, message, err := conn.ReadMessage()
+			assert.NoErrorf(t, err, "Read should not error: %s", err)
+
+			var event struct {
+				Result struct {
+					Event rpc.EventType     `json:"event"`
+					Value map[string]string `json:"value"`
+				} `json:"result"`
+			}
+			err = json.Unmarshal(message, &event)
+			assert.NoErrorf(t, err, "Unmarshal event should not error: %s", err)
+			assert.Equal(t, rpc.EventType("settings_changed"), event.Result.Event, "Event does not match")
+			assert.Equal(t, "dark", event.Result.Value["theme"], "Event value does not match")
+		},
+	},
+	// Test GetVersion is always allowed and reports capabilities
+	{
+		name:       "GetVersion",
+		permission: Deny,
+		options:    []Option{WithSignDomain(true)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.True(t, server.IsAlwaysAllowed("GetVersion"), "GetVersion should be always allowed")
+			assert.True(t, server.IsNoStore("GetVersion"), "GetVersion should be noStore")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			request := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "GetVersion",
+			}
+			response, serverErr, err := testXSWDCall(t, conn, request)
+			assert.NoErrorf(t, err, "GetVersion should not error: %s", err)
+			assert.Nil(t, serverErr, "GetVersion should not have error: %v", serverErr)
+
+			var result GetVersion_Result
+			js, err := json.Marshal(response.Result)
+			assert.NoErrorf(t, err, "GetVersion result marshal should not error: %s", err)
+			err = json.Unmarshal(js, &result)
+			assert.NoErrorf(t, err, "GetVersion result unmarshal should not error: %s", err)
+			assert.Equal(t, ProtocolVersion, result.Version, "Version does not match")
+			assert.Contains(t, result.Capabilities, CapabilityReplayLatest, "Replay should be supported")
+			assert.Contains(t, result.Capabilities, CapabilitySignDomain, "Sign domain should be enabled")
+			assert.NotContains(t, result.Capabilities, CapabilityMultiWallet, "Multi wallet should not be enabled")
+			assert.Equal(t, server.Capabilities(), result.Capabilities, "Capabilities do not match")
+		},
+	},
+	// Test application is warned once when its rate limit is near
+	{
+		name:       "RateLimitWarning",
+		permission: Allow,
+		options:    []Option{WithRateLimit(1, 10), WithRateLimitWarning(5)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			subscribe := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "Subscribe",
+				Params:  Subscribe_Params{Event: rpc.RateLimitWarning},
+			}
+			_, serverErr, err := testXSWDCall(t, conn, subscribe)
+			assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+			assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+
+			request := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "GetAddress",
+			}
+
+			var warnings []GetRateLimit_Result
+			for i := 0; i < 8; i++ {
+				err = conn.WriteJSON(request)
+				assert.NoErrorf(t, err, "Request %d should not error: %s", i, err)
+
+				// warning is queued before the response of the request
+				for {
+					_, message, err := conn.ReadMessage()
+					assert.NoErrorf(t, err, "Read %d should not error: %s", i, err)
+
+					var response RPCResponse
+					err = json.Unmarshal(message, &response)
+					assert.NoErrorf(t, err, "Unmarshal %d should not error: %s", i, err)
+					if response.ID != nil && string(response.ID) != "null" {
+						break
+					}
+
+					js, err := json.Marshal(response.Result)
+					assert.NoErrorf(t, err, "Marshal event should not error: %s", err)
+					var event struct {
+						Event rpc.EventType       `json:"event"`
+						Value GetRateLimit_Result `json:"value"`
+					}
+					err = json.Unmarshal(js, &event)
+					assert.NoErrorf(t, err, "Unmarshal event should not error: %s", err)
+					assert.Equal(t, rpc.EventType(rpc.RateLimitWarning), event.Event, "Event should be a rate limit warning")
+					warnings = append(warnings, event.Value)
+				}
+			}
+
+			if assert.Len(t, warnings, 1, "Application should be warned once") {
+				assert.Less(t, warnings[0].Tokens, float64(5), "Warning tokens should be below threshold")
+				assert.Equal(t, 10, warnings[0].Burst, "Warning burst does not match")
+			}
+			assert.True(t, server.HasApplicationId(testAppData[0].Id), "Application should still be connected")
+		},
+	},
+	// Test applications connected for too long are reaped
+	{
+		name:       "ReapOlderThan",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			var mu sync.Mutex
+			reasons := map[string]DisconnectReason{}
+			server.onDisconnect = func(app *ApplicationData, reason DisconnectReason) {
+				mu.Lock()
+				reasons[app.Id] = reason
+				mu.Unlock()
+			}
+
+			connect := func(app ApplicationData) *websocket.Conn {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+				err = conn.WriteJSON(app)
+				assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.True(t, authResponse.Accepted, "Application should be accepted and is not: %s", authResponse.Message)
+
+				return conn
+			}
+
+			old := connect(testAppData[0])
+			defer old.Close()
+			time.Sleep(sleep500)
+			recent := connect(testAppData[2])
+			defer recent.Close()
+
+			assert.Equal(t, 0, server.ReapOlderThan(time.Hour), "No application should be reaped")
+			assert.Equal(t, 1, server.ReapOlderThan(sleep500/2), "Oldest application should be reaped")
+			assert.False(t, server.HasApplicationId(testAppData[0].Id), "Oldest application should be removed")
+			assert.True(t, server.HasApplicationId(testAppData[2].Id), "Recent application should remain")
+
+			_, _, err := old.ReadMessage()
+			assert.Error(t, err, "Reaped application connection should be closed")
+
+			mu.Lock()
+			assert.Equal(t, DisconnectReaped, reasons[testAppData[0].Id], "Disconnect reason should be reaped")
+			mu.Unlock()
+		},
+	},
+	// Test the root handler response can be replaced
+	{
+		name:       "RootHandler",
+		permission: Allow,
+		options: []Option{WithRootHandler(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("Branded XSWD server"))
+		})},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			get := func() string {
+				u := url.URL{Scheme: "http", Host: "127.0.0.1:44326", Path: "/"}
+				resp, err := http.Get(u.String())
+				assert.NoErrorf(t, err, "Root request should not error: %s", err)
+				defer resp.Body.Close()
+
+				body, err := io.ReadAll(resp.Body)
+				assert.NoErrorf(t, err, "Read root response should not error: %s", err)
+
+				return string(body)
+			}
+
+			assert.Equal(t, "Branded XSWD server", get(), "Root response should be the option handler")
+
+			server.SetRootHandler(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write([]byte(`{"name":"XSWD"}`))
+			})
+			assert.Equal(t, `{"name":"XSWD"}`, get(), "Root response should be the handler set")
+
+			server.SetRootHandler(nil)
+			assert.Equal(t, "XSWD server", get(), "Root response should be the default one")
+		},
+	},
+	// Test permission changes are reported and permissions can be revoked
+	{
+		name:       "PermissionChange",
+		permission: AlwaysAllow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			type change struct {
+				id     string
+				method string
+				perm   Permission
+			}
+
+			var mu sync.Mutex
+			var changes []change
+			server.onPermissionChange = func(appID, method string, perm Permission) {
+				mu.Lock()
+				changes = append(changes, change{appID, method, perm})
+				mu.Unlock()
+			}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			id := testAppData[0].Id
+			call := func(method string) {
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  method,
+				}
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %q should not error: %s", method, err)
+				assert.Nil(t, serverErr, "Request %q should not have error: %v", method, serverErr)
+			}
+
+			call("GetAddress")
+			call("GetAddress") // already stored, not reported
+			assert.True(t, server.RevokePermission(id, "GetAddress"), "GetAddress permission should be revoked")
+			assert.False(t, server.RevokePermission(id, "GetAddress"), "GetAddress permission should already be revoked")
+			app, _ := server.GetApplicationByID(id)
+			_, found := app.Permissions["GetAddress"]
+			assert.False(t, found, "GetAddress permission should be removed")
+
+			call("GetHeight")
+			server.AddNoStore("GetHeight")
+
+			expected := []change{
+				{id, "GetAddress", AlwaysAllow},
+				{id, "GetAddress", Ask},
+				{id, "GetHeight", AlwaysAllow},
+				{id, "GetHeight", Ask},
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, expected, changes, "Permission changes do not match")
+		},
+	},
+	// Test requests handled concurrently are bounded
+	{
+		name:       "MaxConcurrentRequests",
+		permission: Allow,
+		options:    []Option{WithMaxConcurrentRequests(1)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			release := make(chan struct{})
+			server.SetCustomMethod("Block", handler.New(func(ctx context.Context) bool {
+				<-release
+				return true
+			}))
+
+			var conns []*websocket.Conn
+			for i, app := range []ApplicationData{testAppData[0], testAppData[2]} {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application %d failed to dial server: %s", i, err)
+				defer conn.Close()
+
+				err = conn.WriteJSON(app)
+				assert.NoErrorf(t, err, "Application %d failed to write data to server: %s", i, err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.True(t, authResponse.Accepted, "Application %d should be accepted and is not: %s", i, authResponse.Message)
+				conns = append(conns, conn)
+			}
+
+			var wg sync.WaitGroup
+			for i, method := range []string{"Block", "GetAddress"} {
+				wg.Add(1)
+				go func(i int, method string) {
+					defer wg.Done()
+					request := jsonrpc.RPCRequest{
+						JSONRPC: "2.0",
+						ID:      1,
+						Method:  method,
+					}
+					_, serverErr, err := testXSWDCall(t, conns[i], request)
+					assert.NoErrorf(t, err, "Request %q should not error: %s", method, err)
+					assert.Nil(t, serverErr, "Request %q should not have error: %v", method, serverErr)
+				}(i, method)
+				assert.Eventually(t, func() bool { return len(server.workers) == 1 }, time.Second, sleep10, "One request should be handled")
+			}
+
+			// Only one worker is used while second request is queued
+			assert.Len(t, server.workers, 1, "One request should be handled")
+			close(release)
+			wg.Wait()
+			assert.Eventually(t, func() bool { return len(server.workers) == 0 }, time.Second, sleep10, "No request should be handled")
+		},
+	},
+	// Test an application can't hold every worker with its requests in flight
+	{
+		name:       "MaxApplicationRequests",
+		permission: Allow,
+		options:    []Option{WithMaxConcurrentRequests(3), WithMaxApplicationRequests(2)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			release := make(chan struct{})
+			defer close(release)
+			server.SetCustomMethod("Block", handler.New(func(ctx context.Context) bool {
+				<-release
+				return true
+			}))
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			// first request is handled, second waits on the session and third is over the limit
+			for i := 1; i <= 2; i++ {
+				err = conn.WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: i, Method: "Block"})
+				assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+			}
+
+			_, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 3, Method: "Block"})
+			assert.NoErrorf(t, err, "Request should not error: %s", err)
+			if assert.Error(t, serverErr, "Request over the limit should have error") {
+				assert.Equal(t, code.Cancelled, serverErr.Code, "Response should be %v: %v", code.Cancelled, serverErr.Code)
+			}
+
+			// another application is still served and can connect
+			conn2, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn2.Close()
+
+			err = conn2.WriteJSON(testAppData[2])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse = testHandleAuthResponse(t, conn2)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not: %s", authResponse.Message)
+
+			_, serverErr, err = testXSWDCall(t, conn2, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "GetAddress"})
+			assert.NoErrorf(t, err, "Request should not error: %s", err)
+			assert.Nil(t, serverErr, "Request of another application should not have error: %v", serverErr)
+		},
+	},
+	// Test structured data of permission denied errors
+	{
+		name:       "PermissionDeniedData",
+		permission: Deny,
+		options:    []Option{WithForceAsk(false)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			// App 1 has GetHeight stored as AlwaysDeny
+			err = conn.WriteJSON(testAppData[1])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			tests := []struct {
+				method string
+				code   code.Code
+				data   PermissionDenied_Data
+			}{
+				{"GetHeight", PermissionAlwaysDenied, PermissionDenied_Data{Method: "GetHeight", Permission: AlwaysDeny.String(), Stored: true}},
+				{"GetBalance", PermissionDenied, PermissionDenied_Data{Method: "GetBalance", Permission: Deny.String(), Stored: false}},
+			}
+
+			for _, test := range tests {
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  test.method,
+				}
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+				if assert.Error(t, serverErr, "Request %q should be denied", request.Method) {
+					assert.Equal(t, test.code, serverErr.Code, "Request %q should be %v: %v", request.Method, test.code, serverErr.Code)
+
+					var data PermissionDenied_Data
+					err = json.Unmarshal(serverErr.Data, &data)
+					assert.NoErrorf(t, err, "Unmarshal error data of %q should not error: %s", request.Method, err)
+					assert.Equal(t, test.data, data, "Error data of %q does not match", request.Method)
+				}
+			}
+		},
+	},
+	// Test permissions template selected when accepting an application
+	{
+		name:       "Template",
+		permission: Deny,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			// User would reject any application without templateHandler
+			server.appHandler = func(app *ApplicationData) bool { return false }
+			template := "Explorer"
+			server.templateHandler = func(app *ApplicationData) (bool, string) { return true, template }
+
+			server.DefineTemplate("Explorer", map[string]Permission{
+				"GetAddress": AlwaysAllow,
+				"GetBalance": AlwaysDeny,
+				"GetHeight":  Allow,       // not stored
+				"GetDaemon":  AlwaysAllow, // noStore
+			})
+
+			tests := []struct {
+				template    string
+				permissions map[string]Permission
+			}{
+				{"Explorer", map[string]Permission{"GetAddress": AlwaysAllow, "GetBalance": AlwaysDeny}},
+				{"Unknown", map[string]Permission{}},
+				{"", map[string]Permission{}},
+			}
+
+			for i, test := range tests {
+				template = test.template
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application %d failed to dial server: %s", i, err)
+
+				err = conn.WriteJSON(testAppData[0])
+				assert.NoErrorf(t, err, "Application %d failed to write data to server: %s", i, err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.True(t, authResponse.Accepted, "Application %d should be accepted by template handler and is not", i)
+
+				app, found := server.GetApplicationByID(testAppData[0].Id)
+				assert.True(t, found, "Application %d should be found", i)
+				assert.Equal(t, test.permissions, app.Permissions, "Application %d permissions do not match template %q", i, test.template)
+
+				conn.Close()
+				testWaitApplications(t, server, 0)
+			}
+		},
+	},
+	// Test subscription management is not counted by the requests rate limit
+	{
+		name:       "RateLimitExempt",
+		permission: Allow,
+		options:    []Option{WithRateLimit(1, 2)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.True(t, server.IsRateLimitExempt("Subscribe"), "Subscribe should be exempt")
+			assert.False(t, server.IsRateLimitExempt("GetAddress"), "GetAddress should not be exempt")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			// Well over the burst without being disconnected
+			for i := 0; i < 10; i++ {
+				method := "Subscribe"
+				if i%2 == 1 {
+					method = "Unsubscribe"
+				}
+
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      i,
+					Method:  method,
+					Params:  Subscribe_Params{Event: rpc.NewTopoheight},
+				}
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %q %d should not error: %s", request.Method, i, err)
+				assert.Nil(t, serverErr, "Request %q %d should not have error: %v", request.Method, i, serverErr)
+			}
+
+			request := jsonrpc.RPCRequest{
+				JSONRPC: "2.0",
+				ID:      1,
+				Method:  "GetAddress",
+			}
+
+			exceeded := false
+			for i := 0; i < 3; i++ {
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %q %d should not error: %s", request.Method, i, err)
+				if serverErr != nil && serverErr.Code == RateLimitExceeded {
+					exceeded = true
+					break
+				}
+			}
+
+			assert.True(t, exceeded, "Expecting %q to have exceeded rate limit and did not", request.Method)
+		},
+	},
+	// Test subscription management is limited by the exempt rate limit
+	{
+		name:       "ExemptRateLimit",
+		permission: Allow,
+		options:    []Option{WithExemptRateLimit(1, 2)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			exceeded := false
+			for i := 0; i < 4; i++ {
+				method := "Subscribe"
+				if i%2 == 1 {
+					method = "Unsubscribe"
+				}
+
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      i,
+					Method:  method,
+					Params:  Subscribe_Params{Event: rpc.NewTopoheight},
+				}
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %q %d should not error: %s", request.Method, i, err)
+				if serverErr != nil && serverErr.Code == RateLimitExceeded {
+					exceeded = true
+					break
+				}
+			}
+
+			assert.True(t, exceeded, "Expecting subscription management to have exceeded exempt rate limit and did not")
+		},
+	},
+	// Test events caused by an application are not broadcasted back to it
+	{
+		name:       "ExcludeOrigin",
+		permission: Allow,
+		options:    []Option{WithExcludeOrigin(true)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			var conns []*websocket.Conn
+			for i, app := range []ApplicationData{testAppData[0], testAppData[2]} {
+				conn, err := testCreateClient(nil)
+				assert.NoErrorf(t, err, "Application %d failed to dial server: %s", i, err)
+				defer conn.Close()
+
+				err = conn.WriteJSON(app)
+				assert.NoErrorf(t, err, "Application %d failed to write data to server: %s", i, err)
+				authResponse := testHandleAuthResponse(t, conn)
+				assert.True(t, authResponse.Accepted, "Application %d should be accepted and is not: %s", i, authResponse.Message)
+
+				subscribe := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      1,
+					Method:  "Subscribe",
+					Params:  Subscribe_Params{Event: rpc.NewEntry},
+				}
+				_, serverErr, err := testXSWDCall(t, conn, subscribe)
+				assert.NoErrorf(t, err, "Subscribe %d should not error: %s", i, err)
+				assert.Nil(t, serverErr, "Subscribe %d should not have error: %v", i, serverErr)
+				conns = append(conns, conn)
+			}
+
+			// Application 0 sent the transaction
+			origin, found := server.GetApplicationByID(testAppData[0].Id)
+			assert.True(t, found, "Application 0 should be found")
+			txid := "c6a7b2a5a1e9f2e0ad3a4d6f8fd5f0c0b4e2dfc9e2b5a1f7e1ab0a9b8c7d6e5f"
+			server.trackTransaction(&origin, rpc.Transfer_Result{TXID: txid})
+
+			tests := []struct {
+				name     string
+				entry    rpc.Entry
+				received []bool
+			}{
+				// Unknown first as a timed out read breaks the connection
+				{"Unknown", rpc.Entry{TXID: "unknown"}, []bool{true, true}},
+				{"Origin", rpc.Entry{TXID: txid}, []bool{false, true}},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					testListener(xswdWallet, rpc.NewEntry, test.entry)
+
+					for i, conn := range conns {
+						conn.SetReadDeadline(time.Now().Add(sleep500))
+						_, _, err := conn.ReadMessage()
+						if test.received[i] {
+							assert.NoErrorf(t, err, "Application %d should receive event: %s", i, err)
+						} else {
+							assert.Error(t, err, "Application %d should not receive its own event", i)
+						}
+					}
+				})
+			}
+		},
+	},
+	// Test SessionAllow permissions are kept for the session only
+	{
+		name:       "SessionAllow",
+		permission: SessionAllow,
+		options:    []Option{WithForceAsk(false)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.True(t, SessionAllow.IsPositive(), "SessionAllow should be positive")
+			assert.Equal(t, "Session Allow", SessionAllow.String(), "SessionAllow string does not match")
+
+			// SessionAllow requested by application is never accepted
+			app := testAppData[1]
+			app.Permissions = map[string]Permission{"GetAddress": SessionAllow, "GetHeight": AlwaysDeny}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(app)
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			apps := server.GetApplications()
+			assert.Len(t, apps, 1, "There should be one application")
+			_, found := apps[0].Permissions["GetAddress"]
+			assert.False(t, found, "SessionAllow should not be accepted from application")
+			assert.Equal(t, AlwaysDeny, apps[0].Permissions["GetHeight"], "GetHeight should be AlwaysDeny")
+
+			for i, method := range []string{"GetAddress", "GetDaemon"} {
+				request := jsonrpc.RPCRequest{
+					JSONRPC: "2.0",
+					ID:      i,
+					Method:  method,
+				}
+
+				_, serverErr, err := testXSWDCall(t, conn, request)
+				assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+				assert.Nilf(t, serverErr, "Request %q should be allowed: %v", request.Method, serverErr)
+			}
+
+			apps = server.GetApplications()
+			assert.Equal(t, SessionAllow, apps[0].Permissions["GetAddress"], "GetAddress should be SessionAllow for the session")
+			_, found = apps[0].Permissions["GetDaemon"]
+			assert.False(t, found, "noStore method should not keep SessionAllow")
+
+			// New session starts without SessionAllow
+			conn.Close()
+			testWaitApplications(t, server, 0)
+
+			conn2, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn2.Close()
+
+			app.Permissions = map[string]Permission{}
+			err = conn2.WriteJSON(app)
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse = testHandleAuthResponse(t, conn2)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			apps = server.GetApplications()
+			assert.Len(t, apps, 1, "There should be one application")
+			assert.Empty(t, apps[0].Permissions, "SessionAllow should not persist to a new session")
+		},
+	},
+	// Test an application accepted while the server stops is never left connected and untracked
+	{
+		name:       "StopWhileAccepting",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			disconnected := make(chan DisconnectReason, 1)
+			server.onDisconnect = func(app *ApplicationData, reason DisconnectReason) {
+				disconnected <- reason
+			}
+
+			entered := make(chan struct{})
+			release := make(chan struct{})
+			server.appHandler = func(app *ApplicationData) bool {
+				close(entered)
+				<-release
+				return true
+			}
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+
+			select {
+			case <-entered:
+			case <-time.After(time.Second):
+				t.Fatal("appHandler should have been called")
+			}
+
+			// user accepts the application once the server is stopped
+			server.Stop()
+			close(release)
+
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			_, _, err = conn.ReadMessage()
+			assert.Error(t, err, "Application connection should be closed")
+			assert.Len(t, server.GetApplications(), 0, "Application should not be tracked once the server is stopped")
+
+			select {
+			case reason := <-disconnected:
+				t.Errorf("Application never tracked should not be reported disconnected: %s", reason)
+			case <-time.After(sleep50):
+			}
+		},
+	},
+	// Test StopGraceful answers in-flight requests before stopping
+	{
+		name:       "StopGracefulDrained",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			testStopGraceful(t, server, 200*time.Millisecond, 2*time.Second, true)
+		},
+	},
+	// Test StopGraceful stops the server once timed out
+	{
+		name:       "StopGracefulTimeout",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			testStopGraceful(t, server, 2*time.Second, 200*time.Millisecond, false)
+		},
+	},
+	// Test forwarded origin is only used behind a trusted proxy
+	{
+		name:       "TrustedProxy",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			// Proxy rewrites Origin and forwards the one of the application
+			headers := http.Header{}
+			headers.Set("Origin", "http://proxy.local")
+			headers.Set("X-Forwarded-Origin", testAppData[0].Url)
+			headers.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+
+			tests := []struct {
+				name     string
+				trusted  bool
+				accepted bool
+			}{
+				{"Untrusted", false, false},
+				{"Trusted", true, true},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					server.trustedProxy = test.trusted
+
+					r := &http.Request{Header: headers, RemoteAddr: "127.0.0.1:1234"}
+					if test.trusted {
+						assert.Equal(t, testAppData[0].Url, server.requestOrigin(r), "Origin should be forwarded one")
+						assert.Equal(t, "10.0.0.1", server.remoteAddr(r), "Remote address should be forwarded client")
+					} else {
+						assert.Equal(t, "http://proxy.local", server.requestOrigin(r), "Origin should be direct one")
+						assert.Equal(t, r.RemoteAddr, server.remoteAddr(r), "Remote address should be direct one")
+					}
+
+					conn, err := testCreateClient(headers)
+					assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+					defer conn.Close()
+
+					err = conn.WriteJSON(testAppData[0])
+					assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+					authResponse := testHandleAuthResponse(t, conn)
+					assert.Equal(t, test.accepted, authResponse.Accepted, "Application accepted does not match: %s", authResponse.Message)
+					server.RemoveAllApplications("proxy tested")
+				})
+			}
+		},
+	},
+	// Test unknown DERO. methods are not found while the daemon is offline, known ones are cancelled
+	{
+		name:       "OfflineDaemonMethods",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(testAppData[0])
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+			tests := []struct {
+				name    string
+				methods []string
+				method  string
+				code    code.Code
+			}{
+				{"Known", DefaultDaemonMethods, "DERO.GetInfo", code.Cancelled},
+				{"Unknown", DefaultDaemonMethods, "DERO.GetInf", code.MethodNotFound},
+				{"Configured", []string{"DERO.GetCustom"}, "DERO.GetCustom", code.Cancelled},
+				{"Replaced", []string{"DERO.GetCustom"}, "DERO.GetInfo", code.MethodNotFound},
+				{"Unchecked", nil, "DERO.Unknown", code.Cancelled},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					server.daemonMethods = test.methods
+
+					_, serverErr, err := testXSWDCall(t, conn, jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: test.method})
+					assert.NoErrorf(t, err, "Request %q should not give error: %s", test.method, err)
+					if assert.NotNil(t, serverErr, "Request %q should have error", test.method) {
+						assert.Equal(t, test.code, serverErr.Code, "Request %q should be %v: %v", test.method, test.code, serverErr.Code)
+					}
+
+					// close typos of daemon methods are suggested
+					if test.name == "Unknown" {
+						var data MethodNotFound_Data
+						err = json.Unmarshal(serverErr.Data, &data)
+						assert.NoErrorf(t, err, "Error data should unmarshal: %s", err)
+						assert.Contains(t, data.Suggestions, "DERO.GetInfo", "Known daemon method should be suggested")
+					}
+				})
+			}
+		},
+	},
+	// Test XSWD logs V(2) messages with the injected logger at its verbosity
+	{
+		name:       "Logger",
+		permission: Allow,
+		options:    []Option{WithLogger(logr.New(newTestLogSink(2)))},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			testLogger(t, server, true)
+		},
+	},
+	// Test XSWD filters V(2) messages with the injected logger at its verbosity
+	{
+		name:       "LoggerFiltered",
+		permission: Allow,
+		options:    []Option{WithLogger(logr.New(newTestLogSink(1)))},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			testLogger(t, server, false)
+		},
+	},
+	// Test handlers are shared in turn between sessions
+	{
+		name:       "MaxConcurrentHandlers",
+		permission: Allow,
+		options:    []Option{WithMaxConcurrentHandlers(1)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conns, entered, release := testBlockingHandlers(t, server)
+			assert.Equal(t, 1, cap(server.handlers), "Handlers limit does not match")
+
+			// first application queues its requests before the second one sends its own
+			for i := 1; i <= 3; i++ {
+				err := conns[0].WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: i, Method: "BlockA"})
+				assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+			}
+			assert.Eventually(t, func() bool { return len(entered()) == 1 }, time.Second, sleep10, "First request should be handled")
+
+			// second application waiting on the handler can't be observed
+			err := conns[1].WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "BlockB"})
+			assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+			time.Sleep(sleep50)
+
+			assert.Equal(t, []string{"BlockA"}, entered(), "Only one handler should run")
+
+			for i := 0; i < 3; i++ {
+				release <- struct{}{}
+				assert.Eventually(t, func() bool { return len(entered()) == i+2 }, time.Second, sleep10, "Next request should be handled")
+			}
+
+			assert.Equal(t, []string{"BlockA", "BlockB", "BlockA", "BlockA"}, entered(), "Second application should be handled before the queued requests of the first one")
+			close(release)
+		},
+	},
+	// Test handlers run concurrently up to their limit
+	{
+		name:       "MaxConcurrentHandlersConcurrent",
+		permission: Allow,
+		options:    []Option{WithMaxConcurrentHandlers(2)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conns, entered, release := testBlockingHandlers(t, server)
+			defer close(release)
+
+			for i, method := range []string{"BlockA", "BlockA", "BlockB"} {
+				conn := conns[0]
+				if method == "BlockB" {
+					conn = conns[1]
+				}
+
+				err := conn.WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: i + 1, Method: method})
+				assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+			}
+
+			// requests of a session are handled one at a time while the other session progresses
+			assert.Eventually(t, func() bool { return len(entered()) == 2 }, time.Second, sleep10, "Both applications should be handled concurrently")
+			assert.ElementsMatch(t, []string{"BlockA", "BlockB"}, entered(), "Both applications should be handled concurrently")
+		},
+	},
+	// Test a pending permission prompt doesn't take a handler
+	{
+		name:       "MaxConcurrentHandlersPrompt",
+		permission: Allow,
+		options:    []Option{WithMaxConcurrentHandlers(1)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			conns, _, release := testBlockingHandlers(t, server)
+			defer close(release)
+
+			prompted := make(chan struct{})
+			answer := make(chan Permission)
+			server.requestHandler = func(ad *ApplicationData, r *jrpc2.Request) Permission {
+				close(prompted)
+				return <-answer
+			}
+
+			err := conns[0].WriteJSON(jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "BlockA"})
+			assert.NoErrorf(t, err, "Application failed to write request: %s", err)
+
+			select {
+			case <-prompted:
+			case <-time.After(time.Second):
+				t.Fatal("User should be prompted")
+			}
+
+			// always allowed method of another application isn't blocked by the pending prompt
+			conns[1].SetReadDeadline(time.Now().Add(time.Second))
+			_, serverErr, err := testXSWDCall(t, conns[1], jsonrpc.RPCRequest{JSONRPC: "2.0", ID: 1, Method: "Ping"})
+			assert.NoErrorf(t, err, "Ping should not error while a prompt is pending: %s", err)
+			assert.Nil(t, serverErr, "Ping should not have error: %v", serverErr)
+
+			answer <- Deny
+		},
+	},
+	// Test listening on all interfaces
+	{
+		name:       "Host",
+		permission: Allow,
+		options:    []Option{WithHost("")},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			assert.Equal(t, fmt.Sprintf(":%d", XSWD_PORT), server.server.Addr, "Server should listen on all interfaces")
+
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			conn.Close()
+		},
+	},
+	// Test UTF-8 names and descriptions are only accepted when allowed
+	{
+		name:       "UTF8Metadata",
+		permission: Allow,
+		options:    []Option{WithUTF8Metadata(true)},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			accented := testAppData[0]
+			accented.Name = "Appé"
+			emoji := testAppData[15]
+			control := testAppData[0]
+			control.Name = "App\u0007"
+			long := testAppData[0]
+			long.Description = strings.Repeat("é", 256)
+			limit := testAppData[0]
+			limit.Description = strings.Repeat("é", 255)
+
+			tests := []struct {
+				name  string
+				app   ApplicationData
+				ascii ValidationStep
+				utf8  ValidationStep
+			}{
+				{"ASCII", testAppData[0], "", ""},
+				{"Accented", accented, ValidateName, ""},
+				{"Emoji", emoji, ValidateDescription, ""},
+				{"Control", control, "", ValidateName},
+				{"Long", long, ValidateDescription, ValidateDescription},
+				{"Limit", limit, ValidateDescription, ""},
+			}
+
+			for _, test := range tests {
+				step, response := server.checkMetadata(&test.app)
+				assert.Equal(t, test.utf8, step, "%s UTF-8 step does not match: %s", test.name, response)
+			}
+
+			// Accented application connects when UTF-8 is allowed
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			err = conn.WriteJSON(accented)
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.True(t, authResponse.Accepted, "Accented application should be accepted: %s", authResponse.Message)
+
+			// Only ASCII is accepted by default
+			server.allowUTF8 = false
+			for _, test := range tests {
+				step, response := server.checkMetadata(&test.app)
+				assert.Equal(t, test.ascii, step, "%s ASCII step does not match: %s", test.name, response)
+			}
+		},
+	},
+	// Test application Url compared to origin with each policy
+	{
+		name:       "OriginPolicy",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			origins := []string{"http://testapp0.com", "https://testapp0.com:8080", "http://cdn.testapp0.com"}
+			tests := []struct {
+				name     string
+				policy   OriginPolicy
+				accepted []bool
+			}{
+				{"Strict", OriginStrict, []bool{true, false, false}},
+				{"Prefix", OriginPrefix, []bool{true, true, false}},
+				{"Ignore", OriginIgnore, []bool{true, true, true}},
+			}
+
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					server.originPolicy = test.policy
+
+					for i, origin := range origins {
+						header := http.Header{}
+						header.Set("Origin", origin)
+						conn, err := testCreateClient(header)
+						assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+
+						err = conn.WriteJSON(testAppData[0])
+						assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+						authResponse := testHandleAuthResponse(t, conn)
+						assert.Equal(t, test.accepted[i], authResponse.Accepted, "Application with origin %s accepted should be %t: %s", origin, test.accepted[i], authResponse.Message)
+
+						server.RemoveAllApplications("origin tested")
+						conn.Close()
+					}
+				})
+			}
+		},
+	},
+	// Test SignData domain separation
+	{
+		name:       "SignDomain",
+		permission: Allow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			for _, enabled := range []bool{false, true} {
+				t.Run(fmt.Sprintf("%t", enabled), func(t *testing.T) {
+					server.signDomain = enabled
+
+					// Call the handler as handleMessage does
+					app := testAppData[0]
+					wallet_context := *server.context
+					wallet_context.Extra["app_data"] = &app
+					ctx := context.WithValue(context.Background(), "wallet_context", &wallet_context)
+
+					data := []byte("DERO")
+					result, err := SignData(ctx, data)
+					assert.NoErrorf(t, err, "SignData should not error: %s", err)
+
+					expected := data
+					if enabled {
+						assert.Equal(t, SignDomainTag(app.Id), result.Tag, "Tag should be the application tag")
+						assert.True(t, strings.HasPrefix(result.Tag, "XSWD:"), "Tag should be prefixed")
+						expected = append([]byte(result.Tag), data...)
+					} else {
+						assert.Empty(t, result.Tag, "Tag should be empty without domain separation")
+					}
+
+					signer, message, err := server.wallet.CheckSignature(result.Signature)
+					assert.NoErrorf(t, err, "CheckSignature should not error: %s", err)
+					assert.Equal(t, testWalletData[0].Address, signer.String(), "Signer does not match")
+					assert.Equal(t, signer.String(), result.Signer, "Result signer should be the signature signer")
+					assert.Equal(t, expected, message, "Signed message does not match")
+				})
+			}
+		},
+	},
+	// Test permissions requested at once are answered by permissionsHandler
+	{
+		name:       "PermissionsHandler",
+		permission: AlwaysAllow,
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			var requested []string
+			server.permissionsHandler = func(app *ApplicationData, methods []string) map[string]Permission {
+				requested = append(requested, methods...)
+				return map[string]Permission{"GetHeight": AlwaysDeny, "GetBalance": Allow}
+			}
+
+			conn := testConnectApplication(t, testAppData[0])
+
+			permissions, serverErr := testRequestPermissions(t, conn, []string{"GetHeight", "GetBalance"})
+			assert.Nil(t, serverErr, "RequestPermissions should not have error: %v", serverErr)
+			assert.Equal(t, map[string]Permission{"GetHeight": AlwaysDeny, "GetBalance": Ask}, permissions, "Permissions do not match")
+
+			// Stored permission is not requested again
+			_, serverErr = testRequestPermissions(t, conn, []string{"GetHeight", "GetBalance"})
+			assert.Nil(t, serverErr, "RequestPermissions should not have error: %v", serverErr)
+			assert.Equal(t, []string{"GetHeight", "GetBalance", "GetBalance"}, requested, "Requested methods do not match")
+		},
+	},
+	// Test user answers taking longer than the handler timeout are kept
+	{
+		name:       "PermissionsHandlerTimeout",
+		permission: AlwaysAllow,
+		options:    []Option{WithHandlerTimeout(sleep25), WithHandlerTimeout(sleep25, "RequestPermissions")},
+		run: func(t *testing.T, xswdWallet *walletapi.Wallet_Disk, server *XSWD) {
+			server.permissionsHandler = func(app *ApplicationData, methods []string) map[string]Permission {
+				time.Sleep(sleep50 * 2)
+				return map[string]Permission{"GetHeight": AlwaysAllow}
+			}
+			assert.Zero(t, server.handlerTimeoutOf("RequestPermissions"), "RequestPermissions should not be timed out")
+
+			conn := testConnectApplication(t, testAppData[0])
+
+			permissions, serverErr := testRequestPermissions(t, conn, []string{"GetHeight"})
+			assert.Nil(t, serverErr, "RequestPermissions should not have error: %v", serverErr)
+			assert.Equal(t, map[string]Permission{"GetHeight": AlwaysAllow}, permissions, "Permissions do not match")
+		},
+	},
+}
+
+// Test NewXSWDServer defaults and options
+func TestXSWDOptions(t *testing.T) {
+	xswdWallet, server, err := testNewXSWDServer(t, false, true, Allow)
+	assert.NoErrorf(t, err, "testNewXSWDServer should not error: %s", err)
+
+	// Defaults without options
+	assert.Equal(t, XSWD_PORT, server.port, "Default port should be %d", XSWD_PORT)
+	assert.Equal(t, DefaultHost, server.host, "Default host should be %s", DefaultHost)
+	assert.Equal(t, fmt.Sprintf("%s:%d", DefaultHost, XSWD_PORT), server.server.Addr, "Server should listen on localhost")
+	assert.True(t, server.forceAsk, "Default should be forceAsk")
+	assert.Equal(t, DefaultNoStore, server.noStore, "Default noStore methods do not match")
+	assert.Equal(t, DefaultRateLimit, server.rateLimit, "Default rate limit does not match")
+	assert.Equal(t, DefaultRateBurst, server.rateBurst, "Default rate burst does not match")
+	server.Stop()
+
+	port := XSWD_PORT + 1
+	appHandler := func(app *ApplicationData) bool { return true }
+	requestHandler := func(app *ApplicationData, request *jrpc2.Request) Permission { return Allow }
+	server = NewXSWDServer(xswdWallet, appHandler, requestHandler, WithPort(port), WithForceAsk(false), WithNoStore("GetAddress"), WithRateLimit(5, 10))
+	t.Cleanup(server.Stop)
+	err = testWaitListening(server, false)
+	assert.NoErrorf(t, err, "XSWD server should be listening: %s", err)
+	assert.True(t, server.IsRunning(), "XSWD server should be running and is not")
+
+	assert.Equal(t, port, server.port, "Port should be %d", port)
+	assert.False(t, server.forceAsk, "Should not be forceAsk")
+	assert.False(t, server.CanStorePermission("GetAddress"), "GetAddress should be noStore")
+	assert.True(t, server.CanStorePermission("SignData"), "SignData should not be noStore")
+	assert.True(t, server.IsNoStore("GetAddress"), "GetAddress should be noStore")
+	assert.False(t, server.IsNoStore("SignData"), "SignData should not be noStore")
+	assert.Equal(t, []string{"GetAddress"}, server.NoStoreMethods(), "noStore methods do not match")
+	server.NoStoreMethods()[0] = "SignData"
+	assert.True(t, server.IsNoStore("GetAddress"), "noStore methods should not be modified from their copy")
+
+	u := url.URL{Scheme: "ws", Host: fmt.Sprintf("127.0.0.1:%d", port), Path: "/xswd"}
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	assert.NoErrorf(t, err, "Application failed to dial server on port %d: %s", port, err)
+	defer conn.Close()
+
+	err = conn.WriteJSON(testAppData[0])
+	assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+	authResponse := testHandleAuthResponse(t, conn)
+	assert.True(t, authResponse.Accepted, "Application should be accepted and is not")
+
+	for _, app := range server.applications {
+		assert.Equal(t, rate.Limit(5), app.limiter.Limit(), "Application rate limit does not match")
+		assert.Equal(t, 10, app.limiter.Burst(), "Application rate burst does not match")
+	}
+
+	// Application can read its own rate limit state
+	var result GetRateLimit_Result
+	request := jsonrpc.RPCRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "GetRateLimit",
+	}
+	response, serverErr, err := testXSWDCall(t, conn, request)
+	assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+	assert.Nil(t, serverErr, "Response should not have error: %v", serverErr)
+	js, err := json.Marshal(response.Result)
+	assert.NoErrorf(t, err, "Response marshal should not error: %s", err)
+	err = json.Unmarshal(js, &result)
+	assert.NoErrorf(t, err, "Response unmarshal should not error: %s", err)
+	assert.Equal(t, float64(5), result.Limit, "Rate limit does not match")
+	assert.Equal(t, 10, result.Burst, "Rate burst does not match")
+	assert.Less(t, result.Tokens, float64(10), "Tokens should have been consumed by request")
+}
+
+// Test applications targeting a named wallet are routed to it and receive its events
+func TestXSWDMultipleWallets(t *testing.T) {
+	second, err := walletapi.Create_Encrypted_Wallet_Random(t.TempDir()+"/xswd_second_wallet.db", "xswd")
+	assert.NoErrorf(t, err, "Create second wallet should not error: %s", err)
+
+	xswdWallet, server, err := testNewXSWDServerWithOptions(t, true, Allow, WithWallet("second", second), WithWallet("", second))
+	assert.NoErrorf(t, err, "testNewXSWDServerWithOptions should not error: %s", err)
+	t.Cleanup(server.Stop)
+
+	assert.Equal(t, []string{"second"}, server.WalletNames(), "Only named wallet should be registered")
+	assert.True(t, server.HasWallet(""), "Default wallet should always be available")
+	assert.False(t, server.HasWallet("unknown"), "Unknown wallet should not be available")
+
+	request := jsonrpc.RPCRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "GetAddress",
+	}
+
+	tests := []struct {
+		name     string
+		wallet   string
+		accepted bool
+		address  string
+	}{
+		{"Unknown", "unknown", false, ""},
+		{"Default", "", true, testWalletData[0].Address},
+		{"Named", "second", true, second.GetAddress().String()},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, err := testCreateClient(nil)
+			assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+			defer conn.Close()
+
+			app := testAppData[0]
+			app.Wallet = test.wallet
+			err = conn.WriteJSON(app)
+			assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+			authResponse := testHandleAuthResponse(t, conn)
+			assert.Equal(t, test.accepted, authResponse.Accepted, "Application accepted should be %t: %s", test.accepted, authResponse.Message)
+			if !test.accepted {
+				assert.Contains(t, authResponse.Message, "Wallet not registered", "Rejection message does not match")
+				return
+			}
+
+			response, serverErr, err := testXSWDCall(t, conn, request)
+			assert.NoErrorf(t, err, "Request should not error: %s", err)
+			assert.Nil(t, serverErr, "Request should not have error: %v", serverErr)
+			if result, ok := response.Result.(map[string]interface{}); assert.True(t, ok, "Result should be a map") {
+				assert.Equal(t, test.address, result["address"], "Address should be the one of the targeted wallet")
+			}
+
+			server.RemoveAllApplications("wallet tested")
+		})
+	}
+
+	// Derived events are broadcasted to the applications of the named wallet
+	t.Run("Events", func(t *testing.T) {
+		conn, err := testCreateClient(nil)
+		assert.NoErrorf(t, err, "Application failed to dial server: %s", err)
+		defer conn.Close()
+
+		app := testAppData[0]
+		app.Wallet = "second"
+		err = conn.WriteJSON(app)
+		assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+		authResponse := testHandleAuthResponse(t, conn)
+		assert.True(t, authResponse.Accepted, "Application should be accepted and is not: %s", authResponse.Message)
+
+		subscribe := jsonrpc.RPCRequest{
+			JSONRPC: "2.0",
+			ID:      1,
+			Method:  "Subscribe",
+			Params:  Subscribe_Params{Event: rpc.WalletHeight},
+		}
+		_, serverErr, err := testXSWDCall(t, conn, subscribe)
+		assert.NoErrorf(t, err, "Subscribe should not error: %s", err)
+		assert.Nil(t, serverErr, "Subscribe should not have error: %v", serverErr)
+
+		// default wallet height is not sent to the application of the named wallet
+		testListener(xswdWallet, rpc.NewTopoheight, int64(600))
+		testListener(second, rpc.NewTopoheight, int64(600))
+
+		_, message, err := conn.ReadMessage()
+		assert.NoErrorf(t, err, "Read should not error: %s", err)
+
+		var event RPCResponse
+		err = json.Unmarshal(message, &event)
+		assert.NoErrorf(t, err, "Unmarshal event should not error: %s", err)
+		js, err := json.Marshal(event.Result)
+		assert.NoErrorf(t, err, "Marshal event should not error: %s", err)
+
+		var notification struct {
+			Event rpc.EventType `json:"event"`
+			Value uint64        `json:"value"`
+		}
+		err = json.Unmarshal(js, &notification)
+		assert.NoErrorf(t, err, "Unmarshal notification should not error: %s", err)
+		assert.Equal(t, rpc.EventType(rpc.WalletHeight), notification.Event, "Event should be %s: %s", rpc.WalletHeight, notification.Event)
+		assert.Equal(t, second.Get_Height(), notification.Value, "Wallet height should be the one of the named wallet")
+
+		// Named wallet height is not changing
+		testListener(second, rpc.NewTopoheight, int64(601))
+		conn.SetReadDeadline(time.Now().Add(sleep500))
+		_, _, err = conn.ReadMessage()
+		assert.Error(t, err, "Unchanged wallet height should not be broadcasted")
+	})
+}
+
+// Create a testnet wallet and start XSWD server for tests
+// If port, server will use NewXSWDServerWithPort w/ !forceAsk, otherwise will use NewXSWDServer
+// Simulate initial appHandler and requestHandler values
+func testNewXSWDServer(t *testing.T, port, aHandler bool, rHandler Permission) (xswdWallet *walletapi.Wallet_Disk, server *XSWD, err error) {
+	xswdWallet, err = walletapi.Create_Encrypted_Wallet_From_Recovery_Words("xswd_text_wallet.db", "xswd", testWalletData[0].seed)
+	if err != nil {
+		return
+	}
+
+	// Simulate user accepting or denying the application connection request
+	appHandler := func(app *ApplicationData) bool { return aHandler }
+
+	// Simulate user permission when requestHandler is called
+	requestHandler := func(app *ApplicationData, request *jrpc2.Request) Permission { return rHandler }
+
+	// Server should stop itself if the port is already in use
+	inUse := testPortInUse()
+	if port {
+		// Test noStore methods outside NewXSWDServer() defaults
+		testNoStores := []string{"MakeIntegratedAddress"}
+		// NewXSWDServerWithPort will use !forceAsk to allow permission requests
+		server = NewXSWDServerWithPort(XSWD_PORT, xswdWallet, false, testNoStores, appHandler, requestHandler)
+		t.Logf("Starting NewXSWDServerWithPort: [port: %d, appHandler: %t, requestHandler: %s]", XSWD_PORT, aHandler, rHandler.String())
+
+	} else {
+		// NewXSWDServer defaults all permissions to Ask, noStore methods are all xswd methods
+		server = NewXSWDServer(xswdWallet, appHandler, requestHandler)
+		t.Logf("Starting NewXSWDServer: [appHandler: %t, requestHandler: %s]", aHandler, rHandler.String())
+	}
+
+	// Wait for the server to start
+	if err = testWaitListening(server, inUse); err != nil {
+		return nil, nil, err
+	}
+
+	if !server.IsRunning() {
+		return nil, nil, fmt.Errorf("server is not running and should be")
+	}
+
+	return
+}
+
+// Create a testnet wallet and start XSWD server with options for tests
+// Simulate initial appHandler and requestHandler values
+func testNewXSWDServerWithOptions(t *testing.T, aHandler bool, rHandler Permission, opts ...Option) (xswdWallet *walletapi.Wallet_Disk, server *XSWD, err error) {
+	xswdWallet, err = walletapi.Create_Encrypted_Wallet_From_Recovery_Words("xswd_text_wallet.db", "xswd", testWalletData[0].seed)
+	if err != nil {
+		return
+	}
+
+	appHandler := func(app *ApplicationData) bool { return aHandler }
+	requestHandler := func(app *ApplicationData, request *jrpc2.Request) Permission { return rHandler }
+
+	inUse := testPortInUse()
+	server = NewXSWDServer(xswdWallet, appHandler, requestHandler, opts...)
+	t.Logf("Starting NewXSWDServer with %d options: [appHandler: %t, requestHandler: %s]", len(opts), aHandler, rHandler.String())
+
+	// Wait for the server to start
+	if err = testWaitListening(server, inUse); err != nil {
+		return nil, nil, err
+	}
+
+	if !server.IsRunning() {
+		return nil, nil, fmt.Errorf("server is not running and should be")
+	}
+
+	return
+}
+
+// Wait until the server accepts connections,
+// if its port was already in use wait until the server stops itself
+func testWaitListening(server *XSWD, inUse bool) error {
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(sleep10) {
+		if inUse {
+			if !server.IsRunning() {
+				return nil
+			}
+		} else if conn, err := net.DialTimeout("tcp", server.server.Addr, sleep50); err == nil {
+			return conn.Close()
+		}
+	}
+
+	return fmt.Errorf("server is not listening")
+}
+
+// Check if the XSWD port is already used by a server
+func testPortInUse() bool {
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", XSWD_PORT), sleep50)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+
+	return true
+}
+
+// Create client for XSWD server tests
+func testCreateClient(headers http.Header) (conn *websocket.Conn, err error) {
+	u := url.URL{Scheme: "ws", Host: "127.0.0.1:44326", Path: "/xswd"}
+	conn, _, err = websocket.DefaultDialer.Dial(u.String(), headers)
+
+	return
+}
+
+// Connect an application to the XSWD server for tests, the connection is closed once the test is done
+func testConnectApplication(t *testing.T, app ApplicationData) *websocket.Conn {
+	conn, err := testCreateClient(nil)
+	if err != nil {
+		t.Fatalf("Application failed to dial server: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	err = conn.WriteJSON(app)
+	assert.NoErrorf(t, err, "Application failed to write data to server: %s", err)
+	authResponse := testHandleAuthResponse(t, conn)
+	assert.True(t, authResponse.Accepted, "Application should be accepted and is not: %s", authResponse.Message)
+
+	return conn
+}
+
+// Wait until the server has n applications, such as once closed sessions are removed
+func testWaitApplications(t *testing.T, server *XSWD, n int) bool {
+	return assert.Eventually(t, func() bool { return server.ApplicationCount() == n }, time.Second, sleep10, "There should be %d applications", n)
+}
+
+// Request the permissions of methods at once for tests
+func testRequestPermissions(t *testing.T, conn *websocket.Conn, methods []string) (map[string]Permission, *jrpc2.Error) {
+	request := jsonrpc.RPCRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "RequestPermissions",
+		Params:  RequestPermissions_Params{Methods: methods},
+	}
+	response, serverErr, err := testXSWDCall(t, conn, request)
+	assert.NoErrorf(t, err, "RequestPermissions should not error: %s", err)
+	if serverErr != nil {
+		return nil, serverErr
+	}
+
+	var result RequestPermissions_Result
+	js, err := json.Marshal(response.Result)
+	assert.NoErrorf(t, err, "RequestPermissions result marshal should not error: %s", err)
+	err = json.Unmarshal(js, &result)
+	assert.NoErrorf(t, err, "RequestPermissions result unmarshal should not error: %s", err)
+
+	return result.Permissions, nil
+}
+
+// Register BlockA and BlockB methods waiting on release and connect two applications for handlers tests
+func testBlockingHandlers(t *testing.T, server *XSWD) (conns []*websocket.Conn, entered func() []string, release chan struct{}) {
+	var mutex sync.Mutex
+	var calls []string
+	release = make(chan struct{})
+	for _, method := range []string{"BlockA", "BlockB"} {
+		method := method
+		server.SetCustomMethod(method, handler.New(func(ctx context.Context) bool {
+			mutex.Lock()
+			calls = append(calls, method)
+			mutex.Unlock()
+			<-release
+			return true
+		}))
+	}
+
+	entered = func() []string {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return append([]string{}, calls...)
+	}
+
+	conns = append(conns, testConnectApplication(t, testAppData[0]), testConnectApplication(t, testAppData[2]))
+
+	return
+}
+
+// Test the V(2) logs of a server started with a testLogSink
+func testLogger(t *testing.T, server *XSWD, logged bool) {
+	sink := server.logger.GetSink().(testLogSink)
+
+	testConnectApplication(t, testAppData[0])
+
+	assert.True(t, sink.Logged("Starting XSWD server"), "Info logs should use the injected logger")
+	assert.Equal(t, logged, sink.Logged("New WebSocket connection"), "V(2) logs should follow the injected logger verbosity")
+}
+
+// Test StopGraceful with an in-flight request taking duration
+func testStopGraceful(t *testing.T, server *XSWD, duration, timeout time.Duration, handled bool) {
+	started := make(chan struct{})
+	server.SetCustomMethod("Slow", handler.New(func(ctx context.Context) bool {
+		close(started)
+		time.Sleep(duration)
+		return true
+	}))
+
+	conn := testConnectApplication(t, testAppData[0])
+
+	request := jsonrpc.RPCRequest{
+		JSONRPC: "2.0",
+		ID:      1,
+		Method:  "Slow",
+	}
+	err := conn.WriteJSON(request)
+	assert.NoErrorf(t, err, "Request %q should not error: %s", request.Method, err)
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("Request should be in-flight")
+	}
+
+	start := time.Now()
+	server.StopGraceful(timeout)
+	assert.False(t, server.IsRunning(), "XSWD server should not be running")
+	assert.Less(t, time.Since(start), timeout+time.Second, "StopGraceful should not wait longer than timeout")
+
+	var response RPCResponse
+	err = conn.ReadJSON(&response)
+	if handled {
+		assert.NoErrorf(t, err, "In-flight request should be answered before stopping: %s", err)
+		assert.Equal(t, true, response.Result, "In-flight request result should be true")
+	} else {
+		assert.Error(t, err, "Connection should be closed before request is answered")
+	}
+}
+
+// Handle XSWD authentication response for tests
+func testHandleAuthResponse(t *testing.T, conn *websocket.Conn) (response AuthorizationResponse) {
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to receive authorization response: %s", err)
+	}
+
+	err = json.Unmarshal(message, &response)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal authorization response: %s", err)
+	}
+
+	return
+}
+
+// Call and read test requests to XSWD server
+func testXSWDCall(t *testing.T, conn *websocket.Conn, request interface{}) (response RPCResponse, jrpcErr *jrpc2.Error, err error) {
+	method := "unknown"
+	switch r := request.(type) {
+	case jsonrpc.RPCRequest:
+		method = r.Method
+	}
+
+	err = conn.WriteJSON(request)
+	if err != nil {
+		err = fmt.Errorf("failed to write %s request: %s", method, err)
+		return
+	}
+
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		err = fmt.Errorf("failed to receive %s response: %s", method, err)
+		return
+	}
+
+	err = json.Unmarshal(message, &response)
+	if err != nil {
+		err = fmt.Errorf("failed to unmarshal %s response: %s", method, err)
+		return
+	}
+	// t.Logf("%s response: %v", method, response)
+
+	// Parse server response error
+	var result []byte
+	result, err = json.Marshal(response.Error)
+	if err != nil {
+		err = fmt.Errorf("could not marshal error result: %s", err)
+		return
+	}
+
+	err = json.Unmarshal(result, &jrpcErr)
+	if err != nil {
+		err = fmt.Errorf("could not unmarshal error result to jrpc2.Error: %s", err)
+	}
+
+	return
+}
+
+// Test calling added listeners from account
+func testListener(xswdWallet *walletapi.Wallet_Disk, event rpc.EventType, value interface{}) {
+	if listeners, ok := xswdWallet.GetAccount().EventListeners[event]; ok {
+		for _, listener := range listeners {
+			listener(value)
+		}
+	}
+}
+
+// Fake daemon answering DERO. requests with canned results
+type testDaemon struct {
+	jrpc2server.Local
+}
+
+func (d testDaemon) IsOnline() bool {
+	return true
+}
+
+func (d testDaemon) Call(ctx context.Context, method string, params interface{}) (*jrpc2.Response, error) {
+	return d.Client.Call(ctx, method, params)
+}
+
+// Create a fake daemon closed when the test ends
+func testNewDaemon(t *testing.T) testDaemon {
+	daemon := testDaemon{jrpc2server.NewLocal(handler.Map{
+		"DERO.Ping": handler.New(func(ctx context.Context) string {
+			return "Pong "
+		}),
+		"DERO.GetInfo": handler.New(func(ctx context.Context) rpc.GetInfo_Result {
+			return rpc.GetInfo_Result{Height: 100, Total_Supply: 21000000, Network: "Mainnet", Version: "test"}
+		}),
+		"DERO.GetHeight": handler.New(func(ctx context.Context) rpc.GetHeight_Result {
+			return rpc.GetHeight_Result{Height: 100}
+		}),
+		"DERO.GetRandomAddress": handler.New(func(ctx context.Context) rpc.GetRandomAddress_Result {
+			return rpc.GetRandomAddress_Result{Address: []string{testWalletData[0].Address}}
+		}),
+		"DERO.GetBlock": handler.New(func(ctx context.Context, p rpc.GetBlock_Params) rpc.GetBlock_Result {
+			return rpc.GetBlock_Result{Status: "OK"}
+		}),
+	}, nil)}
+	t.Cleanup(func() { daemon.Close() })
+
+	return daemon
+}
+
+// Log sink recording the messages of enabled levels
+type testLogSink struct {
+	level    int
+	mutex    *sync.Mutex
+	messages *[]string
+}
+
+func newTestLogSink(level int) testLogSink {
+	return testLogSink{level: level, mutex: new(sync.Mutex), messages: new([]string)}
+}
+
+func (s testLogSink) Init(info logr.RuntimeInfo) {}
+
+func (s testLogSink) Enabled(level int) bool {
+	return level <= s.level
+}
+
+func (s testLogSink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	*s.messages = append(*s.messages, msg)
+}
+
+func (s testLogSink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.Info(0, msg, keysAndValues...)
+}
+
+func (s testLogSink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return s
+}
+
+func (s testLogSink) WithName(name string) logr.LogSink {
+	return s
+}
+
+// Check if msg was logged
+func (s testLogSink) Logged(msg string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, m := range *s.messages {
+		if m == msg {
+			return true
+		}
+	}
+
+	return false
 }